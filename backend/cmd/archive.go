@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sharex/internal/archive"
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+)
+
+// exportCommand writes an archive of the library that another instance can
+// import with importCommand or /api/import
+func exportCommand(cfg *config.Config, db *storage.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	opts := archive.ExportOptions{}
+	fs.StringVar(&opts.Type, "type", "", "only export this type: image, gif or an extension")
+	fs.StringVar(&opts.DateFrom, "from", "", "only export images uploaded on or after this date")
+	fs.StringVar(&opts.DateTo, "to", "", "only export images uploaded on or before this date")
+	fs.BoolVar(&opts.Views, "views", false, "include view counts per day and country")
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	keyring, err := encryption.LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename at the end, so an interrupted
+	// export does not leave a truncated archive behind
+	tmp := rest[0] + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	manifest, err := archive.New(cfg, db, keyring, nil).Export(file, opts, printProgress("Exported"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, rest[0]); err != nil {
+		return err
+	}
+
+	for uuid, reason := range manifest.Skipped {
+		fmt.Printf("skipped  %s: %s\n", uuid, reason)
+	}
+	fmt.Printf("Exported %d images to %s, skipped %d\n", len(manifest.Images), rest[0], len(manifest.Skipped))
+	return audit(db, models.AuditLibraryExport, "library", "", nil, map[string]interface{}{
+		"images": len(manifest.Images),
+		"type":   opts.Type,
+		"from":   opts.DateFrom,
+		"to":     opts.DateTo,
+		"views":  opts.Views,
+	})
+}
+
+// importCommand adds the images of an archive made by exportCommand or
+// /api/export
+func importCommand(cfg *config.Config, db *storage.DB, logger *utils.Logger, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	conflict := fs.String("conflict", archive.ConflictSkip, "what to do when a UUID is taken: skip, rename or keep")
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	keyring, err := encryption.LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	scanner, err := upload.NewScanner(cfg)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(rest[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	report, importErr := archive.New(cfg, db, keyring, scanner).Import(file, info.Size(), archive.ImportOptions{Conflict: *conflict}, printProgress("Imported"))
+	if report == nil {
+		return importErr
+	}
+
+	for _, result := range report.Results {
+		switch result.Outcome {
+		case "skipped":
+			fmt.Printf("skipped  %s: %s\n", result.UUID, result.Reason)
+		case "renamed":
+			fmt.Printf("renamed  %s -> %s: %s\n", result.UUID, result.Stored, result.Reason)
+		default:
+			fmt.Printf("%-8s %s\n", result.Outcome, result.UUID)
+		}
+	}
+	logger.Info("Imported archive", map[string]interface{}{
+		"file":   rest[0],
+		"source": report.Source,
+		"counts": report.Counts,
+	})
+	fmt.Printf("Imported %d of %d images from %s, skipped %d\n",
+		report.Counts["imported"]+report.Counts["renamed"]+report.Counts["replaced"], report.Total, report.Source, report.Counts["skipped"])
+
+	if err := audit(db, models.AuditLibraryImport, "library", report.Source, nil, map[string]interface{}{
+		"conflict": *conflict,
+		"counts":   report.Counts,
+	}); err != nil {
+		return err
+	}
+	return importErr
+}
+
+// printProgress reports transfer progress on stderr, keeping stdout for the
+// summary
+func printProgress(verb string) archive.Progress {
+	return func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\r%s %d/%d images", verb, done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"sharex/internal/backup"
+	"sharex/internal/config"
+	"sharex/internal/size"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// backupCommand takes, lists and verifies backups in backup.dir. Taking one
+// is safe while the server is running.
+func backupCommand(cfg *config.Config, db *storage.DB, logger *utils.Logger, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "run":
+		if _, err := parseFlags(flag.NewFlagSet("backup run", flag.ContinueOnError), args[1:], 0); err != nil {
+			return err
+		}
+		manifest, err := backup.NewManager(cfg, db, logger).Run()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created backup %s: %d files, %d copied, %d linked from %s\n",
+			manifest.Name, len(manifest.Files), manifest.Copied, manifest.Linked, baseName(manifest.Base))
+		return nil
+	case "list":
+		return listBackups(cfg, args[1:])
+	case "verify":
+		rest, err := parseFlags(flag.NewFlagSet("backup verify", flag.ContinueOnError), args[1:], 1)
+		if err != nil {
+			return err
+		}
+		dir, err := backup.Resolve(cfg.Backup.Dir, rest[0])
+		if err != nil {
+			return err
+		}
+		manifest, problems, err := backup.Verify(dir)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("backup %s failed verification with %d problems", manifest.Name, len(problems))
+		}
+		fmt.Printf("Backup %s is intact: database and %d files match the manifest\n", manifest.Name, len(manifest.Files))
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+func listBackups(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backup list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if cfg.Backup.Dir == "" {
+		return backup.ErrDisabled
+	}
+
+	names, err := backup.List(cfg.Backup.Dir)
+	if err != nil {
+		return err
+	}
+	manifests := []*backup.Manifest{}
+	for _, name := range names {
+		manifest, err := backup.ReadManifest(filepath.Join(cfg.Backup.Dir, name))
+		if err != nil {
+			return fmt.Errorf("backup %s: %w", name, err)
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	if *asJSON {
+		type backupSummary struct {
+			Name      string    `json:"name"`
+			CreatedAt time.Time `json:"created_at"`
+			Files     int       `json:"files"`
+			Copied    int       `json:"copied"`
+			Size      int64     `json:"size"`
+		}
+		summaries := make([]backupSummary, len(manifests))
+		for i, m := range manifests {
+			summaries[i] = backupSummary{m.Name, m.CreatedAt, len(m.Files), m.Copied, m.Size()}
+		}
+		return printJSON(summaries)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCREATED\tFILES\tCOPIED\tSIZE")
+	for _, m := range manifests {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", m.Name, m.CreatedAt.Local().Format(time.DateTime), len(m.Files), m.Copied, size.Format(m.Size()))
+	}
+	return tw.Flush()
+}
+
+// restoreCommand replaces the database and stored files with a backup after
+// verifying it. It runs before the database is opened, and the server must
+// be stopped.
+func restoreCommand(cfg *config.Config, logger *utils.Logger, args []string) error {
+	rest, err := parseFlags(flag.NewFlagSet("restore", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	dir, err := backup.Resolve(cfg.Backup.Dir, rest[0])
+	if err != nil {
+		return err
+	}
+
+	manifest, err := backup.Restore(cfg, dir)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Restored backup", map[string]interface{}{
+		"name":  manifest.Name,
+		"files": len(manifest.Files),
+	})
+	fmt.Printf("Restored backup %s taken %s: database and %d files\n", manifest.Name, manifest.CreatedAt.Local().Format(time.DateTime), len(manifest.Files))
+	fmt.Printf("The previous database was kept as %s.pre-restore. Run fsck to find files that are not part of the backup.\n", cfg.Database.File)
+	return nil
+}
+
+func baseName(base string) string {
+	if base == "" {
+		return "no earlier backup"
+	}
+	return base
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"sharex/internal/models"
+	"sharex/internal/storage"
+)
+
+// cliActor is recorded as the actor of audit events caused by commands
+const cliActor = "cli"
+
+// errUsage is returned for malformed command lines
+var errUsage = errors.New("invalid arguments, run 'simp-server help' for usage")
+
+// parseFlags parses the flags of a subcommand and returns the positional
+// arguments, which must number exactly want
+func parseFlags(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() != want {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// readPassword reads a password from the first line of stdin, prompting when
+// stdin is a terminal
+func readPassword(prompt string) (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	return password, nil
+}
+
+// audit records an administrative action taken from the command line
+func audit(db *storage.DB, action, targetType, target string, before, after interface{}) error {
+	event := &models.AuditEvent{
+		Actor:      cliActor,
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Before:     auditValue(before),
+		After:      auditValue(after),
+	}
+	if err := db.RecordAuditEvent(event); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func auditValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
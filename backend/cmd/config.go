@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"sharex/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configCommand prints the effective configuration, after environment
+// overrides and defaults, with secrets redacted. Loading it has already
+// validated it.
+func configCommand(cfg *config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return errUsage
+	}
+
+	fmt.Printf("# Effective configuration from %s, secrets are redacted\n", cfg.Path())
+	if overrides := cfg.EnvOverrides(); len(overrides) > 0 {
+		fmt.Printf("# Environment overrides: %s\n", strings.Join(overrides, ", "))
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"sharex/internal/config"
+	"sharex/internal/size"
+	"sharex/internal/storage"
+)
+
+// dbCommand maintains the database
+func dbCommand(cfg *config.Config, db *storage.DB, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "migrate":
+		// Opening the database already brought the schema up to date, this
+		// lets operators do it ahead of starting a new version
+		if _, err := parseFlags(flag.NewFlagSet("db migrate", flag.ContinueOnError), args[1:], 0); err != nil {
+			return err
+		}
+		fmt.Printf("Database %s is up to date\n", databaseName(cfg))
+		return nil
+	case "vacuum":
+		if _, err := parseFlags(flag.NewFlagSet("db vacuum", flag.ContinueOnError), args[1:], 0); err != nil {
+			return err
+		}
+		if cfg.Database.Driver != config.DatabaseSQLite {
+			if err := db.Vacuum(); err != nil {
+				return err
+			}
+			fmt.Printf("Vacuumed %s\n", databaseName(cfg))
+			return nil
+		}
+
+		before, err := os.Stat(cfg.Database.File)
+		if err != nil {
+			return err
+		}
+		if err := db.Vacuum(); err != nil {
+			return err
+		}
+		after, err := os.Stat(cfg.Database.File)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Vacuumed %s: %s -> %s\n", cfg.Database.File, size.Format(before.Size()), size.Format(after.Size()))
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+// databaseName describes the database without the password a connection URL
+// may hold
+func databaseName(cfg *config.Config) string {
+	if cfg.Database.Driver != config.DatabasePostgres {
+		return cfg.Database.File
+	}
+	u, err := url.Parse(cfg.Database.URL)
+	if err != nil || u.Scheme == "" {
+		return "PostgreSQL database"
+	}
+	return u.Redacted()
+}
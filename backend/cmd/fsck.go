@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/fsck"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// fsckCommand reconciles image records with the storage path. Without -repair
+// it only reports. It fails when issues remain so it can be used in scripts.
+func fsckCommand(cfg *config.Config, db *storage.DB, logger *utils.Logger, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	checksums := fs.Bool("checksums", false, "read every file and compare checksums")
+	repair := fs.String("repair", "", "comma separated repair actions")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	opts := fsck.Options{Checksums: *checksums}
+	for _, action := range strings.Split(*repair, ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			opts.Repair = append(opts.Repair, action)
+		}
+	}
+	if err := config.ValidateRepairActions(opts.Repair); err != nil {
+		return err
+	}
+
+	keyring, err := encryption.LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := fsck.New(cfg, db, keyring, logger).Run(opts)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Repair) > 0 {
+		if err := audit(db, models.AuditStorageRepair, "storage", "", nil, map[string]interface{}{
+			"repair":             opts.Repair,
+			"repaired":           report.Repaired(),
+			"checksums_recorded": report.ChecksumsRecorded,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tUUID\tPATH\tDETAIL\tREPAIR")
+		for _, issue := range report.Issues {
+			repaired := issue.Repair
+			if issue.RepairError != "" {
+				repaired = "failed: " + issue.RepairError
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", issue.Kind, issue.UUID, issue.Path, issue.Detail, repaired)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("Checked %d images and %d files, %d issues\n", report.Images, report.Files, len(report.Issues))
+		if report.ChecksumsRecorded > 0 {
+			fmt.Printf("Recorded %d missing checksums\n", report.ChecksumsRecorded)
+		}
+	}
+
+	remaining := 0
+	for _, issue := range report.Issues {
+		if issue.Repair == "" {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d issues were not repaired", remaining)
+	}
+	return nil
+}
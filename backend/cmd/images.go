@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+)
+
+// imageCommand imports and deletes stored images
+func imageCommand(cfg *config.Config, db *storage.DB, logger *utils.Logger, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "import":
+		return importImages(cfg, db, logger, args[1:])
+	case "delete":
+		return deleteImage(cfg, db, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// importer stores files from disk the way /api/upload stores uploads
+type importer struct {
+	config   *config.Config
+	db       *storage.DB
+	keyring  *encryption.Keyring
+	scanner  upload.Scanner
+	uuidRe   *regexp.Regexp
+	maxSize  int64
+	storage  int64 // bytes in the storage path
+	maxTotal int64 // -1 when storage is FULL
+	imported int
+	skipped  int
+}
+
+// importImages adds every file with an allowed extension below a directory.
+// Files are validated and scanned like uploads and keep their modification
+// time as the upload date. Names that already match app.uuid_format keep
+// their UUID unless it is taken.
+func importImages(cfg *config.Config, db *storage.DB, logger *utils.Logger, args []string) error {
+	rest, err := parseFlags(flag.NewFlagSet("image import", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	dir := rest[0]
+
+	imp := &importer{config: cfg, db: db, uuidRe: regexp.MustCompile(cfg.App.UUIDFormat)}
+	if imp.keyring, err = encryption.LoadKeyring(cfg); err != nil {
+		return err
+	}
+	if imp.scanner, err = upload.NewScanner(cfg); err != nil {
+		return err
+	}
+	if imp.maxSize, err = cfg.GetMaxFileSize(); err != nil {
+		return err
+	}
+	if imp.maxTotal, err = cfg.GetMaxStorage(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.Storage.BasePath, 0755); err != nil {
+		return err
+	}
+	if imp.storage, err = storage.Usage(cfg.Storage.BasePath); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		image, err := imp.importFile(path)
+		if err != nil {
+			var skip *skipError
+			if !errors.As(err, &skip) {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			imp.skipped++
+			fmt.Printf("skipped  %s: %s\n", path, skip.reason)
+			return nil
+		}
+		imp.imported++
+		fmt.Printf("imported %s -> /%s.%s\n", path, image.UUID, image.Extension)
+		return nil
+	})
+
+	logger.Info("Imported images", map[string]interface{}{
+		"dir":      dir,
+		"imported": imp.imported,
+		"skipped":  imp.skipped,
+	})
+	fmt.Printf("Imported %d files, skipped %d\n", imp.imported, imp.skipped)
+	return err
+}
+
+// skipError leaves a file out of an import without stopping it
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+func skip(format string, args ...interface{}) error {
+	return &skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (imp *importer) importFile(path string) (*models.Image, error) {
+	name := filepath.Base(path)
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	allowed := false
+	for _, allowedExt := range imp.config.Storage.AllowedExtensions {
+		if ext == allowedExt {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, skip("file type '.%s' not allowed", ext)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > imp.maxSize {
+		return nil, skip("file too large")
+	}
+	if imp.maxTotal != -1 && imp.storage+info.Size() > imp.maxTotal {
+		return nil, skip("maximum storage limit reached")
+	}
+
+	// Verify and scan the content like an upload
+	if _, known := upload.FormatForExtension(ext); known {
+		limits := upload.Limits{
+			MaxPixels:       imp.config.Uploads.MaxPixels,
+			MaxDimension:    imp.config.Uploads.MaxDimension,
+			AllowUnverified: imp.config.Uploads.AllowUnverified,
+		}
+		if err := upload.Validate(file, ext, limits); err != nil {
+			var invalid *upload.InvalidError
+			if errors.As(err, &invalid) {
+				return nil, skip("not a valid .%s image: %s", ext, invalid.Reason)
+			}
+			return nil, err
+		}
+	} else if !imp.config.Uploads.AllowUnverified {
+		return nil, skip("file type '.%s' cannot be verified", ext)
+	}
+	if imp.scanner != nil {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		result, err := imp.scanner.Scan(context.Background(), file)
+		switch {
+		case err != nil && !imp.config.Uploads.Scanner.FailOpen:
+			return nil, fmt.Errorf("scan failed: %w", err)
+		case err == nil && !result.Clean:
+			return nil, skip("flagged by %s: %s", imp.scanner.Name(), result.Signature)
+		}
+	}
+
+	uuid := strings.TrimSuffix(name, filepath.Ext(name))
+	if !imp.uuidRe.MatchString(uuid) {
+		uuid = ""
+	} else if existing, err := imp.db.GetImage(uuid); err != nil {
+		return nil, err
+	} else if existing != nil {
+		uuid = ""
+	}
+	if uuid == "" {
+		if uuid, err = utils.GenerateFormattedUUID(imp.config.App.UUIDFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	uploadedAt := info.ModTime()
+	dst := filepath.Join(
+		imp.config.Storage.BasePath,
+		fmt.Sprintf("%d", uploadedAt.Year()),
+		fmt.Sprintf("%02d", uploadedAt.Month()),
+		fmt.Sprintf("%02d", uploadedAt.Day()),
+		fmt.Sprintf("%s.%s", uuid, ext),
+	)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+	digest, err := imp.store(file, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &models.Image{
+		UUID:       uuid,
+		Filename:   name,
+		Extension:  ext,
+		Size:       info.Size(),
+		UploadedAt: uploadedAt,
+		SHA256:     digest,
+	}
+	if err := imp.db.CreateImage(image); err != nil {
+		os.Remove(dst)
+		return nil, err
+	}
+
+	stored, err := os.Stat(dst)
+	if err == nil {
+		imp.storage += stored.Size()
+	}
+	return image, nil
+}
+
+// store copies a file into the storage path, encrypting it when enabled, and
+// returns the SHA-256 of its content
+func (imp *importer) store(src *os.File, dst string) (string, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(imp.config.Storage.BasePath, ".import-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if imp.keyring != nil && imp.config.Encryption.Enabled {
+		err = imp.keyring.EncryptFile(tmpPath, dst)
+	} else {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), os.Chmod(dst, 0644)
+}
+
+// deleteImage removes an image's file, views and record
+func deleteImage(cfg *config.Config, db *storage.DB, args []string) error {
+	rest, err := parseFlags(flag.NewFlagSet("image delete", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	image, err := db.GetImage(rest[0])
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		return fmt.Errorf("image %q does not exist", rest[0])
+	}
+
+	filePath := filepath.Join(
+		cfg.Storage.BasePath,
+		fmt.Sprintf("%d", image.UploadedAt.Year()),
+		fmt.Sprintf("%02d", image.UploadedAt.Month()),
+		fmt.Sprintf("%02d", image.UploadedAt.Day()),
+		fmt.Sprintf("%s.%s", image.UUID, image.Extension),
+	)
+	// A missing file should not keep the record around
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := db.DeleteImageByID(image.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted image %s (%s)\n", image.UUID, image.Filename)
+	return audit(db, models.AuditImageDelete, "image", image.UUID, map[string]interface{}{
+		"id":        image.ID,
+		"filename":  image.Filename,
+		"extension": image.Extension,
+		"size":      image.Size,
+		"isPrivate": image.IsPrivate,
+	}, nil)
+}
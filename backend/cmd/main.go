@@ -1,154 +1,166 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
 
 	"sharex/internal/config"
-	"sharex/internal/handlers"
-	"sharex/internal/middleware"
 	"sharex/internal/storage"
 	"sharex/internal/utils"
 )
 
+const usage = `Usage: simp-server [--config file] [command]
+
+Options:
+  --config file                   Configuration file, default $LLMSTOR_CONFIG or config.yaml
+
+Commands:
+  serve                           Run the web server (default)
+  config check                    Validate the configuration and print it with secrets redacted
+  user add [-role r] <username>   Create a local user, reading the password from stdin
+  user passwd <username>          Set a user's password, reading it from stdin
+  user disable <username>         Block logins and end the user's sessions
+  user enable <username>          Allow a disabled user to log in again
+  user list [-json]               List users
+  token create <name>             Create an API token for uploads and print it once
+  token revoke <name>             Revoke an API token
+  token list [-json]              List API tokens
+  image import <dir>              Import the image files in a directory
+  image delete <uuid>             Delete an image and its file
+  export [-views] [-type t] [-from d] [-to d] <file>
+                                  Write the library to an archive another instance can import
+  import [-conflict skip|rename|keep] <file>
+                                  Import the images of an export archive
+  stats [-json]                   Print image, view and storage totals
+  fsck [-checksums] [-repair a,b] [-json]
+                                  Check image records against stored files
+  backup run                      Back up the database and stored files now
+  backup list [-json]             List backups
+  backup verify <backup>          Check a backup against its manifest
+  restore <backup>                Verify a backup and restore it, with the server stopped
+  db migrate                      Bring the database schema up to date
+  db vacuum                       Compact the database file
+  rotate-keys                     Rewrap encrypted files with the current master key
+`
+
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig("config.yaml")
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+	// Options come before the command
+	defaultConfig := os.Getenv("LLMSTOR_CONFIG")
+	if defaultConfig == "" {
+		defaultConfig = "config.yaml"
 	}
-
-	// Initialize logger
-	logger, err := utils.InitializeLogger(cfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize logger: %v", err)
+	fs := flag.NewFlagSet("simp-server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", defaultConfig, "configuration file")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Print(usage)
+			return
+		}
+		fmt.Fprint(os.Stderr, usage)
+		log.Fatalf("%v", err)
 	}
-	defer logger.Close()
 
-	// Set logger for IP info package
-	utils.SetIPInfoLogger(logger)
+	command, args := "serve", []string(nil)
+	if fs.NArg() > 0 {
+		command, args = fs.Arg(0), fs.Args()[1:]
+	}
 
-	// Initialize database
-	db, err := storage.NewDB(cfg.Database.File)
-	if err != nil {
-		logger.Error("Failed to initialize database", map[string]interface{}{
-			"error": err.Error(),
-			"file":  cfg.Database.File,
-		})
-		log.Fatalf("Failed to initialize database: %v", err)
+	switch command {
+	case "help":
+		fmt.Print(usage)
+		return
 	}
 
-	// Create user if it doesn't exist
-	user, err := db.GetUser(cfg.User.Username)
+	// Load configuration
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
-		logger.Error("Failed to get user", map[string]interface{}{
-			"error":    err.Error(),
-			"username": cfg.User.Username,
-		})
-		log.Fatalf("Failed to get user: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	if user == nil {
-		if err := db.CreateUser(cfg.User.Username, cfg.User.Password); err != nil {
-			logger.Error("Failed to create user", map[string]interface{}{
-				"error":    err.Error(),
-				"username": cfg.User.Username,
-			})
-			log.Fatalf("Failed to create user: %v", err)
+	// Checking the configuration must not touch anything else
+	if command == "config" {
+		if err := configCommand(cfg, args); err != nil {
+			log.Fatalf("%s: %v", command, err)
 		}
-		logger.Info("Created new user", map[string]interface{}{
-			"username": cfg.User.Username,
-		})
+		return
 	}
 
-	// Initialize handler
-	handler := handlers.NewHandler(cfg, db, logger)
-
-	// Create storage directory if it doesn't exist
-	if err := os.MkdirAll(cfg.Storage.BasePath, 0755); err != nil {
-		logger.Error("Failed to create storage directory", map[string]interface{}{
-			"error": err.Error(),
-			"path":  cfg.Storage.BasePath,
-		})
-		log.Fatalf("Failed to create storage directory: %v", err)
+	if err := cfg.CreateDirectories(); err != nil {
+		log.Fatalf("Failed to create directories: %v", err)
 	}
 
-	// Create frontend directory if it doesn't exist
-	if err := os.MkdirAll("frontend/dist", 0755); err != nil {
-		logger.Error("Failed to create frontend directory", map[string]interface{}{
-			"error": err.Error(),
-			"path":  "frontend/dist",
-		})
-		log.Fatalf("Failed to create frontend directory: %v", err)
+	// Initialize logger. Only the server prints the startup banner, so
+	// command output stays readable and scriptable.
+	var logger *utils.Logger
+	if command == "serve" {
+		logger, err = utils.InitializeLogger(cfg)
+	} else {
+		logger, err = utils.NewLogger(cfg)
 	}
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+	defer logger.Close()
 
-	// Create a simple index.html if it doesn't exist
-	indexPath := filepath.Join("frontend/dist", "index.html")
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-		logger.Error("Frontend files not found", map[string]interface{}{
-			"error": "frontend/dist directory is empty or missing required files",
-			"path":  indexPath,
-		})
-		log.Fatalf("Frontend files not found in frontend/dist. Please build the frontend first")
+	// Set logger for IP info package
+	utils.SetIPInfoLogger(logger)
+
+	// Restoring replaces the database file, so it runs before it is opened
+	if command == "restore" {
+		if err := restoreCommand(cfg, logger, args); err != nil {
+			log.Fatalf("%s: %v", command, err)
+		}
+		return
 	}
 
-	// Initialize rate limiter
-	rateLimiter, err := middleware.NewRateLimiter(cfg, logger)
+	// Initialize database
+	db, err := storage.NewDB(cfg.Database.Driver, cfg.DatabaseSource())
 	if err != nil {
-		logger.Error("Failed to initialize rate limiter", map[string]interface{}{
-			"error": err.Error(),
+		// The connection URL may hold a password, so only the driver is logged
+		logger.Error("Failed to initialize database", map[string]interface{}{
+			"error":  err.Error(),
+			"driver": cfg.Database.Driver,
 		})
-		log.Fatalf("Failed to initialize rate limiter: %v", err)
+		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer rateLimiter.Close()
-
-	// Setup routes
-	mux := http.NewServeMux()
-
-	// Public routes (no auth required)
-	mux.HandleFunc("/api/login", handler.Login)
-	mux.HandleFunc("/api/verify", handler.VerifyToken)
-	mux.HandleFunc("/api/refresh", handler.RefreshToken)
-	mux.HandleFunc("/api/upload", handler.Upload)
-
-	// Auth required routes
-	mux.HandleFunc("/api/logout", handler.Logout)
-	mux.HandleFunc("/api/delete/", handler.DeleteImage)
-	mux.HandleFunc("/api/list", handler.ListImages)
-	mux.HandleFunc("/api/stats/", handler.GetImageStats)
-	mux.HandleFunc("/api/privacy/", handler.TogglePrivacy)
-	mux.HandleFunc("/api/stats/disk-usage", handler.GetDiskUsage)
-	mux.HandleFunc("/api/stats/views", handler.GetViewsData)
-	mux.HandleFunc("/api/stats/country-views", handler.GetCountryViews)
-	mux.HandleFunc("/api/stats/recent-views", handler.GetRecentViews)
-	mux.HandleFunc("/api/stats/dashboard", handler.GetDashboardStats)
-	mux.HandleFunc("/api/proxy/", handler.ServeProxyImage)
-	mux.HandleFunc("/api/config", handler.GetConfig)
-
-	// Frontend routes (must be last)
-	mux.HandleFunc("/", handler.ServeFrontend)
-
-	// Apply middleware in order
-	var handlerWithMiddleware http.Handler = mux
-	handlerWithMiddleware = middleware.LoggingMiddleware(cfg, logger)(handlerWithMiddleware)
-	handlerWithMiddleware = rateLimiter.RateLimitMiddleware()(handlerWithMiddleware)
-	handlerWithMiddleware = middleware.CORSMiddleware(cfg)(handlerWithMiddleware)
-	handlerWithMiddleware = middleware.CSRFMiddleware(cfg)(handlerWithMiddleware)
-	handlerWithMiddleware = middleware.AuthMiddleware(cfg)(handlerWithMiddleware)
-
-	// Start server
-	addr := fmt.Sprintf(":%d", cfg.App.Port)
-	logger.Info("Starting server", map[string]interface{}{
-		"address":     addr,
-		"environment": cfg.App.Environment,
-	})
-	if err := http.ListenAndServe(addr, handlerWithMiddleware); err != nil {
-		logger.Error("Server error", map[string]interface{}{
-			"error": err.Error(),
-		})
-		log.Fatalf("Server error: %v", err)
+	defer db.Close()
+
+	// Maintenance commands share the configuration and database with the
+	// server and run instead of it
+	var cmdErr error
+	switch command {
+	case "serve":
+		serve(cfg, db, logger)
+	case "user":
+		cmdErr = userCommand(db, args)
+	case "token":
+		cmdErr = tokenCommand(db, args)
+	case "image":
+		cmdErr = imageCommand(cfg, db, logger, args)
+	case "export":
+		cmdErr = exportCommand(cfg, db, args)
+	case "import":
+		cmdErr = importCommand(cfg, db, logger, args)
+	case "stats":
+		cmdErr = statsCommand(cfg, db, args)
+	case "fsck":
+		cmdErr = fsckCommand(cfg, db, logger, args)
+	case "backup":
+		cmdErr = backupCommand(cfg, db, logger, args)
+	case "db":
+		cmdErr = dbCommand(cfg, db, args)
+	case "rotate-keys":
+		cmdErr = rotateKeys(cfg, db, logger)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		log.Fatalf("Unknown command %q", command)
+	}
+	if cmdErr != nil {
+		log.Fatalf("%s: %v", command, cmdErr)
 	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// rotateKeys rewraps the data key of every encrypted file with the current
+// master key. Content is not re-encrypted, so this is quick even for large
+// stores. The retired key must be listed in encryption.previous_key_files.
+func rotateKeys(cfg *config.Config, db *storage.DB, logger *utils.Logger) error {
+	keyring, err := encryption.LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return fmt.Errorf("no encryption key is configured")
+	}
+
+	result, err := encryption.NewMigrator(cfg, db, keyring, logger).Pass(0, false)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Rotated encryption keys", map[string]interface{}{
+		"key_id":    keyring.KeyID(),
+		"rewrapped": result.Rewrapped,
+		"failed":    result.Failed,
+	})
+	fmt.Printf("Rewrapped %d files with key %s\n", result.Rewrapped, keyring.KeyID())
+	if result.Failed > 0 {
+		return fmt.Errorf("%d files could not be rewrapped, see the error log", result.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"sharex/internal/analytics"
+	"sharex/internal/backup"
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/events"
+	"sharex/internal/fsck"
+	"sharex/internal/handlers"
+	"sharex/internal/health"
+	"sharex/internal/middleware"
+	"sharex/internal/server"
+	"sharex/internal/storage"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+	"sharex/internal/webhooks"
+)
+
+// serve runs the web server until it is stopped or fails
+func serve(cfg *config.Config, db *storage.DB, logger *utils.Logger) {
+	// Create user if it doesn't exist
+	user, err := db.GetUser(cfg.User.Username)
+	if err != nil {
+		logger.Error("Failed to get user", map[string]interface{}{
+			"error":    err.Error(),
+			"username": cfg.User.Username,
+		})
+		log.Fatalf("Failed to get user: %v", err)
+	}
+
+	if user == nil {
+		if err := db.CreateUser(cfg.User.Username, cfg.User.Password); err != nil {
+			logger.Error("Failed to create user", map[string]interface{}{
+				"error":    err.Error(),
+				"username": cfg.User.Username,
+			})
+			log.Fatalf("Failed to create user: %v", err)
+		}
+		logger.Info("Created new user", map[string]interface{}{
+			"username": cfg.User.Username,
+		})
+	}
+
+	// Hash viewer passwords older versions stored in plain text. Each hash
+	// takes a while, so this runs next to the server instead of before it.
+	rehashCtx, stopRehash := context.WithCancel(context.Background())
+	rehashed := make(chan struct{})
+	go func() {
+		defer close(rehashed)
+		count, err := db.HashPrivateKeys(rehashCtx)
+		if err != nil && rehashCtx.Err() == nil {
+			logger.Error("Failed to hash viewer passwords", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		if count > 0 {
+			logger.Info("Hashed viewer passwords", map[string]interface{}{
+				"count": count,
+			})
+		}
+	}()
+	defer func() {
+		stopRehash()
+		<-rehashed
+	}()
+
+	// Start purging raw views past the retention window
+	retention := analytics.NewRetention(cfg, db, logger)
+	retention.Start()
+	defer retention.Close()
+
+	// Load the master keys for encryption at rest, then encrypt existing files
+	// and rewrap ones sealed with a retired key in the background
+	keyring, err := encryption.LoadKeyring(cfg)
+	if err != nil {
+		logger.Error("Failed to load encryption keys", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	migrator := encryption.NewMigrator(cfg, db, keyring, logger)
+	migrator.Start()
+	defer migrator.Close()
+
+	// Reconcile image records with the storage path on a schedule
+	checker := fsck.New(cfg, db, keyring, logger)
+	checker.Start()
+	defer checker.Close()
+
+	// Take scheduled backups of the database and stored files
+	backups := backup.NewManager(cfg, db, logger)
+	backups.Start()
+	defer backups.Close()
+
+	// Initialize live event broker for the dashboard feed
+	broker := events.NewBroker(cfg.Events.HistorySize, cfg.Events.SubscriberBuffer)
+
+	// Start delivering broker events to webhook subscribers
+	dispatcher := webhooks.NewDispatcher(cfg, db, logger)
+	if err := dispatcher.Start(broker); err != nil {
+		logger.Error("Failed to start webhook dispatcher", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to start webhook dispatcher: %v", err)
+	}
+	defer dispatcher.Close()
+
+	// Connect the malware scanner uploads are checked with, if any
+	scanner, err := upload.NewScanner(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize upload scanner", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to initialize upload scanner: %v", err)
+	}
+
+	// Requests read the configuration through the store, so a reload
+	// applies to the next request
+	configs := config.NewStore(cfg)
+
+	// Initialize handler
+	handler := handlers.NewHandler(configs, db, logger, broker, dispatcher, scanner, keyring, checker)
+
+	// Create storage directory if it doesn't exist
+	if err := os.MkdirAll(cfg.Storage.BasePath, 0755); err != nil {
+		logger.Error("Failed to create storage directory", map[string]interface{}{
+			"error": err.Error(),
+			"path":  cfg.Storage.BasePath,
+		})
+		log.Fatalf("Failed to create storage directory: %v", err)
+	}
+
+	// Create frontend directory if it doesn't exist
+	if err := os.MkdirAll("frontend/dist", 0755); err != nil {
+		logger.Error("Failed to create frontend directory", map[string]interface{}{
+			"error": err.Error(),
+			"path":  "frontend/dist",
+		})
+		log.Fatalf("Failed to create frontend directory: %v", err)
+	}
+
+	// Create a simple index.html if it doesn't exist
+	indexPath := filepath.Join("frontend/dist", "index.html")
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		logger.Error("Frontend files not found", map[string]interface{}{
+			"error": "frontend/dist directory is empty or missing required files",
+			"path":  indexPath,
+		})
+		log.Fatalf("Frontend files not found in frontend/dist. Please build the frontend first")
+	}
+
+	// Resolve client IPs through the trusted proxies only
+	ipResolver, err := utils.NewIPResolver(cfg.App.TrustedProxies)
+	if err != nil {
+		logger.Error("Failed to initialize client IP resolver", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to initialize client IP resolver: %v", err)
+	}
+
+	// Initialize rate limiter
+	rateLimiter, err := middleware.NewRateLimiter(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to initialize rate limiter", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to initialize rate limiter: %v", err)
+	}
+	defer rateLimiter.Close()
+
+	// Apply reloaded configurations to the rate limiter and log levels
+	configs.OnReload(rateLimiter.Reload)
+	configs.OnReload(func(old, next *config.Config) error {
+		return logger.SetLevels(next)
+	})
+
+	// Register the checks of the liveness and readiness probes. Only a stuck
+	// worker fails liveness, restarting does not help with the rest.
+	prober := health.NewProber(configs, logger)
+	prober.Add("database", false, health.Database(db))
+	prober.Add("schema", false, health.Schema(db))
+	prober.Add("storage", false, health.Storage(cfg.Storage.BasePath))
+	prober.Add("disk", false, health.FreeDisk(cfg.Storage.BasePath, configs))
+	prober.Add("redis", false, health.Redis(rateLimiter))
+	prober.Add("workers", true, health.Workers(
+		retention.Heartbeat(),
+		migrator.Heartbeat(),
+		checker.Heartbeat(),
+		backups.Heartbeat(),
+		dispatcher.Heartbeat(),
+	))
+
+	// Setup routes
+	mux := http.NewServeMux()
+
+	// Public routes (no auth required)
+	mux.HandleFunc("/api/login", handler.Login)
+	mux.HandleFunc("/api/login/2fa", handler.LoginTwoFactor)
+	mux.HandleFunc("/api/oidc/login", handler.OIDCLogin)
+	mux.HandleFunc("/api/oidc/callback", handler.OIDCCallback)
+	mux.HandleFunc("/api/verify", handler.VerifyToken)
+	mux.HandleFunc("/api/refresh", handler.RefreshToken)
+	mux.HandleFunc("/api/upload", handler.Upload)
+
+	// Auth required routes
+	mux.HandleFunc("/api/logout", handler.Logout)
+	mux.HandleFunc("/api/delete/", handler.DeleteImage)
+	mux.HandleFunc("/api/list", handler.ListImages)
+	mux.HandleFunc("/api/stats/", handler.GetImageStats)
+	mux.HandleFunc("/api/privacy/", handler.TogglePrivacy)
+	mux.HandleFunc("/api/stats/disk-usage", handler.GetDiskUsage)
+	mux.HandleFunc("/api/stats/views", handler.GetViewsData)
+	mux.HandleFunc("/api/stats/country-views", handler.GetCountryViews)
+	mux.HandleFunc("/api/stats/recent-views", handler.GetRecentViews)
+	mux.HandleFunc("/api/stats/dashboard", handler.GetDashboardStats)
+	mux.HandleFunc("/api/proxy/", handler.ServeProxyImage)
+	mux.HandleFunc("/api/config", handler.GetConfig)
+	mux.HandleFunc("/api/config/reload", handler.ReloadConfig)
+	mux.HandleFunc("/api/analytics/erase", handler.EraseIPAnalytics)
+	mux.HandleFunc("/api/export/views", handler.ExportViews)
+	mux.HandleFunc("/api/export", handler.ExportArchive)
+	mux.HandleFunc("/api/import", handler.ImportArchive)
+	mux.HandleFunc("/api/events", handler.Events)
+	mux.HandleFunc("/api/webhooks", handler.Webhooks)
+	mux.HandleFunc("/api/webhooks/", handler.WebhookRoutes)
+	mux.HandleFunc("/api/lockouts", handler.Lockouts)
+	mux.HandleFunc("/api/ratelimit", handler.RateLimitPolicies)
+	mux.HandleFunc("/api/audit", handler.AuditEvents)
+	mux.HandleFunc("/api/audit/verify", handler.VerifyAuditLog)
+	mux.HandleFunc("/api/quarantine", handler.Quarantine)
+	mux.HandleFunc("/api/quarantine/", handler.DeleteQuarantined)
+	mux.HandleFunc("/api/fsck", handler.Fsck)
+	mux.HandleFunc("/api/sessions", handler.Sessions)
+	mux.HandleFunc("/api/sessions/", handler.RevokeSession)
+	mux.HandleFunc("/api/2fa", handler.TwoFactorStatus)
+	mux.HandleFunc("/api/2fa/setup", handler.TwoFactorSetup)
+	mux.HandleFunc("/api/2fa/enable", handler.TwoFactorEnable)
+	mux.HandleFunc("/api/2fa/disable", handler.TwoFactorDisable)
+	mux.HandleFunc("/api/2fa/reset", handler.TwoFactorReset)
+
+	// Prometheus metrics, protected by token or IP allowlist
+	mux.HandleFunc("/metrics", handler.Metrics)
+
+	// Password form for private images
+	mux.HandleFunc("/unlock/", handler.UnlockImage)
+
+	// Frontend routes (must be last)
+	mux.HandleFunc("/", handler.ServeFrontend)
+
+	// Apply middleware in order
+	var handlerWithMiddleware http.Handler = mux
+	handlerWithMiddleware = middleware.LoggingMiddleware(cfg, logger)(handlerWithMiddleware)
+	handlerWithMiddleware = rateLimiter.RateLimitMiddleware()(handlerWithMiddleware)
+	handlerWithMiddleware = middleware.CORSMiddleware(configs)(handlerWithMiddleware)
+	handlerWithMiddleware = middleware.CSRFMiddleware(cfg)(handlerWithMiddleware)
+	handlerWithMiddleware = middleware.AuthMiddleware(cfg, db)(handlerWithMiddleware)
+	handlerWithMiddleware = middleware.MetricsMiddleware(mux)(handlerWithMiddleware)
+	handlerWithMiddleware = middleware.ClientIPMiddleware(ipResolver)(handlerWithMiddleware)
+
+	// Probes bypass the middleware, so they are not logged, rate limited or
+	// authenticated
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", prober.Liveness)
+	root.HandleFunc("/readyz", prober.Readiness)
+	root.Handle("/", handlerWithMiddleware)
+
+	srv, err := server.New(cfg, root, logger)
+	if err != nil {
+		logger.Error("Failed to initialize server", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
+	srv.RegisterOnShutdown(broker.Close)
+
+	// Reload the configuration on SIGHUP, like POST /api/config/reload
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			handler.Reload("signal")
+		}
+	}()
+
+	// Serve until SIGTERM or Ctrl+C. Returning stops the background workers
+	// in the reverse order they were started, then main closes the database
+	// and the logger.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
+		logger.Error("Server error", map[string]interface{}{
+			"error": err.Error(),
+		})
+		log.Fatalf("Server error: %v", err)
+	}
+}
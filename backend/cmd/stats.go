@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"sharex/internal/config"
+	"sharex/internal/size"
+	"sharex/internal/storage"
+)
+
+// statsCommand prints the totals shown on the dashboard
+func statsCommand(cfg *config.Config, db *storage.DB, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	totalImages, privateImages, totalViews, err := db.GetDashboardStats()
+	if err != nil {
+		return err
+	}
+	users, err := db.ListUsers()
+	if err != nil {
+		return err
+	}
+	used, err := storage.Usage(cfg.Storage.BasePath)
+	if err != nil {
+		return err
+	}
+	maxStorage, err := cfg.GetMaxStorage()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{
+			"total_images":   totalImages,
+			"private_images": privateImages,
+			"total_views":    totalViews,
+			"users":          len(users),
+			"storage_used":   used,
+			"storage_max":    maxStorage,
+		})
+	}
+
+	fmt.Printf("Images:   %d (%d private)\n", totalImages, privateImages)
+	fmt.Printf("Views:    %d\n", totalViews)
+	fmt.Printf("Users:    %d\n", len(users))
+	fmt.Printf("Storage:  %s used, limit %s\n", size.Format(used), cfg.GetStorageLimit())
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/storage"
+)
+
+// apiTokenPrefix marks API tokens so they are easy to spot in configs and
+// secret scanners
+const apiTokenPrefix = "simp_"
+
+// tokenCommand manages API tokens. Tokens are accepted by /api/upload as a
+// bearer token or in place of the upload key.
+func tokenCommand(db *storage.DB, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return createToken(db, args[1:])
+	case "revoke":
+		return revokeToken(db, args[1:])
+	case "list":
+		return listTokens(db, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+func createToken(db *storage.DB, args []string) error {
+	rest, err := parseFlags(flag.NewFlagSet("token create", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	name := rest[0]
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiToken, err := db.CreateAPIToken(name, token)
+	if err != nil {
+		return fmt.Errorf("failed to create token %q, active token names must be unique: %w", name, err)
+	}
+
+	// The token is only shown once, the database keeps its hash
+	fmt.Fprintf(os.Stderr, "Created token %s, store it now as it cannot be shown again\n", name)
+	fmt.Println(token)
+	return audit(db, models.AuditTokenCreate, "token", name, nil, map[string]interface{}{
+		"id": apiToken.ID,
+	})
+}
+
+func revokeToken(db *storage.DB, args []string) error {
+	rest, err := parseFlags(flag.NewFlagSet("token revoke", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	name := rest[0]
+
+	revoked, err := db.RevokeAPIToken(name)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return fmt.Errorf("no active token named %q", name)
+	}
+
+	fmt.Printf("Revoked token %s\n", name)
+	return audit(db, models.AuditTokenRevoke, "token", name, nil, nil)
+}
+
+func listTokens(db *storage.DB, args []string) error {
+	fs := flag.NewFlagSet("token list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	tokens, err := db.ListAPITokens()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if tokens == nil {
+			tokens = []models.APIToken{}
+		}
+		return printJSON(tokens)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCREATED\tLAST USED\tSTATUS")
+	for _, t := range tokens {
+		lastUsed, status := "never", "active"
+		if t.LastUsedAt != nil {
+			lastUsed = t.LastUsedAt.Local().Format(time.DateTime)
+		}
+		if t.RevokedAt != nil {
+			status = "revoked " + t.RevokedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.CreatedAt.Local().Format(time.DateTime), lastUsed, status)
+	}
+	return tw.Flush()
+}
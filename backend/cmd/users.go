@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"sharex/internal/models"
+	"sharex/internal/storage"
+)
+
+// userCommand manages login accounts
+func userCommand(db *storage.DB, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "add":
+		return addUser(db, args[1:])
+	case "passwd":
+		return setUserPassword(db, args[1:])
+	case "disable":
+		return setUserDisabled(db, args[1:], true)
+	case "enable":
+		return setUserDisabled(db, args[1:], false)
+	case "list":
+		return listUsers(db, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+func addUser(db *storage.DB, args []string) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	role := fs.String("role", models.RoleAdmin, "admin or user")
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	username := rest[0]
+	if *role != models.RoleAdmin && *role != models.RoleUser {
+		return fmt.Errorf("role must be %q or %q", models.RoleAdmin, models.RoleUser)
+	}
+
+	existing, err := db.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("user %q already exists", username)
+	}
+
+	password, err := readPassword("Password: ")
+	if err != nil {
+		return err
+	}
+	if err := db.CreateUser(username, password); err != nil {
+		return err
+	}
+	user, err := db.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if user.Role != *role {
+		if err := db.SetUserRole(user.ID, *role); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Created user %s with role %s\n", username, *role)
+	return audit(db, models.AuditUserCreate, "user", username, nil, map[string]interface{}{
+		"role":          *role,
+		"auth_provider": models.AuthProviderLocal,
+	})
+}
+
+func setUserPassword(db *storage.DB, args []string) error {
+	user, err := lookupUser(db, flag.NewFlagSet("user passwd", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if user.AuthProvider == models.AuthProviderOIDC {
+		return fmt.Errorf("user %q signs in with single sign-on and has no local password", user.Username)
+	}
+
+	password, err := readPassword("New password: ")
+	if err != nil {
+		return err
+	}
+	if err := db.SetUserPassword(user.ID, password); err != nil {
+		return err
+	}
+
+	fmt.Printf("Changed the password of %s and ended their sessions\n", user.Username)
+	// The password itself is never recorded
+	return audit(db, models.AuditUserUpdate, "user", user.Username, nil,
+		map[string]interface{}{"password_changed": true})
+}
+
+func setUserDisabled(db *storage.DB, args []string, disabled bool) error {
+	name := "user enable"
+	if disabled {
+		name = "user disable"
+	}
+	user, err := lookupUser(db, flag.NewFlagSet(name, flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if user.Disabled == disabled {
+		fmt.Printf("User %s is already %s\n", user.Username, enabledState(disabled))
+		return nil
+	}
+
+	if err := db.SetUserDisabled(user.ID, disabled); err != nil {
+		return err
+	}
+
+	fmt.Printf("User %s is now %s\n", user.Username, enabledState(disabled))
+	return audit(db, models.AuditUserUpdate, "user", user.Username,
+		map[string]interface{}{"disabled": user.Disabled},
+		map[string]interface{}{"disabled": disabled})
+}
+
+func listUsers(db *storage.DB, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	users, err := db.ListUsers()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if users == nil {
+			users = []models.User{}
+		}
+		return printJSON(users)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tROLE\tPROVIDER\t2FA\tSTATUS")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%v\t%s\n", u.ID, u.Username, u.Role, u.AuthProvider, u.TOTPEnabled, enabledState(u.Disabled))
+	}
+	return tw.Flush()
+}
+
+// lookupUser parses a single username argument and loads that user
+func lookupUser(db *storage.DB, fs *flag.FlagSet, args []string) (*models.User, error) {
+	rest, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	user, err := db.GetUser(rest[0])
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %q does not exist", rest[0])
+	}
+	return user, nil
+}
+
+func enabledState(disabled bool) string {
+	if disabled {
+		return "disabled"
+	}
+	return "enabled"
+}
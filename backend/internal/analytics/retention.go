@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/health"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// Retention periodically rolls raw view rows past the configured retention
+// window up into daily aggregates and purges them
+type Retention struct {
+	config    *config.Config
+	db        *storage.DB
+	logger    *utils.Logger
+	heartbeat *health.Heartbeat
+	stopChan  chan struct{}
+}
+
+func NewRetention(cfg *config.Config, db *storage.DB, logger *utils.Logger) *Retention {
+	return &Retention{
+		config:    cfg,
+		db:        db,
+		logger:    logger,
+		heartbeat: health.NewHeartbeat("view_retention", time.Duration(cfg.Analytics.PurgeInterval)*time.Minute),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start runs an initial purge and then keeps purging on the configured schedule.
+// It does nothing when retention_days is 0.
+func (r *Retention) Start() {
+	if r.config.GetViewRetention() <= 0 {
+		r.logger.Info("View retention is disabled, raw views are kept forever", nil)
+		return
+	}
+
+	r.logger.Info("Starting view retention routine", map[string]interface{}{
+		"retention_days":   r.config.Analytics.RetentionDays,
+		"purge_interval":   r.config.Analytics.PurgeInterval,
+		"ip_anonymization": r.config.Analytics.IPAnonymization,
+	})
+
+	go r.run()
+}
+
+func (r *Retention) run() {
+	defer r.heartbeat.Stop()
+	r.heartbeat.Beat()
+	r.Purge()
+
+	ticker := time.NewTicker(time.Duration(r.config.Analytics.PurgeInterval) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		r.heartbeat.Beat()
+		select {
+		case <-ticker.C:
+			r.Purge()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// Purge removes raw view rows older than the retention window
+func (r *Retention) Purge() (int64, error) {
+	cutoff := time.Now().Add(-r.config.GetViewRetention())
+
+	purged, err := r.db.PurgeImageViews(cutoff)
+	if err != nil {
+		r.logger.Error("Failed to purge expired views", map[string]interface{}{
+			"error":  err.Error(),
+			"cutoff": cutoff.Format(time.RFC3339),
+		})
+		return 0, err
+	}
+
+	if purged > 0 {
+		r.logger.Info("Purged expired views", map[string]interface{}{
+			"purged": purged,
+			"cutoff": cutoff.Format(time.RFC3339),
+		})
+	}
+	return purged, nil
+}
+
+// Heartbeat reports whether the purge routine is still running
+func (r *Retention) Heartbeat() *health.Heartbeat {
+	return r.heartbeat
+}
+
+func (r *Retention) Close() {
+	close(r.stopChan)
+}
@@ -0,0 +1,544 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+)
+
+// An archive is a zip file holding manifest.json and the content of each
+// image under images/, decrypted so it can be imported on an instance with
+// different keys. The manifest is written last, after every image.
+const (
+	Format       = "llmstor-export"
+	Version      = 1
+	manifestFile = "manifest.json"
+	imagesDir    = "images"
+)
+
+// How an import handles an image whose UUID is already taken by a different
+// image. Images identical to the one they collide with are always skipped.
+const (
+	ConflictSkip   = "skip"   // leave the local image and skip the archived one
+	ConflictRename = "rename" // import the archived image under a new UUID
+	ConflictKeep   = "keep"   // keep the archived UUID, replacing the local image
+)
+
+// Manifest lists the images of an archive
+type Manifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Source     string    `json:"source"` // app.domain of the exporting instance
+	Views      bool      `json:"views"`  // whether view aggregates are included
+	Images     []Image   `json:"images"`
+
+	// Skipped maps the UUIDs of images left out of the export to the reason
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// Image is one image of an archive
+type Image struct {
+	UUID         string                 `json:"uuid"`
+	Filename     string                 `json:"filename"`
+	Extension    string                 `json:"extension"`
+	Size         int64                  `json:"size"`
+	SHA256       string                 `json:"sha256"`
+	UploadedAt   time.Time              `json:"uploaded_at"`
+	IsPrivate    bool                   `json:"is_private"`
+	PasswordHash string                 `json:"password_hash,omitempty"`
+	Views        int64                  `json:"views"`
+	ViewsByDay   []models.ViewAggregate `json:"views_by_day,omitempty"`
+	File         string                 `json:"file"` // path of the content in the archive
+}
+
+// ExportOptions selects what an export contains. The filters work like the
+// ones of /api/list. Images record no uploader, so there is no filter by user.
+type ExportOptions struct {
+	Type     string
+	DateFrom string
+	DateTo   string
+	Views    bool
+}
+
+// Validate checks the date filters
+func (o ExportOptions) Validate() error {
+	for _, date := range []string{o.DateFrom, o.DateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return errors.New("invalid date, expected YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+// ImportOptions controls an import
+type ImportOptions struct {
+	Conflict string
+}
+
+// Result is one image of an import
+type Result struct {
+	UUID    string `json:"uuid"`             // UUID in the archive
+	Stored  string `json:"stored,omitempty"` // UUID the image was stored under
+	Outcome string `json:"outcome"`          // imported, renamed, replaced or skipped
+	Reason  string `json:"reason,omitempty"` // why it was skipped or renamed
+}
+
+// ImportReport is the outcome of an import
+type ImportReport struct {
+	Source  string         `json:"source"`
+	Total   int            `json:"total"`
+	Counts  map[string]int `json:"counts"`
+	Results []Result       `json:"results"`
+}
+
+// Progress is called after each image with the number done and the total
+type Progress func(done, total int)
+
+// Transfer exports the library to archives and imports archives into it
+type Transfer struct {
+	config  *config.Config
+	db      *storage.DB
+	keyring *encryption.Keyring
+	scanner upload.Scanner
+}
+
+func New(cfg *config.Config, db *storage.DB, keyring *encryption.Keyring, scanner upload.Scanner) *Transfer {
+	return &Transfer{
+		config:  cfg,
+		db:      db,
+		keyring: keyring,
+		scanner: scanner,
+	}
+}
+
+// ValidateConflict checks an import conflict mode, defaulting to skip
+func ValidateConflict(mode string) (string, error) {
+	switch mode {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictRename, ConflictKeep:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid conflict mode %q (expected skip, rename or keep)", mode)
+	}
+}
+
+// Export writes an archive of the selected images to w. Images whose file is
+// missing or cannot be opened are left out and listed in the manifest.
+func (t *Transfer) Export(w io.Writer, opts ExportOptions, progress Progress) (*Manifest, error) {
+	images, err := t.db.ListImages(opts.Type, opts.DateFrom, opts.DateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Format:     Format,
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Source:     t.config.App.Domain,
+		Views:      opts.Views,
+		Images:     []Image{},
+	}
+
+	zw := zip.NewWriter(w)
+	for i := range images {
+		image := &images[i]
+		entry, err := t.exportImage(zw, image, opts)
+		var unreadable *unreadableError
+		switch {
+		case errors.As(err, &unreadable):
+			if manifest.Skipped == nil {
+				manifest.Skipped = map[string]string{}
+			}
+			manifest.Skipped[image.UUID] = unreadable.Error()
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", image.UUID, err)
+		default:
+			manifest.Images = append(manifest.Images, *entry)
+		}
+		if progress != nil {
+			progress(i+1, len(images))
+		}
+	}
+
+	mw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     manifestFile,
+		Method:   zip.Deflate,
+		Modified: manifest.ExportedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// unreadableError leaves an image out of an export without stopping it
+type unreadableError struct {
+	reason string
+}
+
+func (e *unreadableError) Error() string {
+	return e.reason
+}
+
+func (t *Transfer) exportImage(zw *zip.Writer, image *models.Image, opts ExportOptions) (*Image, error) {
+	// Nothing has been written for the image yet, so a file that cannot be
+	// opened is left out instead of failing the export
+	file, err := encryption.Open(storage.ImagePath(t.config.Storage.BasePath, image), t.keyring)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &unreadableError{"file is missing"}
+	}
+	if err != nil {
+		return nil, &unreadableError{err.Error()}
+	}
+	defer file.Close()
+
+	entry := &Image{
+		UUID:         image.UUID,
+		Filename:     image.Filename,
+		Extension:    image.Extension,
+		UploadedAt:   image.UploadedAt.UTC(),
+		IsPrivate:    image.IsPrivate,
+		PasswordHash: image.PasswordHash,
+		Views:        image.Views,
+		File:         path.Join(imagesDir, image.UUID+"."+image.Extension),
+	}
+	if opts.Views {
+		if entry.ViewsByDay, err = t.db.ImageViewAggregates(image.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Images are compressed already
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     entry.File,
+		Method:   zip.Store,
+		Modified: entry.UploadedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if entry.Size, err = io.Copy(io.MultiWriter(fw, hash), file); err != nil {
+		return nil, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, nil
+}
+
+// Import adds the images of an archive to the library. Images are validated
+// and scanned like uploads, and ones that fail are skipped.
+func (t *Transfer) Import(r io.ReaderAt, size int64, opts ImportOptions, progress Progress) (*ImportReport, error) {
+	conflict, err := ValidateConflict(opts.Conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readManifest(files[manifestFile])
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		Transfer: t,
+		conflict: conflict,
+		uuidRe:   regexp.MustCompile(t.config.App.UUIDFormat),
+	}
+	if imp.maxSize, err = t.config.GetMaxFileSize(); err != nil {
+		return nil, err
+	}
+	if imp.maxTotal, err = t.config.GetMaxStorage(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(t.config.Storage.BasePath, 0755); err != nil {
+		return nil, err
+	}
+	if imp.used, err = storage.Usage(t.config.Storage.BasePath); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{
+		Source:  manifest.Source,
+		Total:   len(manifest.Images),
+		Counts:  map[string]int{},
+		Results: []Result{},
+	}
+	for i := range manifest.Images {
+		entry := &manifest.Images[i]
+		result, err := imp.importImage(entry, files[entry.File])
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", entry.UUID, err)
+		}
+		report.Counts[result.Outcome]++
+		report.Results = append(report.Results, *result)
+		if progress != nil {
+			progress(i+1, len(manifest.Images))
+		}
+	}
+	return report, nil
+}
+
+func readManifest(f *zip.File) (*Manifest, error) {
+	if f == nil {
+		return nil, errors.New("archive has no manifest.json")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %w", err)
+	}
+	if manifest.Format != Format {
+		return nil, fmt.Errorf("not an export archive, format is %q", manifest.Format)
+	}
+	if manifest.Version > Version {
+		return nil, fmt.Errorf("archive version %d is newer than the supported version %d", manifest.Version, Version)
+	}
+	return &manifest, nil
+}
+
+type importer struct {
+	*Transfer
+	conflict string
+	uuidRe   *regexp.Regexp
+	maxSize  int64
+	maxTotal int64 // -1 when storage is FULL
+	used     int64
+}
+
+func skipped(entry *Image, format string, args ...interface{}) *Result {
+	return &Result{UUID: entry.UUID, Outcome: "skipped", Reason: fmt.Sprintf(format, args...)}
+}
+
+func (imp *importer) importImage(entry *Image, f *zip.File) (*Result, error) {
+	ext := strings.ToLower(entry.Extension)
+	allowed := false
+	for _, allowedExt := range imp.config.Storage.AllowedExtensions {
+		if ext == allowedExt {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return skipped(entry, "file type '.%s' not allowed", ext), nil
+	}
+	// The UUID names the stored file, whatever app.uuid_format allows
+	if strings.ContainsAny(entry.UUID, `/\`) || strings.Contains(entry.UUID, "..") {
+		return skipped(entry, "invalid UUID"), nil
+	}
+	if f == nil {
+		return skipped(entry, "%s is not in the archive", entry.File), nil
+	}
+	if int64(f.UncompressedSize64) > imp.maxSize {
+		return skipped(entry, "file too large"), nil
+	}
+	if imp.maxTotal != -1 && imp.used+int64(f.UncompressedSize64) > imp.maxTotal {
+		return skipped(entry, "maximum storage limit reached"), nil
+	}
+
+	// Extract to a temporary file first, the content has to be read more than
+	// once
+	tmp, err := os.CreateTemp(imp.config.Storage.BasePath, ".import-*")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), rc)
+	rc.Close()
+	if err != nil {
+		if errors.Is(err, zip.ErrChecksum) {
+			return skipped(entry, "corrupt in the archive"), nil
+		}
+		return nil, err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if entry.SHA256 != "" && digest != entry.SHA256 {
+		return skipped(entry, "checksum does not match the manifest"), nil
+	}
+
+	// Verify and scan the content like an upload
+	if _, known := upload.FormatForExtension(ext); known {
+		limits := upload.Limits{
+			MaxPixels:       imp.config.Uploads.MaxPixels,
+			MaxDimension:    imp.config.Uploads.MaxDimension,
+			AllowUnverified: imp.config.Uploads.AllowUnverified,
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := upload.Validate(tmp, ext, limits); err != nil {
+			var invalid *upload.InvalidError
+			if errors.As(err, &invalid) {
+				return skipped(entry, "not a valid .%s image: %s", ext, invalid.Reason), nil
+			}
+			return nil, err
+		}
+	} else if !imp.config.Uploads.AllowUnverified {
+		return skipped(entry, "file type '.%s' cannot be verified", ext), nil
+	}
+	if imp.scanner != nil {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		result, err := imp.scanner.Scan(context.Background(), tmp)
+		switch {
+		case err != nil && !imp.config.Uploads.Scanner.FailOpen:
+			return nil, fmt.Errorf("scan failed: %w", err)
+		case err == nil && !result.Clean:
+			return skipped(entry, "flagged by %s: %s", imp.scanner.Name(), result.Signature), nil
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{UUID: entry.UUID, Outcome: "imported"}
+	uuid := entry.UUID
+	var replace *models.Image
+	if !imp.uuidRe.MatchString(uuid) {
+		uuid = ""
+		result.Outcome, result.Reason = "renamed", "UUID does not match app.uuid_format"
+	} else if existing, err := imp.db.GetImage(uuid); err != nil {
+		return nil, err
+	} else if existing != nil {
+		switch {
+		case existing.SHA256 == digest:
+			return skipped(entry, "already imported"), nil
+		case imp.conflict == ConflictSkip:
+			return skipped(entry, "UUID is taken by a different image"), nil
+		case imp.conflict == ConflictRename:
+			uuid = ""
+			result.Outcome, result.Reason = "renamed", "UUID is taken by a different image"
+		case imp.conflict == ConflictKeep:
+			replace = existing
+			result.Outcome = "replaced"
+		}
+	}
+	if uuid == "" {
+		if uuid, err = utils.GenerateFormattedUUID(imp.config.App.UUIDFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	image := &models.Image{
+		UUID:         uuid,
+		Filename:     entry.Filename,
+		Extension:    ext,
+		Size:         size,
+		UploadedAt:   entry.UploadedAt,
+		IsPrivate:    entry.IsPrivate,
+		PasswordHash: entry.PasswordHash,
+		SHA256:       digest,
+	}
+	if image.UploadedAt.IsZero() {
+		image.UploadedAt = time.Now()
+	}
+	// Archives written by hand may carry the password itself
+	if image.PasswordHash != "" && !utils.IsPasswordHash(image.PasswordHash) {
+		if image.PasswordHash, err = utils.HashPassword(image.PasswordHash); err != nil {
+			return nil, err
+		}
+	}
+	// The file is staged next to its destination, and only moved into place
+	// once the record is written. A replaced image keeps its file and record
+	// until then.
+	dst := storage.ImagePath(imp.config.Storage.BasePath, image)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+	staged := filepath.Join(filepath.Dir(dst), ".import-"+uuid)
+	if imp.keyring != nil && imp.config.Encryption.Enabled {
+		err = imp.keyring.EncryptFile(tmpPath, staged)
+	} else {
+		err = os.Rename(tmpPath, staged)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged)
+	if err := os.Chmod(staged, 0644); err != nil {
+		return nil, err
+	}
+
+	if replace != nil {
+		err = imp.db.ReplaceImage(replace.ID, image)
+	} else {
+		err = imp.db.CreateImage(image)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staged, dst); err != nil {
+		if replace == nil {
+			// Do not leave a record without a file behind
+			imp.db.DeleteImageByID(image.ID)
+		}
+		return nil, err
+	}
+	// The image is replaced by now, a file that cannot be removed is left
+	// for fsck to report as an orphan
+	if replace != nil {
+		if old := storage.ImagePath(imp.config.Storage.BasePath, replace); old != dst {
+			os.Remove(old)
+		}
+	}
+	if entry.Views > 0 || len(entry.ViewsByDay) > 0 {
+		if err := imp.db.ImportImageViews(image.ID, entry.Views, entry.ViewsByDay); err != nil {
+			return nil, err
+		}
+	}
+
+	if stored, err := os.Stat(dst); err == nil {
+		imp.used += stored.Size()
+	}
+	result.Stored = uuid
+	return result, nil
+}
@@ -0,0 +1,589 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/health"
+	"sharex/internal/metrics"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// A backup is a directory named after the time it was taken. It holds a
+// snapshot of the database, a copy of the storage path under files/ and a
+// manifest with the checksum of each. Files unchanged since the previous
+// backup are hard links to its copy, so every backup is complete on its own
+// while only new or changed files take up space.
+const (
+	nameLayout   = "20060102T150405Z"
+	manifestFile = "manifest.json"
+	databaseFile = "database.db"
+	filesDir     = "files"
+	tmpPrefix    = ".tmp-"
+)
+
+// staleAfter is how old an unfinished backup directory must be before it is
+// considered left behind by a crash and removed
+const staleAfter = 24 * time.Hour
+
+// ErrRunning is returned when a backup is started while another is running
+var ErrRunning = errors.New("a backup is already running")
+
+// ErrDisabled is returned when no backup directory is configured
+var ErrDisabled = errors.New("backups are disabled, set backup.dir")
+
+// Entry is one file of a backup
+type Entry struct {
+	Path    string    `json:"path"` // relative to the storage path, or database.db
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Manifest describes a backup and lets it be verified
+type Manifest struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Base      string    `json:"base,omitempty"` // backup unchanged files were linked from
+	Database  Entry     `json:"database"`
+	Files     []Entry   `json:"files"`
+	Copied    int       `json:"copied"` // files new or changed since the base
+	Linked    int       `json:"linked"` // files shared with the base
+}
+
+// Size is the total size of the backed up files and database
+func (m *Manifest) Size() int64 {
+	total := m.Database.Size
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Manager takes backups, on demand and on the schedule in the backup section
+type Manager struct {
+	config    *config.Config
+	db        *storage.DB
+	logger    *utils.Logger
+	heartbeat *health.Heartbeat
+	running   sync.Mutex
+	stopChan  chan struct{}
+}
+
+func NewManager(cfg *config.Config, db *storage.DB, logger *utils.Logger) *Manager {
+	return &Manager{
+		config:    cfg,
+		db:        db,
+		logger:    logger,
+		heartbeat: health.NewHeartbeat("backup", time.Duration(cfg.Backup.Interval)*time.Hour),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start takes a backup every backup.interval hours. It does nothing when
+// backups are disabled or the interval is 0.
+func (m *Manager) Start() {
+	if m.config.Backup.Dir == "" || m.config.Backup.Interval <= 0 {
+		return
+	}
+
+	m.logger.Info("Starting backup routine", map[string]interface{}{
+		"dir":         m.config.Backup.Dir,
+		"interval":    m.config.Backup.Interval,
+		"keep_daily":  m.config.Backup.KeepDaily,
+		"keep_weekly": m.config.Backup.KeepWeekly,
+	})
+
+	go m.run()
+}
+
+func (m *Manager) run() {
+	defer m.heartbeat.Stop()
+
+	ticker := time.NewTicker(time.Duration(m.config.Backup.Interval) * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		m.heartbeat.Beat()
+		select {
+		case <-ticker.C:
+			m.Run()
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// Heartbeat reports whether the backup schedule is still running
+func (m *Manager) Heartbeat() *health.Heartbeat {
+	return m.heartbeat
+}
+
+// Close stops the schedule and waits for a backup that is running
+func (m *Manager) Close() {
+	close(m.stopChan)
+	m.running.Lock()
+	defer m.running.Unlock()
+}
+
+// Run takes a backup and then removes the ones retention no longer keeps
+func (m *Manager) Run() (*Manifest, error) {
+	if m.config.Backup.Dir == "" {
+		return nil, ErrDisabled
+	}
+	if !m.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer m.running.Unlock()
+
+	start := time.Now()
+	manifest, err := m.take(start.UTC())
+	if err != nil {
+		metrics.BackupFailures.Inc()
+		m.logger.Error("Backup failed", map[string]interface{}{
+			"error": err.Error(),
+			"dir":   m.config.Backup.Dir,
+		})
+		return nil, err
+	}
+
+	metrics.BackupLastSuccess.Set(float64(manifest.CreatedAt.Unix()))
+	m.logger.Info("Backup completed", map[string]interface{}{
+		"name":     manifest.Name,
+		"files":    len(manifest.Files),
+		"copied":   manifest.Copied,
+		"linked":   manifest.Linked,
+		"duration": time.Since(start).String(),
+	})
+
+	if err := m.prune(); err != nil {
+		m.logger.Error("Failed to remove old backups", map[string]interface{}{
+			"error": err.Error(),
+			"dir":   m.config.Backup.Dir,
+		})
+	}
+	return manifest, nil
+}
+
+// take writes a backup to a temporary directory and renames it into place
+// once it is complete, so an interrupted backup is never mistaken for one
+func (m *Manager) take(now time.Time) (*Manifest, error) {
+	dir := m.config.Backup.Dir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	name := now.Format(nameLayout)
+	if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+	tmp := filepath.Join(dir, tmpPrefix+name)
+	if err := os.MkdirAll(filepath.Join(tmp, filesDir), 0700); err != nil {
+		return nil, err
+	}
+	complete := false
+	defer func() {
+		if !complete {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	manifest := &Manifest{Name: name, CreatedAt: now, Files: []Entry{}}
+
+	// Unchanged files are linked from the newest backup
+	previous := map[string]Entry{}
+	var baseDir string
+	if names, err := List(dir); err != nil {
+		return nil, err
+	} else if len(names) > 0 {
+		base, err := ReadManifest(filepath.Join(dir, names[0]))
+		if err != nil {
+			m.logger.Warn("Ignoring unreadable previous backup, copying every file", map[string]interface{}{
+				"error":  err.Error(),
+				"backup": names[0],
+			})
+		} else {
+			manifest.Base = base.Name
+			baseDir = filepath.Join(dir, base.Name, filesDir)
+			for _, f := range base.Files {
+				previous[f.Path] = f
+			}
+		}
+	}
+
+	// The database goes first, files uploaded while the rest is copied end up
+	// as orphans a storage check can import
+	dbPath := filepath.Join(tmp, databaseFile)
+	if err := m.db.Snapshot(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to snapshot the database: %w", err)
+	}
+	entry, err := hashFile(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	entry.Path = databaseFile
+	manifest.Database = entry
+
+	base := m.config.Storage.BasePath
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		// Uploads in progress are staged as dotfiles
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		dst := filepath.Join(tmp, filesDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+
+		if prev, ok := previous[rel]; ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+			// Fall back to copying when the backup directory cannot hold
+			// hard links
+			if err := os.Link(filepath.Join(baseDir, filepath.FromSlash(rel)), dst); err == nil {
+				manifest.Files = append(manifest.Files, prev)
+				manifest.Linked++
+				return nil
+			}
+		}
+
+		entry, err := copyFile(path, dst)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Deleted since the walk reached it
+				return nil
+			}
+			return err
+		}
+		entry.Path = rel
+		manifest.Files = append(manifest.Files, entry)
+		manifest.Copied++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy files: %w", err)
+	}
+
+	if err := writeManifest(tmp, manifest); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return nil, err
+	}
+	complete = true
+	return manifest, nil
+}
+
+// prune removes backups retention no longer keeps, and unfinished ones left
+// behind by a crash. The newest backup is always kept.
+func (m *Manager) prune() error {
+	dir := m.config.Backup.Dir
+	names, err := List(dir)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, name := range names {
+		t, _ := time.Parse(nameLayout, name)
+		day := t.Format("2006-01-02")
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+
+		if i == 0 {
+			keep[name] = true
+		}
+		if !days[day] && len(days) < m.config.Backup.KeepDaily {
+			keep[name] = true
+			days[day] = true
+		}
+		if !weeks[weekKey] && len(weeks) < m.config.Backup.KeepWeekly {
+			keep[name] = true
+			weeks[weekKey] = true
+		}
+	}
+
+	for _, name := range names {
+		if keep[name] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		m.logger.Info("Removed old backup", map[string]interface{}{
+			"name": name,
+		})
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), tmpPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < staleAfter {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the names of the complete backups in dir, newest first
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(nameLayout, e.Name()); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// Resolve turns a backup name from dir, or a path to a backup directory, into
+// the backup's directory
+func Resolve(dir, nameOrPath string) (string, error) {
+	path := nameOrPath
+	if _, err := time.Parse(nameLayout, nameOrPath); err == nil && dir != "" {
+		path = filepath.Join(dir, nameOrPath)
+	}
+	if _, err := os.Stat(filepath.Join(path, manifestFile)); err != nil {
+		return "", fmt.Errorf("%s is not a backup: %w", nameOrPath, err)
+	}
+	return path, nil
+}
+
+// ReadManifest reads the manifest of the backup in dir
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Verify checks every file of the backup in dir against its manifest. It
+// returns the manifest and a description of each problem found.
+func Verify(dir string) (*Manifest, []string, error) {
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var problems []string
+	check := func(want Entry, path string) {
+		got, err := hashFile(path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", want.Path, err))
+		case got.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("%s: manifest says %d bytes, file has %d", want.Path, want.Size, got.Size))
+		case got.SHA256 != want.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: checksum does not match the manifest", want.Path))
+		}
+	}
+
+	check(manifest.Database, filepath.Join(dir, databaseFile))
+	for _, f := range manifest.Files {
+		check(f, filepath.Join(dir, filesDir, filepath.FromSlash(f.Path)))
+	}
+	return manifest, problems, nil
+}
+
+// Restore replaces the database and the storage path with the backup in
+// dir. It verifies the whole backup first and changes nothing if any file
+// fails. The server must not be running.
+//
+// The current database is kept next to the restored one with a .pre-restore
+// suffix. Files not in the backup are left in place, a storage check reports
+// them as orphans.
+func Restore(cfg *config.Config, dir string) (*Manifest, error) {
+	if cfg.Database.Driver != config.DatabaseSQLite {
+		return nil, fmt.Errorf("restore needs the sqlite database driver")
+	}
+
+	manifest, problems, err := Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("backup failed verification, nothing was restored: %s", strings.Join(problems, "; "))
+	}
+
+	// Files first, so the database is only replaced once every file is in
+	// place
+	base := cfg.Storage.BasePath
+	for _, f := range manifest.Files {
+		dst := filepath.Join(base, filepath.FromSlash(f.Path))
+		if current, err := hashFile(dst); err == nil && current.SHA256 == f.SHA256 {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+		if err := restoreFile(filepath.Join(dir, filesDir, filepath.FromSlash(f.Path)), dst, f.ModTime); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+	}
+
+	// Keep the current database, and remove its journal files so they are
+	// not applied to the restored one
+	dbFile := cfg.Database.File
+	if _, err := os.Stat(dbFile); err == nil {
+		if _, err := copyFile(dbFile, dbFile+".pre-restore"); err != nil {
+			return nil, err
+		}
+	}
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dbFile + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := restoreFile(filepath.Join(dir, databaseFile), dbFile, manifest.Database.ModTime); err != nil {
+		return nil, fmt.Errorf("failed to restore the database: %w", err)
+	}
+	return manifest, nil
+}
+
+// restoreFile copies src over dst through a temporary file, so dst is never
+// left half written. Files that cannot be replaced, like a database file
+// bind mounted into a container, are overwritten in place instead.
+func restoreFile(src, dst string, modTime time.Time) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".restore")
+	defer os.Remove(tmp)
+
+	if _, err := copyFile(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if _, err := copyFile(tmp, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, keeping its modification time, and returns the
+// entry for what was copied
+func copyFile(src, dst string) (Entry, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return Entry{}, err
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Size:    written,
+		ModTime: info.ModTime().UTC(),
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// hashFile returns the size, modification time and checksum of a file
+func hashFile(path string) (Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Size:    size,
+		ModTime: info.ModTime().UTC(),
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func writeManifest(dir string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, manifestFile), data, 0600)
+}
@@ -2,8 +2,15 @@ package config
 
 import (
 	"fmt"
+	"math/rand/v2"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"sharex/internal/size"
 
@@ -12,32 +19,150 @@ import (
 
 type Config struct {
 	App struct {
-		Environment      string `yaml:"environment"`
-		Port             int    `yaml:"port"`
-		Domain           string `yaml:"domain"`
-		JWTSecret        string `yaml:"jwt_secret"`
-		MaxFileSize      string `yaml:"max_file_size"`
-		UUIDFormat       string `yaml:"uuid_format"`
-		UploadKey        string `yaml:"upload_key"`
-		IPInfoToken      string `yaml:"ipinfo_token"`
-		EnableIPTracking bool   `yaml:"enable_ip_tracking"`
+		Environment      string   `yaml:"environment"`
+		Port             int      `yaml:"port"`
+		Domain           string   `yaml:"domain"`
+		JWTSecret        string   `yaml:"jwt_secret" secret:"true"`
+		MaxFileSize      string   `yaml:"max_file_size"`
+		UUIDFormat       string   `yaml:"uuid_format"`
+		UploadKey        string   `yaml:"upload_key" secret:"true"`
+		IPInfoToken      string   `yaml:"ipinfo_token" secret:"true"`
+		EnableIPTracking bool     `yaml:"enable_ip_tracking"`
+		TOTPIssuer       string   `yaml:"totp_issuer"`     // issuer shown in authenticator apps
+		TrustedProxies   []string `yaml:"trusted_proxies"` // IPs or CIDRs whose forwarding headers are believed
 	} `yaml:"app"`
 
+	Server struct {
+		ReadHeaderTimeout int `yaml:"read_header_timeout"` // seconds to receive the request headers
+		ReadTimeout       int `yaml:"read_timeout"`        // seconds to receive a whole request, including an upload
+		WriteTimeout      int `yaml:"write_timeout"`       // seconds to send a response, event streams and exports are exempt
+		IdleTimeout       int `yaml:"idle_timeout"`        // seconds a keep-alive connection waits for the next request
+		ShutdownTimeout   int `yaml:"shutdown_timeout"`    // seconds in-flight requests get to finish on SIGTERM
+		TLS               struct {
+			CertFile string `yaml:"cert_file"` // PEM certificate chain, serves HTTPS when set
+			KeyFile  string `yaml:"key_file"`  // PEM private key
+		} `yaml:"tls"`
+	} `yaml:"server"`
+
 	User struct {
 		Username string `yaml:"username"`
-		Password string `yaml:"password"`
+		Password string `yaml:"password" secret:"true"`
 	} `yaml:"user"`
 
 	Database struct {
-		File string `yaml:"file"` // Database file path
+		Driver string `yaml:"driver"`           // sqlite or postgres
+		File   string `yaml:"file"`             // Database file path, used by sqlite
+		URL    string `yaml:"url" secret:"url"` // connection URL, used by postgres
 	} `yaml:"database"`
 
 	Storage struct {
 		BasePath          string   `yaml:"base_path"`
 		AllowedExtensions []string `yaml:"allowed_extensions"`
 		MaxStorage        string   `yaml:"max_storage"`
+		QuotaWarning      int      `yaml:"quota_warning"` // percent of max_storage that triggers a quota warning, 0 disables it
 	} `yaml:"storage"`
 
+	Uploads struct {
+		MaxPixels       int64  `yaml:"max_pixels"`       // width * height of an uploaded image
+		MaxDimension    int    `yaml:"max_dimension"`    // longest side of an uploaded image
+		AllowUnverified bool   `yaml:"allow_unverified"` // accept allowed extensions the content validator cannot decode
+		QuarantineDir   string `yaml:"quarantine_dir"`   // where flagged uploads are kept, outside base_path
+		Scanner         struct {
+			Type     string `yaml:"type"`      // empty for none, or clamd
+			Address  string `yaml:"address"`   // unix:/path, /path or tcp://host:port
+			Timeout  int    `yaml:"timeout"`   // seconds per scan
+			FailOpen bool   `yaml:"fail_open"` // accept uploads when the scanner is unreachable
+		} `yaml:"scanner"`
+	} `yaml:"uploads"`
+
+	Encryption struct {
+		Enabled          bool     `yaml:"enabled"`            // encrypt new uploads and migrate existing files in the background
+		KeyFile          string   `yaml:"key_file"`           // file holding the 32-byte master key, hex or base64
+		KeyEnv           string   `yaml:"key_env"`            // environment variable holding the key when key_file is empty
+		PreviousKeyFiles []string `yaml:"previous_key_files"` // retired master keys that can still unwrap files during a rotation
+		MigrateBatch     int      `yaml:"migrate_batch"`      // files migrated per background pass
+		MigrateInterval  int      `yaml:"migrate_interval"`   // seconds between background passes
+	} `yaml:"encryption"`
+
+	Share struct {
+		UnlockDuration   int `yaml:"unlock_duration"`    // minutes an unlocked private image stays viewable in that browser
+		LinkTTL          int `yaml:"link_ttl"`           // days signed embed links stay valid, 0 until the password changes
+		MaxAttempts      int `yaml:"max_attempts"`       // wrong passwords per image and IP before unlocking is paused
+		MaxImageAttempts int `yaml:"max_image_attempts"` // wrong passwords per image from all IPs before unlocking is paused, -1 never pauses
+		AttemptWindow    int `yaml:"attempt_window"`     // minutes failures are counted and unlocking stays paused
+	} `yaml:"share"`
+
+	Fsck struct {
+		Interval  int      `yaml:"interval"`  // hours between background checks, 0 disables them
+		Checksums bool     `yaml:"checksums"` // read every file to detect checksum drift
+		Repair    []string `yaml:"repair"`    // repair actions the background check applies
+	} `yaml:"fsck"`
+
+	Backup struct {
+		Dir        string `yaml:"dir"`         // directory backups are written to, empty disables backups
+		Interval   int    `yaml:"interval"`    // hours between scheduled backups, 0 disables the schedule
+		KeepDaily  int    `yaml:"keep_daily"`  // keep the newest backup of each of the last N days
+		KeepWeekly int    `yaml:"keep_weekly"` // keep the newest backup of each of the last N weeks
+	} `yaml:"backup"`
+
+	Analytics struct {
+		IPAnonymization string `yaml:"ip_anonymization"`       // none, truncate or hash
+		HashKey         string `yaml:"hash_key" secret:"true"` // HMAC key used when ip_anonymization is hash
+		RetentionDays   int    `yaml:"retention_days"`         // 0 keeps raw view rows forever
+		PurgeInterval   int    `yaml:"purge_interval"`         // minutes between purge runs
+	} `yaml:"analytics"`
+
+	Events struct {
+		HeartbeatInterval int `yaml:"heartbeat_interval"` // seconds between keep-alive comments
+		SubscriberBuffer  int `yaml:"subscriber_buffer"`  // events buffered per subscriber before it is dropped
+		HistorySize       int `yaml:"history_size"`       // events kept for Last-Event-ID resume
+	} `yaml:"events"`
+
+	Health struct {
+		Timeout     int    `yaml:"timeout"`       // seconds each check of /healthz and /readyz may take
+		MinFreeDisk string `yaml:"min_free_disk"` // free space the storage volume needs for /readyz to pass, 0 disables the check
+	} `yaml:"health"`
+
+	Metrics struct {
+		Enabled    bool     `yaml:"enabled"`
+		Token      string   `yaml:"token" secret:"true"` // Bearer token required to scrape /metrics
+		AllowedIPs []string `yaml:"allowed_ips"`         // IPs or CIDRs allowed to scrape without a token
+	} `yaml:"metrics"`
+
+	Webhooks struct {
+		Timeout       int `yaml:"timeout"`        // seconds per delivery attempt
+		MaxAttempts   int `yaml:"max_attempts"`   // attempts before a delivery is marked failed
+		RetryDelay    int `yaml:"retry_delay"`    // seconds before the first retry, doubled on every attempt
+		RetentionDays int `yaml:"retention_days"` // days succeeded and failed deliveries are kept, 0 keeps them forever
+	} `yaml:"webhooks"`
+
+	LoginProtection struct {
+		FreeAttempts       int `yaml:"free_attempts"`        // failures before delays start
+		BaseDelay          int `yaml:"base_delay"`           // seconds, doubled after every further failure
+		MaxDelay           int `yaml:"max_delay"`            // seconds
+		LockoutThreshold   int `yaml:"lockout_threshold"`    // failures that lock an account
+		IPLockoutThreshold int `yaml:"ip_lockout_threshold"` // failures that lock out a client IP
+		LockoutDuration    int `yaml:"lockout_duration"`     // minutes
+		Window             int `yaml:"window"`               // minutes without failures after which the count resets
+	} `yaml:"login_protection"`
+
+	OIDC struct {
+		Enabled              bool              `yaml:"enabled"`
+		Issuer               string            `yaml:"issuer"`
+		ClientID             string            `yaml:"client_id"`
+		ClientSecret         string            `yaml:"client_secret" secret:"true"` // optional for public clients, PKCE is always used
+		RedirectURL          string            `yaml:"redirect_url"`                // defaults to the callback on app.domain
+		Scopes               []string          `yaml:"scopes"`
+		AllowedDomains       []string          `yaml:"allowed_domains"` // email domains allowed to sign in, empty allows any
+		AllowedGroups        []string          `yaml:"allowed_groups"`  // groups allowed to sign in, empty allows any
+		GroupsClaim          string            `yaml:"groups_claim"`
+		RoleClaim            string            `yaml:"role_claim"`   // claim whose values are looked up in role_mapping
+		RoleMapping          map[string]string `yaml:"role_mapping"` // claim value to role
+		DefaultRole          string            `yaml:"default_role"`
+		DisablePasswordLogin bool              `yaml:"disable_password_login"`
+		LinkLocalAccounts    bool              `yaml:"link_local_accounts"` // let a verified email sign in to the local account of that name
+	} `yaml:"oidc"`
+
 	Logging struct {
 		Enabled bool   `yaml:"enabled"`
 		LogDir  string `yaml:"log_dir"`
@@ -74,20 +199,51 @@ type Config struct {
 		AllowedHeaders []string `yaml:"allowed_headers"`
 	} `yaml:"cors"`
 
-	RateLimit struct {
-		Enabled     bool   `yaml:"enabled"`
-		RedisURL    string `yaml:"redis_url"`
-		DefaultRate struct {
-			Requests int   `yaml:"requests"`
-			Period   int64 `yaml:"period"` // in seconds
-		} `yaml:"default_rate"`
-		Routes map[string]struct {
-			Requests int   `yaml:"requests"`
-			Period   int64 `yaml:"period"` // in seconds
-		} `yaml:"routes"`
-	} `yaml:"rate_limit"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	path      string   // file the configuration was read from
+	overrides []string // environment variables that overrode settings
+}
+
+// RateLimitConfig is the rate_limit section, shared with the startup banner
+type RateLimitConfig struct {
+	Enabled     bool                     `yaml:"enabled"`
+	Store       string                   `yaml:"store"`                  // memory or redis
+	RedisURL    string                   `yaml:"redis_url" secret:"url"` // used by the redis store
+	Algorithm   string                   `yaml:"algorithm"`              // sliding_window or token_bucket
+	Fallback    string                   `yaml:"fallback"`               // memory, allow or deny while Redis is unreachable
+	DefaultRate RateLimitRule            `yaml:"default_rate"`
+	Routes      map[string]RateLimitRule `yaml:"routes"`       // keyed by path pattern, optionally prefixed with a method
+	ExemptIPs   []string                 `yaml:"exempt_ips"`   // IPs or CIDRs that are never limited
+	ExemptUsers []string                 `yaml:"exempt_users"` // usernames that are never limited
+}
+
+// RateLimitRule is the limit applied to requests matching a route pattern
+type RateLimitRule struct {
+	Requests int    `yaml:"requests"`
+	Period   int64  `yaml:"period"` // in seconds
+	Burst    int    `yaml:"burst"`  // extra requests allowed at once
+	Key      string `yaml:"key"`    // ip, user or token
 }
 
+// IP anonymization modes for recorded views
+const (
+	IPAnonymizationNone     = "none"
+	IPAnonymizationTruncate = "truncate"
+	IPAnonymizationHash     = "hash"
+)
+
+// Database drivers
+const (
+	DatabaseSQLite   = "sqlite"
+	DatabasePostgres = "postgres"
+)
+
+// Upload scanner types
+const (
+	ScannerClamd = "clamd"
+)
+
 // GetMaxFileSize returns the max file size in bytes
 func (c *Config) GetMaxFileSize() (int64, error) {
 	return size.Parse(c.App.MaxFileSize)
@@ -106,6 +262,15 @@ func (c *Config) GetMaxStorage() (int64, error) {
 	return size.Parse(c.Storage.MaxStorage)
 }
 
+// GetMinFreeDisk returns the free disk space readiness requires in bytes, 0
+// when the check is disabled
+func (c *Config) GetMinFreeDisk() (int64, error) {
+	if c.Health.MinFreeDisk == "0" {
+		return 0, nil
+	}
+	return size.Parse(c.Health.MinFreeDisk)
+}
+
 // IsFullStorageAllowed returns true if storage is set to "FULL"
 func (c *Config) IsFullStorageAllowed() bool {
 	return c.Storage.MaxStorage == "FULL"
@@ -119,40 +284,758 @@ func (c *Config) GetStorageLimit() string {
 	return c.Storage.MaxStorage
 }
 
+// LoadConfig reads the configuration file at path, applies the LLMSTOR_*
+// environment overrides and validates the result
 func LoadConfig(path string) (*Config, error) {
 	file, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
+	// Reject keys that are not settings, most likely typos
+	var node yaml.Node
+	if err := yaml.Unmarshal(file, &node); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	var unknown []string
+	checkKeys(&node, reflect.TypeOf(Config{}), "", &unknown)
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown keys in config file: %s", strings.Join(unknown, ", "))
+	}
+
+	config := Config{path: path}
 	if err := yaml.Unmarshal(file, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	// Apply environment overrides
+	if config.overrides, err = applyEnv(reflect.ValueOf(&config).Elem(), EnvPrefix); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// validate checks every section and fills in defaults. It can run again on
+// a configuration it has already been run on.
+func (c *Config) validate() error {
 	// Validate size values
-	if _, err := config.GetMaxFileSize(); err != nil {
-		return nil, fmt.Errorf("invalid max_file_size: %w", err)
+	if _, err := c.GetMaxFileSize(); err != nil {
+		return fmt.Errorf("invalid max_file_size: %w", err)
+	}
+	if _, err := c.GetMaxLogSize(); err != nil {
+		return fmt.Errorf("invalid max_log_size: %w", err)
 	}
-	if _, err := config.GetMaxLogSize(); err != nil {
-		return nil, fmt.Errorf("invalid max_log_size: %w", err)
+	if _, err := c.GetMaxStorage(); err != nil {
+		return fmt.Errorf("invalid max_storage: %w", err)
 	}
-	if _, err := config.GetMaxStorage(); err != nil {
-		return nil, fmt.Errorf("invalid max_storage: %w", err)
+	if c.Storage.QuotaWarning < 0 || c.Storage.QuotaWarning > 100 {
+		return fmt.Errorf("storage.quota_warning must be between 0 and 100")
 	}
 
-	// Create necessary directories
-	if err := os.MkdirAll(config.Storage.BasePath, 0755); err != nil {
-		return nil, err
+	if c.App.TOTPIssuer == "" {
+		c.App.TOTPIssuer = "llmstor"
+	}
+
+	// Validate application settings
+	if err := c.validateApp(); err != nil {
+		return err
+	}
+
+	// Validate HTTP server settings
+	if err := c.validateServer(); err != nil {
+		return err
+	}
+
+	// Validate database settings
+	if err := c.validateDatabase(); err != nil {
+		return err
+	}
+
+	// Validate upload content checks
+	if err := c.validateUploads(); err != nil {
+		return err
+	}
+
+	// Validate encryption at rest settings
+	if err := c.validateEncryption(); err != nil {
+		return err
+	}
+
+	// Validate private share settings
+	if err := c.validateShare(); err != nil {
+		return err
+	}
+
+	// Validate storage check settings
+	if err := c.validateFsck(); err != nil {
+		return err
+	}
+
+	// Validate backup settings
+	if err := c.validateBackup(); err != nil {
+		return err
+	}
+
+	// Validate analytics settings
+	if err := c.validateAnalytics(); err != nil {
+		return err
+	}
+
+	// Validate live event settings
+	if err := c.validateEvents(); err != nil {
+		return err
 	}
 
-	if config.Logging.Enabled {
-		if err := os.MkdirAll(config.Logging.LogDir, 0755); err != nil {
-			return nil, err
+	// Validate health probe settings
+	if err := c.validateHealth(); err != nil {
+		return err
+	}
+
+	// Validate metrics settings
+	if err := c.validateMetrics(); err != nil {
+		return err
+	}
+
+	// Validate webhook settings
+	if err := c.validateWebhooks(); err != nil {
+		return err
+	}
+
+	// Validate trusted proxies
+	for _, entry := range c.App.TrustedProxies {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid app.trusted_proxies entry: %q", entry)
 		}
 	}
 
-	return &config, nil
+	// Validate rate limiting settings
+	if err := c.validateRateLimit(); err != nil {
+		return err
+	}
+
+	// Validate login brute-force protection settings
+	if err := c.validateLoginProtection(); err != nil {
+		return err
+	}
+
+	// Validate single sign-on settings
+	if err := c.validateOIDC(); err != nil {
+		return err
+	}
+
+	// Validate secrets last, some are only required by enabled features
+	return c.validateSecrets()
+}
+
+// CreateDirectories creates the storage, quarantine and log directories
+func (c *Config) CreateDirectories() error {
+	if err := os.MkdirAll(c.Storage.BasePath, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Uploads.QuarantineDir, 0700); err != nil {
+		return err
+	}
+
+	if c.Logging.Enabled {
+		if err := os.MkdirAll(c.Logging.LogDir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateApp checks the environment and the id format of uploads
+func (c *Config) validateApp() error {
+	switch c.App.Environment {
+	case "", "development", "production":
+	default:
+		return fmt.Errorf("invalid app.environment: %q (expected development or production)", c.App.Environment)
+	}
+
+	if c.App.UUIDFormat == "" {
+		return fmt.Errorf("app.uuid_format is required")
+	}
+	re, err := regexp.Compile(c.App.UUIDFormat)
+	if err != nil {
+		return fmt.Errorf("invalid app.uuid_format: %w", err)
+	}
+
+	// New ids are 10 random letters and digits, drawn again until one
+	// matches, so a format that hardly ever matches would hang uploads
+	for i := 0; i < 1000; i++ {
+		if re.MatchString(sampleID()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("app.uuid_format %q does not match generated ids, which are 10 letters and digits", c.App.UUIDFormat)
+}
+
+// sampleID returns an id like the ones uploads get
+func sampleID() string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	id := make([]byte, 10)
+	for i := range id {
+		id[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(id)
+}
+
+// weakSecrets are the placeholders of the example configuration and other
+// values that must not protect a production instance
+var weakSecrets = []string{
+	"your-secret-key-here",
+	"your-upload-key",
+	"password",
+	"changeme",
+	"secret",
+}
+
+// validateSecrets rejects missing, placeholder and short secrets in
+// production
+func (c *Config) validateSecrets() error {
+	if c.App.Environment != "production" {
+		return nil
+	}
+
+	secrets := []struct {
+		name      string
+		value     string
+		required  bool
+		minLength int
+	}{
+		{"app.jwt_secret", c.App.JWTSecret, true, 32},
+		{"app.upload_key", c.App.UploadKey, true, 16},
+		{"user.password", c.User.Password, false, 8},
+		{"metrics.token", c.Metrics.Token, false, 16},
+		{"analytics.hash_key", c.Analytics.HashKey, false, 16},
+	}
+	for _, s := range secrets {
+		if s.value == "" {
+			if s.required {
+				return fmt.Errorf("%s is required in production", s.name)
+			}
+			continue
+		}
+		for _, weak := range weakSecrets {
+			if strings.EqualFold(s.value, weak) {
+				return fmt.Errorf("%s is a placeholder value, set a random one in production (e.g. openssl rand -hex 32)", s.name)
+			}
+		}
+		if len(s.value) < s.minLength {
+			return fmt.Errorf("%s must be at least %d characters in production", s.name, s.minLength)
+		}
+	}
+	return nil
+}
+
+// validateServer checks the server section and fills in defaults
+func (c *Config) validateServer() error {
+	s := &c.Server
+	timeouts := []struct {
+		name     string
+		value    *int
+		fallback int
+	}{
+		{"read_header_timeout", &s.ReadHeaderTimeout, 10},
+		{"read_timeout", &s.ReadTimeout, 300},
+		{"write_timeout", &s.WriteTimeout, 300},
+		{"idle_timeout", &s.IdleTimeout, 120},
+		{"shutdown_timeout", &s.ShutdownTimeout, 30},
+	}
+	for _, t := range timeouts {
+		if *t.value < 0 {
+			return fmt.Errorf("server.%s must not be negative", t.name)
+		}
+		if *t.value == 0 {
+			*t.value = t.fallback
+		}
+	}
+
+	if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		return fmt.Errorf("server.tls.cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// validateDatabase checks the database section and fills in defaults
+func (c *Config) validateDatabase() error {
+	switch c.Database.Driver {
+	case "":
+		c.Database.Driver = DatabaseSQLite
+		fallthrough
+	case DatabaseSQLite:
+		if c.Database.File == "" {
+			return fmt.Errorf("database.file is required when driver is sqlite")
+		}
+	case DatabasePostgres:
+		if c.Database.URL == "" {
+			return fmt.Errorf("database.url is required when driver is postgres")
+		}
+	default:
+		return fmt.Errorf("invalid database.driver: %q (expected sqlite or postgres)", c.Database.Driver)
+	}
+	return nil
+}
+
+// DatabaseSource returns what the database driver connects to, the file for
+// sqlite and the connection URL for postgres
+func (c *Config) DatabaseSource() string {
+	if c.Database.Driver == DatabasePostgres {
+		return c.Database.URL
+	}
+	return c.Database.File
+}
+
+// validateUploads checks the uploads section and fills in defaults
+func (c *Config) validateUploads() error {
+	u := &c.Uploads
+	if u.MaxPixels < 0 || u.MaxDimension < 0 || u.Scanner.Timeout < 0 {
+		return fmt.Errorf("uploads settings must not be negative")
+	}
+	if u.MaxPixels == 0 {
+		u.MaxPixels = 50_000_000
+	}
+	if u.MaxDimension == 0 {
+		u.MaxDimension = 16384
+	}
+	if u.QuarantineDir == "" {
+		u.QuarantineDir = "./quarantine"
+	}
+
+	base, err := filepath.Abs(c.Storage.BasePath)
+	if err != nil {
+		return err
+	}
+	quarantine, err := filepath.Abs(u.QuarantineDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(base, quarantine); err == nil && (rel == "." || !strings.HasPrefix(rel, "..")) {
+		return fmt.Errorf("uploads.quarantine_dir must be outside storage.base_path")
+	}
+
+	switch u.Scanner.Type {
+	case "":
+	case ScannerClamd:
+		addr := u.Scanner.Address
+		if !strings.HasPrefix(addr, "unix:") && !strings.HasPrefix(addr, "tcp://") && !strings.HasPrefix(addr, "/") {
+			return fmt.Errorf("uploads.scanner.address must be unix:<path>, an absolute socket path or tcp://host:port")
+		}
+		if u.Scanner.Timeout == 0 {
+			u.Scanner.Timeout = 30
+		}
+	default:
+		return fmt.Errorf("invalid uploads.scanner.type: %q (expected clamd or empty)", u.Scanner.Type)
+	}
+	return nil
+}
+
+// validateEncryption checks the encryption section and fills in defaults. The
+// keys themselves are read when the server starts.
+func (c *Config) validateEncryption() error {
+	e := &c.Encryption
+	if e.MigrateBatch < 0 || e.MigrateInterval < 0 {
+		return fmt.Errorf("encryption settings must not be negative")
+	}
+	if e.KeyEnv == "" {
+		e.KeyEnv = "LLMSTOR_ENCRYPTION_KEY"
+	}
+	if e.MigrateBatch == 0 {
+		e.MigrateBatch = 50
+	}
+	if e.MigrateInterval == 0 {
+		e.MigrateInterval = 10
+	}
+	if len(e.PreviousKeyFiles) > 0 && e.KeyFile == "" && os.Getenv(e.KeyEnv) == "" {
+		return fmt.Errorf("encryption.previous_key_files requires a current key")
+	}
+	return nil
+}
+
+// validateShare checks the share section and fills in defaults
+func (c *Config) validateShare() error {
+	s := &c.Share
+	if s.UnlockDuration < 0 || s.LinkTTL < 0 || s.MaxAttempts < 0 || s.AttemptWindow < 0 {
+		return fmt.Errorf("share settings must not be negative")
+	}
+	if s.MaxImageAttempts < -1 {
+		return fmt.Errorf("share.max_image_attempts must be -1 or more")
+	}
+	if s.UnlockDuration == 0 {
+		s.UnlockDuration = 1440
+	}
+	if s.MaxAttempts == 0 {
+		s.MaxAttempts = 5
+	}
+	if s.MaxImageAttempts == 0 {
+		s.MaxImageAttempts = 50
+	}
+	if s.AttemptWindow == 0 {
+		s.AttemptWindow = 15
+	}
+	return nil
+}
+
+// Repair actions of the storage check
+const (
+	RepairRecordChecksums = "record_checksums"
+	RepairImportOrphans   = "import_orphans"
+	RepairDeleteOrphans   = "delete_orphans"
+	RepairMarkMissing     = "mark_missing"
+	RepairDeleteMissing   = "delete_missing"
+)
+
+// RepairActions lists every repair action of the storage check
+var RepairActions = []string{
+	RepairRecordChecksums,
+	RepairImportOrphans,
+	RepairDeleteOrphans,
+	RepairMarkMissing,
+	RepairDeleteMissing,
+}
+
+// ValidateRepairActions rejects unknown and conflicting repair actions
+func ValidateRepairActions(actions []string) error {
+	set := map[string]bool{}
+	for _, action := range actions {
+		known := false
+		for _, candidate := range RepairActions {
+			if action == candidate {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown repair action %q (expected one of %s)", action, strings.Join(RepairActions, ", "))
+		}
+		set[action] = true
+	}
+	if set[RepairImportOrphans] && set[RepairDeleteOrphans] {
+		return fmt.Errorf("repair actions %s and %s cannot be combined", RepairImportOrphans, RepairDeleteOrphans)
+	}
+	if set[RepairMarkMissing] && set[RepairDeleteMissing] {
+		return fmt.Errorf("repair actions %s and %s cannot be combined", RepairMarkMissing, RepairDeleteMissing)
+	}
+	return nil
+}
+
+// validateFsck checks the fsck section
+func (c *Config) validateFsck() error {
+	if c.Fsck.Interval < 0 {
+		return fmt.Errorf("fsck.interval must not be negative")
+	}
+	if err := ValidateRepairActions(c.Fsck.Repair); err != nil {
+		return fmt.Errorf("invalid fsck.repair: %w", err)
+	}
+	return nil
+}
+
+// validateBackup checks the backup section
+func (c *Config) validateBackup() error {
+	if c.Backup.Interval < 0 || c.Backup.KeepDaily < 0 || c.Backup.KeepWeekly < 0 {
+		return fmt.Errorf("backup.interval, keep_daily and keep_weekly must not be negative")
+	}
+	if c.Backup.Dir == "" {
+		return nil
+	}
+	if c.Database.Driver != DatabaseSQLite {
+		return fmt.Errorf("backup.dir needs the sqlite database driver, back up PostgreSQL with pg_dump instead")
+	}
+
+	// Backups inside the storage path would be backed up themselves
+	dir, err := filepath.Abs(c.Backup.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid backup.dir: %w", err)
+	}
+	base, err := filepath.Abs(c.Storage.BasePath)
+	if err != nil {
+		return fmt.Errorf("invalid storage.base_path: %w", err)
+	}
+	if rel, err := filepath.Rel(base, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("backup.dir must not be inside storage.base_path")
+	}
+	return nil
+}
+
+// validateAnalytics checks the analytics section and fills in defaults
+func (c *Config) validateAnalytics() error {
+	switch c.Analytics.IPAnonymization {
+	case "":
+		c.Analytics.IPAnonymization = IPAnonymizationNone
+	case IPAnonymizationNone, IPAnonymizationTruncate:
+	case IPAnonymizationHash:
+		if c.Analytics.HashKey == "" {
+			return fmt.Errorf("analytics.hash_key is required when ip_anonymization is hash")
+		}
+	default:
+		return fmt.Errorf("invalid analytics.ip_anonymization: %q (expected none, truncate or hash)", c.Analytics.IPAnonymization)
+	}
+
+	if c.Analytics.RetentionDays < 0 {
+		return fmt.Errorf("analytics.retention_days must not be negative")
+	}
+	if c.Analytics.PurgeInterval < 0 {
+		return fmt.Errorf("analytics.purge_interval must not be negative")
+	}
+	if c.Analytics.PurgeInterval == 0 {
+		c.Analytics.PurgeInterval = 60
+	}
+
+	return nil
+}
+
+// validateEvents checks the events section and fills in defaults
+func (c *Config) validateEvents() error {
+	if c.Events.HeartbeatInterval < 0 || c.Events.SubscriberBuffer < 0 || c.Events.HistorySize < 0 {
+		return fmt.Errorf("events settings must not be negative")
+	}
+	if c.Events.HeartbeatInterval == 0 {
+		c.Events.HeartbeatInterval = 15
+	}
+	if c.Events.SubscriberBuffer == 0 {
+		c.Events.SubscriberBuffer = 64
+	}
+	if c.Events.HistorySize == 0 {
+		c.Events.HistorySize = 256
+	}
+	return nil
+}
+
+// validateHealth checks the health section and fills in defaults
+func (c *Config) validateHealth() error {
+	if c.Health.Timeout < 0 {
+		return fmt.Errorf("health.timeout must not be negative")
+	}
+	if c.Health.Timeout == 0 {
+		c.Health.Timeout = 2
+	}
+	if c.Health.MinFreeDisk == "" {
+		c.Health.MinFreeDisk = "500MB"
+	}
+	if _, err := c.GetMinFreeDisk(); err != nil {
+		return fmt.Errorf("invalid health.min_free_disk: %w", err)
+	}
+	return nil
+}
+
+// validateMetrics makes sure an enabled metrics endpoint is protected
+func (c *Config) validateMetrics() error {
+	if !c.Metrics.Enabled {
+		return nil
+	}
+	if c.Metrics.Token == "" && len(c.Metrics.AllowedIPs) == 0 {
+		return fmt.Errorf("metrics requires a token or allowed_ips when enabled")
+	}
+	for _, entry := range c.Metrics.AllowedIPs {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid metrics.allowed_ips entry: %q", entry)
+		}
+	}
+	return nil
+}
+
+// validateWebhooks checks the webhooks section and fills in defaults
+func (c *Config) validateWebhooks() error {
+	if c.Webhooks.Timeout < 0 || c.Webhooks.MaxAttempts < 0 || c.Webhooks.RetryDelay < 0 || c.Webhooks.RetentionDays < 0 {
+		return fmt.Errorf("webhooks settings must not be negative")
+	}
+	if c.Webhooks.Timeout == 0 {
+		c.Webhooks.Timeout = 10
+	}
+	if c.Webhooks.MaxAttempts == 0 {
+		c.Webhooks.MaxAttempts = 8
+	}
+	if c.Webhooks.RetryDelay == 0 {
+		c.Webhooks.RetryDelay = 30
+	}
+	return nil
+}
+
+// validateRateLimit checks the rate limiting section and fills in defaults
+func (c *Config) validateRateLimit() error {
+	rl := &c.RateLimit
+
+	switch rl.Store {
+	case "":
+		rl.Store = "memory"
+		if rl.RedisURL != "" {
+			rl.Store = "redis"
+		}
+	case "memory", "redis":
+	default:
+		return fmt.Errorf("invalid rate_limit.store: %q (expected memory or redis)", rl.Store)
+	}
+
+	switch rl.Algorithm {
+	case "":
+		rl.Algorithm = "sliding_window"
+	case "sliding_window", "token_bucket":
+	default:
+		return fmt.Errorf("invalid rate_limit.algorithm: %q (expected sliding_window or token_bucket)", rl.Algorithm)
+	}
+
+	switch rl.Fallback {
+	case "":
+		rl.Fallback = "memory"
+	case "memory", "allow", "deny":
+	default:
+		return fmt.Errorf("invalid rate_limit.fallback: %q (expected memory, allow or deny)", rl.Fallback)
+	}
+
+	if rl.DefaultRate.Key == "" {
+		rl.DefaultRate.Key = "ip"
+	}
+	for pattern, rule := range rl.Routes {
+		if rule.Key == "" {
+			rule.Key = rl.DefaultRate.Key
+			rl.Routes[pattern] = rule
+		}
+	}
+
+	for _, entry := range rl.ExemptIPs {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid rate_limit.exempt_ips entry: %q", entry)
+		}
+	}
+
+	if !rl.Enabled {
+		return nil
+	}
+	if err := validateRateLimitRule("rate_limit.default_rate", rl.DefaultRate); err != nil {
+		return err
+	}
+	for pattern, rule := range rl.Routes {
+		name := fmt.Sprintf("rate_limit.routes[%q]", pattern)
+		if err := validateRateLimitPattern(pattern); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := validateRateLimitRule(name, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateRateLimitRule(name string, rule RateLimitRule) error {
+	if rule.Requests <= 0 || rule.Period <= 0 {
+		return fmt.Errorf("%s requires positive requests and period", name)
+	}
+	if rule.Burst < 0 {
+		return fmt.Errorf("%s.burst must not be negative", name)
+	}
+	switch rule.Key {
+	case "ip", "user", "token":
+	default:
+		return fmt.Errorf("invalid %s.key: %q (expected ip, user or token)", name, rule.Key)
+	}
+	return nil
+}
+
+// validateRateLimitPattern checks a route pattern of the form "[METHOD ]/path"
+func validateRateLimitPattern(pattern string) error {
+	routePath := pattern
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		if method == "" || strings.ToUpper(method) != method {
+			return fmt.Errorf("method must be upper case")
+		}
+		routePath = strings.TrimSpace(rest)
+	}
+	if !strings.HasPrefix(routePath, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	for _, segment := range strings.Split(routePath, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateLoginProtection fills in defaults for the login brute-force protection,
+// which is always active
+func (c *Config) validateLoginProtection() error {
+	lp := &c.LoginProtection
+	if lp.FreeAttempts < 0 || lp.BaseDelay < 0 || lp.MaxDelay < 0 || lp.LockoutThreshold < 0 ||
+		lp.IPLockoutThreshold < 0 || lp.LockoutDuration < 0 || lp.Window < 0 {
+		return fmt.Errorf("login_protection settings must not be negative")
+	}
+	if lp.FreeAttempts == 0 {
+		lp.FreeAttempts = 3
+	}
+	if lp.BaseDelay == 0 {
+		lp.BaseDelay = 1
+	}
+	if lp.MaxDelay == 0 {
+		lp.MaxDelay = 300
+	}
+	if lp.LockoutThreshold == 0 {
+		lp.LockoutThreshold = 10
+	}
+	if lp.IPLockoutThreshold == 0 {
+		lp.IPLockoutThreshold = 30
+	}
+	if lp.LockoutDuration == 0 {
+		lp.LockoutDuration = 15
+	}
+	if lp.Window == 0 {
+		lp.Window = 60
+	}
+	if lp.LockoutThreshold <= lp.FreeAttempts {
+		return fmt.Errorf("login_protection.lockout_threshold must be greater than free_attempts")
+	}
+	return nil
+}
+
+// validateOIDC checks the single sign-on section and fills in defaults
+func (c *Config) validateOIDC() error {
+	if !c.OIDC.Enabled {
+		if c.OIDC.DisablePasswordLogin {
+			return fmt.Errorf("oidc.disable_password_login requires oidc to be enabled")
+		}
+		return nil
+	}
+	if c.OIDC.Issuer == "" || c.OIDC.ClientID == "" {
+		return fmt.Errorf("oidc requires issuer and client_id when enabled")
+	}
+	if len(c.OIDC.Scopes) == 0 {
+		c.OIDC.Scopes = []string{"openid", "email", "profile"}
+	}
+	if c.OIDC.GroupsClaim == "" {
+		c.OIDC.GroupsClaim = "groups"
+	}
+	if c.OIDC.RoleClaim == "" {
+		c.OIDC.RoleClaim = c.OIDC.GroupsClaim
+	}
+	if c.OIDC.DefaultRole == "" {
+		c.OIDC.DefaultRole = "user"
+	}
+	if c.OIDC.RedirectURL == "" {
+		scheme := "http"
+		if c.App.Environment == "production" {
+			scheme = "https"
+		}
+		c.OIDC.RedirectURL = scheme + "://" + c.App.Domain + "/api/oidc/callback"
+	}
+
+	validRole := func(role string) bool { return role == "admin" || role == "user" }
+	if !validRole(c.OIDC.DefaultRole) {
+		return fmt.Errorf("invalid oidc.default_role: %q", c.OIDC.DefaultRole)
+	}
+	for value, role := range c.OIDC.RoleMapping {
+		if !validRole(role) {
+			return fmt.Errorf("invalid oidc.role_mapping role for %q: %q", value, role)
+		}
+	}
+	return nil
+}
+
+// GetViewRetention returns how long raw view rows are kept, or 0 to keep them forever
+func (c *Config) GetViewRetention() time.Duration {
+	return time.Duration(c.Analytics.RetentionDays) * 24 * time.Hour
 }
 
 func (c *Config) GetLogPath(filename string) string {
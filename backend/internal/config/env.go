@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// setting. The rest is the setting's path in upper case joined by
+// underscores, so app.jwt_secret is LLMSTOR_APP_JWT_SECRET.
+const EnvPrefix = "LLMSTOR"
+
+// redactedValue replaces secrets in the output of config check
+const redactedValue = "[redacted]"
+
+// applyEnv overrides the settings below v that have an environment variable
+// and returns the names of the variables used. Secrets can also be read from
+// the file named by the variable with a _FILE suffix.
+func applyEnv(v reflect.Value, prefix string) ([]string, error) {
+	var used []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := yamlKey(field)
+		if key == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		fv := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			nested, err := applyEnv(fv, name)
+			if err != nil {
+				return nil, err
+			}
+			used = append(used, nested...)
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if field.Tag.Get("secret") != "" {
+			if file, fromFile := os.LookupEnv(name + "_FILE"); fromFile {
+				if ok {
+					return nil, fmt.Errorf("%s and %s_FILE cannot both be set", name, name)
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read %s_FILE: %w", name, err)
+				}
+				value, ok = strings.TrimRight(string(data), "\r\n"), true
+				name += "_FILE"
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(fv, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		used = append(used, name)
+	}
+	return used, nil
+}
+
+// setFromEnv parses an environment value into a setting. Lists are comma
+// separated, and lists and maps can also be written in YAML flow style, like
+// [a, b] or {key: value}.
+func setFromEnv(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a whole number")
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if trimmed := strings.TrimSpace(value); !strings.HasPrefix(trimmed, "[") {
+			items := []string{}
+			for _, item := range strings.Split(trimmed, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			v.Set(reflect.ValueOf(items))
+			return nil
+		}
+		fallthrough
+	case reflect.Map:
+		parsed := reflect.New(v.Type())
+		if err := yaml.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+			return err
+		}
+		v.Set(parsed.Elem())
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// checkKeys collects the keys of node that t has no setting for, so typos
+// are reported instead of silently leaving a default in place
+func checkKeys(node *yaml.Node, t reflect.Type, prefix string, unknown *[]string) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			if key := yamlKey(t.Field(i)); key != "" {
+				fields[key] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				continue
+			}
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				*unknown = append(*unknown, fmt.Sprintf("%s (line %d)", path, node.Content[i].Line))
+				continue
+			}
+			checkKeys(node.Content[i+1], fieldType, path, unknown)
+		}
+	case reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			path := fmt.Sprintf("%s[%q]", prefix, node.Content[i].Value)
+			checkKeys(node.Content[i+1], t.Elem(), path, unknown)
+		}
+	}
+}
+
+// redactSecrets replaces the secrets below v. Passwords in connection URLs
+// are masked and the rest of the URL is kept.
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			redactSecrets(fv)
+			continue
+		}
+		if fv.Kind() != reflect.String || fv.String() == "" {
+			continue
+		}
+		switch field.Tag.Get("secret") {
+		case "true":
+			fv.SetString(redactedValue)
+		case "url":
+			if u, err := url.Parse(fv.String()); err == nil {
+				fv.SetString(u.Redacted())
+			} else {
+				fv.SetString(redactedValue)
+			}
+		}
+	}
+}
+
+// yamlKey returns the key of a struct field in config.yaml, or "" for
+// fields that are not settings
+func yamlKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// Redacted returns a copy of the configuration with its secrets replaced,
+// safe to print or log
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redactSecrets(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+// Path returns the file the configuration was read from
+func (c *Config) Path() string {
+	return c.path
+}
+
+// EnvOverrides returns the sorted names of the environment variables that
+// overrode settings of the file
+func (c *Config) EnvOverrides() []string {
+	overrides := append([]string(nil), c.overrides...)
+	sort.Strings(overrides)
+	return overrides
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reloadable lists the settings a reload applies, by key or whole section.
+// The rest are read once at startup, by background workers, connections or
+// the listener, and keep their old value until the server restarts.
+var reloadable = []string{
+	"app.max_file_size",
+	"app.enable_ip_tracking",
+	"storage.allowed_extensions",
+	"storage.max_storage",
+	"storage.quota_warning",
+	"uploads.max_pixels",
+	"uploads.max_dimension",
+	"uploads.allow_unverified",
+	"share",
+	"health",
+	"metrics",
+	"cors",
+	"rate_limit",
+	"logging.debug.enabled",
+	"logging.debug.console_output",
+	"logging.info.enabled",
+	"logging.info.console_output",
+	"logging.warn.enabled",
+	"logging.warn.console_output",
+	"logging.error.enabled",
+	"logging.error.console_output",
+}
+
+// Change is a setting that differs between the old and the new configuration
+type Change struct {
+	Key             string `json:"key"`
+	Old             string `json:"old"`
+	New             string `json:"new"`
+	RestartRequired bool   `json:"restart_required"` // kept at the old value until the server restarts
+}
+
+// ReloadHook applies a new configuration to a subsystem that keeps state
+// derived from it. Hooks run before the new configuration takes effect, and
+// run again with the arguments swapped to roll back when a later hook fails.
+type ReloadHook func(old, next *Config) error
+
+// Store holds the configuration in effect. A reload swaps in a whole new
+// snapshot, so readers see either the old or the new settings, never a mix.
+type Store struct {
+	current atomic.Pointer[Config]
+	mu      sync.Mutex // serializes reloads
+	hooks   []ReloadHook
+}
+
+// NewStore returns a store holding cfg
+func NewStore(cfg *Config) *Store {
+	s := &Store{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Get returns the configuration in effect. Callers should not hold on to it
+// longer than a request or a single run of a job.
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// OnReload registers a hook, called in registration order on every reload
+// that applies a change
+func (s *Store) OnReload(hook ReloadHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// Reload reads and validates the configuration file and environment again.
+// A configuration that fails to load, or that a hook rejects, leaves the
+// current one in effect. Settings that need a restart are reported but keep
+// their old value.
+func (s *Store) Reload() ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.current.Load()
+	next, err := LoadConfig(old.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffSettings(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), "", false, &changes)
+	if Applied(changes) == 0 {
+		// Only settings still waiting for a restart differ
+		return changes, nil
+	}
+	// Settings kept at their old value may have been validated against new
+	// values of others
+	if err := next.validate(); err != nil {
+		return nil, fmt.Errorf("settings that need a restart conflict with the new ones: %w", err)
+	}
+
+	for i, hook := range s.hooks {
+		if err := hook(old, next); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				s.hooks[j](next, old)
+			}
+			return nil, err
+		}
+	}
+	s.current.Store(next)
+	return changes, nil
+}
+
+// Applied counts the changes a reload put into effect
+func Applied(changes []Change) int {
+	applied := 0
+	for _, change := range changes {
+		if !change.RestartRequired {
+			applied++
+		}
+	}
+	return applied
+}
+
+// diffSettings records the settings that differ between old and next. The
+// settings that cannot be reloaded are set back to their old value in next.
+func diffSettings(old, next reflect.Value, prefix string, secret bool, changes *[]Change) {
+	switch old.Kind() {
+	case reflect.Struct:
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := yamlKey(field)
+			if key == "" {
+				continue
+			}
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			diffField(old.Field(i), next.Field(i), key, field.Tag.Get("secret") != "", changes)
+		}
+	case reflect.Map:
+		keys := map[string]reflect.Value{}
+		for _, k := range append(old.MapKeys(), next.MapKeys()...) {
+			keys[k.String()] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := fmt.Sprintf("%s[%q]", prefix, name)
+			oldValue, nextValue := old.MapIndex(keys[name]), next.MapIndex(keys[name])
+			if !oldValue.IsValid() || !nextValue.IsValid() || old.Type().Elem().Kind() != reflect.Struct {
+				if !reflect.DeepEqual(settingValue(oldValue), settingValue(nextValue)) {
+					*changes = append(*changes, newChange(key, oldValue, nextValue, secret))
+				}
+				continue
+			}
+			diffSettings(oldValue, nextValue, key, secret, changes)
+		}
+	}
+}
+
+// diffField compares one setting, descending into sections and maps. A
+// section leaves the decision to its settings, a map is reloaded as a whole.
+func diffField(old, next reflect.Value, key string, secret bool, changes *[]Change) {
+	if reflect.DeepEqual(old.Interface(), next.Interface()) {
+		return
+	}
+	if old.Kind() == reflect.Struct {
+		diffSettings(old, next, key, secret, changes)
+		return
+	}
+
+	first := len(*changes)
+	if old.Kind() == reflect.Map {
+		diffSettings(old, next, key, secret, changes)
+	} else {
+		*changes = append(*changes, newChange(key, old, next, secret))
+	}
+
+	if !isReloadable(key) {
+		for i := first; i < len(*changes); i++ {
+			(*changes)[i].RestartRequired = true
+		}
+		if next.CanSet() {
+			next.Set(old)
+		}
+	}
+}
+
+func newChange(key string, old, next reflect.Value, secret bool) Change {
+	if secret {
+		return Change{Key: key, Old: redactedValue, New: redactedValue}
+	}
+	return Change{Key: key, Old: formatSetting(old), New: formatSetting(next)}
+}
+
+// settingValue returns the value of v, or nil for a missing map entry
+func settingValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// formatSetting renders a setting for the reload log in YAML flow style,
+// like [jpg, png] or {requests: 5, period: 60}
+func formatSetting(v reflect.Value) string {
+	if !v.IsValid() {
+		return "(unset)"
+	}
+	var node yaml.Node
+	if err := node.Encode(v.Interface()); err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	setFlowStyle(&node)
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func setFlowStyle(node *yaml.Node) {
+	node.Style |= yaml.FlowStyle
+	for _, child := range node.Content {
+		setFlowStyle(child)
+	}
+}
+
+func isReloadable(key string) bool {
+	for _, prefix := range reloadable {
+		if key == prefix || strings.HasPrefix(key, prefix+".") || strings.HasPrefix(key, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted blobs start with a fixed-size header followed by the content in
+// independently sealed chunks, so any byte range can be decrypted without
+// reading the chunks before it:
+//
+//	magic       8 bytes  "LLMSENC1"
+//	chunk size  4 bytes  plaintext bytes per chunk
+//	size        8 bytes  plaintext length
+//	key id      8 bytes  master key that wrapped the data key
+//	wrap nonce 12 bytes
+//	data key   48 bytes  AES-256 key sealed by the master key
+//
+// Chunk i is sealed with the data key under the nonce (i, last), where last
+// marks the final chunk so truncating a blob is detected. The magic, chunk
+// size and length are authenticated with every chunk. Rotating the master
+// key only rewrites the key id, nonce and wrapped key.
+const (
+	magic      = "LLMSENC1"
+	ChunkSize  = 64 * 1024
+	headerSize = 88
+	tagSize    = 16
+
+	fixedEnd = 20 // end of the magic, chunk size and length
+	keyIDEnd = 28
+	nonceEnd = 40
+
+	maxChunkSize = 16 * 1024 * 1024
+)
+
+// ErrCorrupt is returned for blobs whose header or chunks do not authenticate
+var ErrCorrupt = errors.New("encrypted blob is corrupt")
+
+// IsEncrypted reports whether r holds an encrypted blob
+func IsEncrypted(r io.ReaderAt) (bool, error) {
+	head := make([]byte, len(magic))
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return n == len(magic) && string(head) == magic, nil
+}
+
+// EncryptedSize returns the size on disk of a blob holding size bytes
+func EncryptedSize(size int64) int64 {
+	return headerSize + size + chunkCount(size)*tagSize
+}
+
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + ChunkSize - 1) / ChunkSize
+}
+
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// Encrypt writes size bytes from src to dst as an encrypted blob under a new
+// random data key
+func (k *Keyring) Encrypt(dst io.Writer, src io.Reader, size int64) error {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[8:12], ChunkSize)
+	binary.BigEndian.PutUint64(header[12:fixedEnd], uint64(size))
+	if err := k.wrap(header, dataKey); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	aad := header[:fixedEnd]
+	chunks := chunkCount(size)
+	plain := make([]byte, ChunkSize)
+	sealed := make([]byte, 0, ChunkSize+tagSize)
+	remaining := size
+	for i := int64(0); i < chunks; i++ {
+		n := int64(ChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(src, plain[:n]); err != nil {
+			return fmt.Errorf("source is shorter than %d bytes: %w", size, err)
+		}
+		remaining -= n
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(i, i == chunks-1), plain[:n], aad)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap seals the data key with the current master key into the header
+func (k *Keyring) wrap(header, dataKey []byte) error {
+	copy(header[fixedEnd:keyIDEnd], k.current.id[:])
+	nonce := header[keyIDEnd:nonceEnd]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	k.current.aead.Seal(header[nonceEnd:nonceEnd], nonce, dataKey, header[:keyIDEnd])
+	return nil
+}
+
+// unwrap opens the data key in a header with whichever master key sealed it
+func (k *Keyring) unwrap(header []byte) ([]byte, error) {
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	var id [8]byte
+	copy(id[:], header[fixedEnd:keyIDEnd])
+	mk, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	dataKey, err := mk.aead.Open(nil, header[keyIDEnd:nonceEnd], header[nonceEnd:headerSize], header[:keyIDEnd])
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key does not authenticate", ErrCorrupt)
+	}
+	return dataKey, nil
+}
+
+// rewrap seals the data key in a blob header with the current master key.
+// It returns false when the header already uses the current key.
+func (k *Keyring) rewrap(header []byte) (bool, error) {
+	if bytes.Equal(header[fixedEnd:keyIDEnd], k.current.id[:]) {
+		return false, nil
+	}
+
+	dataKey, err := k.unwrap(header)
+	if err != nil {
+		return false, err
+	}
+	if err := k.wrap(header, dataKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Reader decrypts a blob, seeking by whole chunks
+type Reader struct {
+	src       io.ReaderAt
+	aead      cipher.AEAD
+	aad       []byte
+	size      int64
+	chunkSize int64
+	chunks    int64
+
+	offset  int64
+	current int64 // index of the chunk in plain, -1 for none
+	plain   []byte
+	sealed  []byte
+}
+
+// NewReader opens the encrypted blob in src, whose total length is fileSize
+func (k *Keyring) NewReader(src io.ReaderAt, fileSize int64) (*Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := src.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+		}
+		return nil, err
+	}
+
+	dataKey, err := k.unwrap(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := int64(binary.BigEndian.Uint32(header[8:12]))
+	size := int64(binary.BigEndian.Uint64(header[12:fixedEnd]))
+	if chunkSize == 0 || chunkSize > maxChunkSize || size < 0 {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	chunks := int64(1)
+	if size > 0 {
+		chunks = (size + chunkSize - 1) / chunkSize
+	}
+	if fileSize != headerSize+size+chunks*tagSize {
+		return nil, fmt.Errorf("%w: length does not match the header", ErrCorrupt)
+	}
+
+	return &Reader{
+		src:       src,
+		aead:      aead,
+		aad:       header[:fixedEnd],
+		size:      size,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		current:   -1,
+		sealed:    make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+// Size returns the plaintext length
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / r.chunkSize
+	if err := r.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, r.plain[r.offset-index*r.chunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("encryption: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("encryption: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// load decrypts and authenticates one chunk
+func (r *Reader) load(index int64) error {
+	if index == r.current {
+		return nil
+	}
+
+	length := r.chunkSize
+	if rest := r.size - index*r.chunkSize; rest < length {
+		length = rest
+	}
+	sealed := r.sealed[:length+tagSize]
+	if _, err := r.src.ReadAt(sealed, headerSize+index*(r.chunkSize+tagSize)); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%w: truncated chunk", ErrCorrupt)
+		}
+		return err
+	}
+
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(index, index == r.chunks-1), sealed, r.aad)
+	if err != nil {
+		r.current = -1
+		return fmt.Errorf("%w: chunk %d does not authenticate", ErrCorrupt, index)
+	}
+	r.plain = plain
+	r.current = index
+	return nil
+}
@@ -0,0 +1,263 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, seed byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(testKey(seed), previous...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func testKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, keySize)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encryptBytes(t *testing.T, k *Keyring, plain []byte) []byte {
+	t.Helper()
+	var blob bytes.Buffer
+	if err := k.Encrypt(&blob, bytes.NewReader(plain), int64(len(plain))); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	return blob.Bytes()
+}
+
+func openBytes(k *Keyring, blob []byte) (*Reader, error) {
+	return k.NewReader(bytes.NewReader(blob), int64(len(blob)))
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := testKeyring(t, 1)
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 7} {
+		plain := randomBytes(t, size)
+		blob := encryptBytes(t, k, plain)
+		if int64(len(blob)) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: blob is %d bytes, EncryptedSize says %d", size, len(blob), EncryptedSize(int64(size)))
+		}
+		if encrypted, err := IsEncrypted(bytes.NewReader(blob)); err != nil || !encrypted {
+			t.Errorf("size %d: IsEncrypted = %v, %v", size, encrypted, err)
+		}
+
+		r, err := openBytes(k, blob)
+		if err != nil {
+			t.Fatalf("size %d: NewReader: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Errorf("size %d: Size() = %d", size, r.Size())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestEncryptShortSource(t *testing.T) {
+	k := testKeyring(t, 1)
+	if err := k.Encrypt(io.Discard, bytes.NewReader(make([]byte, 10)), 11); err == nil {
+		t.Error("Encrypt accepted a source shorter than the given size")
+	}
+}
+
+func TestSeekAcrossChunks(t *testing.T) {
+	k := testKeyring(t, 1)
+	plain := randomBytes(t, 3*ChunkSize+100)
+	r, err := openBytes(k, encryptBytes(t, k, plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		want   int64 // absolute position read from
+	}{
+		{"start", 0, io.SeekStart, 10, 0},
+		{"across the first boundary", ChunkSize - 5, io.SeekStart, 10, ChunkSize - 5},
+		{"back into the first chunk", -ChunkSize, io.SeekCurrent, 20, 5},
+		{"whole chunk unaligned", ChunkSize + 1, io.SeekStart, ChunkSize, ChunkSize + 1},
+		{"last chunk from the end", -50, io.SeekEnd, 50, 3*ChunkSize + 50},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil {
+			t.Fatalf("%s: Seek: %v", tt.name, err)
+		}
+		if pos != tt.want {
+			t.Fatalf("%s: Seek returned %d, want %d", tt.name, pos, tt.want)
+		}
+		got := make([]byte, tt.length)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("%s: Read: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, plain[tt.want:tt.want+int64(tt.length)]) {
+			t.Errorf("%s: read wrong bytes", tt.name)
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end = %d, %v, want 0, EOF", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position succeeded")
+	}
+}
+
+func TestServeContentRanges(t *testing.T) {
+	k := testKeyring(t, 1)
+	plain := randomBytes(t, 3*ChunkSize+100)
+	blob := encryptBytes(t, k, plain)
+	size := len(plain)
+
+	tests := []struct {
+		rangeHeader string
+		start, end  int // inclusive
+	}{
+		{"bytes=0-0", 0, 0},
+		{"bytes=65530-65545", ChunkSize - 6, ChunkSize + 9},
+		{"bytes=65536-131071", ChunkSize, 2*ChunkSize - 1},
+		{"bytes=100-150000", 100, 150000},
+		{"bytes=-10", size - 10, size - 1},
+		{"bytes=196600-", 196600, size - 1},
+	}
+	for _, tt := range tests {
+		r, err := openBytes(k, blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/file", nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, req, "file.bin", time.Time{}, r)
+
+		if rec.Code != http.StatusPartialContent {
+			t.Errorf("%s: status %d, want %d", tt.rangeHeader, rec.Code, http.StatusPartialContent)
+			continue
+		}
+		if !bytes.Equal(rec.Body.Bytes(), plain[tt.start:tt.end+1]) {
+			t.Errorf("%s: body is %d bytes, want bytes %d-%d", tt.rangeHeader, rec.Body.Len(), tt.start, tt.end)
+		}
+	}
+}
+
+func TestCorruptBlobs(t *testing.T) {
+	k := testKeyring(t, 1)
+	plain := randomBytes(t, 2*ChunkSize+10)
+	blob := encryptBytes(t, k, plain)
+
+	tests := []struct {
+		name   string
+		modify func(blob []byte) []byte
+	}{
+		{"bad magic", func(b []byte) []byte { b[0] ^= 1; return b }},
+		{"chunk size", func(b []byte) []byte { b[10] ^= 1; return b }},
+		{"length", func(b []byte) []byte { b[19] ^= 1; return b }},
+		{"wrap nonce", func(b []byte) []byte { b[keyIDEnd] ^= 1; return b }},
+		{"wrapped key", func(b []byte) []byte { b[nonceEnd] ^= 1; return b }},
+		{"first chunk", func(b []byte) []byte { b[headerSize] ^= 1; return b }},
+		{"last chunk tag", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"truncated header", func(b []byte) []byte { return b[:headerSize-1] }},
+		{"truncated chunk", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"last chunk dropped", func(b []byte) []byte { return b[:headerSize+2*(ChunkSize+tagSize)] }},
+		{"chunks swapped", func(b []byte) []byte {
+			first := headerSize
+			second := headerSize + ChunkSize + tagSize
+			chunk := append([]byte(nil), b[first:second]...)
+			copy(b[first:second], b[second:second+ChunkSize+tagSize])
+			copy(b[second:], chunk)
+			return b
+		}},
+	}
+	for _, tt := range tests {
+		corrupt := tt.modify(append([]byte(nil), blob...))
+		r, err := openBytes(k, corrupt)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", tt.name, err)
+		}
+	}
+}
+
+func TestTruncatedSource(t *testing.T) {
+	k := testKeyring(t, 1)
+	blob := encryptBytes(t, k, randomBytes(t, 2*ChunkSize+10))
+
+	// A source shorter than the length it claims fails when the missing
+	// chunk is read, not before
+	r, err := k.NewReader(bytes.NewReader(blob[:len(blob)-20]), int64(len(blob)))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("reading the intact first chunk: %v", err)
+	}
+	if _, err := r.Seek(-1, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading the truncated chunk: err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestLastChunkFlag(t *testing.T) {
+	k := testKeyring(t, 1)
+	plain := randomBytes(t, ChunkSize)
+	blob := encryptBytes(t, k, plain)
+
+	// Reseal the only chunk as if more chunks followed it, like the first
+	// chunk of a longer blob cut down to one chunk
+	dataKey, err := k.unwrap(blob[:headerSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := aead.Seal(append([]byte(nil), blob[:headerSize]...), chunkNonce(0, false), plain, blob[:fixedEnd])
+
+	r, err := openBytes(k, forged)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("chunk without the last flag: err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestUnknownMasterKey(t *testing.T) {
+	blob := encryptBytes(t, testKeyring(t, 1), []byte("content"))
+	if _, err := openBytes(testKeyring(t, 2), blob); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoKey is returned when opening an encrypted file without a keyring
+var ErrNoKey = errors.New("file is encrypted but no encryption key is configured")
+
+// File is a stored file opened for reading, decrypted when necessary
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type decryptedFile struct {
+	*Reader
+	file *os.File
+}
+
+func (f *decryptedFile) Close() error {
+	return f.file.Close()
+}
+
+// Open opens a stored file for reading. Encrypted and plain files are both
+// accepted, so a store can be read while it is being migrated. k may be nil
+// when encryption has never been configured.
+func Open(path string, k *Keyring) (File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := IsEncrypted(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !encrypted {
+		return file, nil
+	}
+	if k == nil {
+		file.Close()
+		return nil, ErrNoKey
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := k.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &decryptedFile{Reader: reader, file: file}, nil
+}
+
+// EncryptFile encrypts the plain file at src into dst. The result is written
+// to a temporary file next to dst and renamed over it, so dst may be src.
+func (k *Keyring) EncryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".encrypt-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := k.Encrypt(tmp, in, info.Size()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// RewrapFile seals the data key of the encrypted file at path with the
+// current master key, leaving the content untouched. The new header and the
+// content are written to a temporary file that is renamed over path, so a
+// crash never leaves a header that no longer authenticates. It returns false
+// when the file already uses the current key.
+func (k *Keyring) RewrapFile(path string) (bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := in.ReadAt(header, 0); err != nil {
+		return false, err
+	}
+	rewrapped, err := k.rewrap(header)
+	if err != nil || !rewrapped {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rewrap-*")
+	if err != nil {
+		return false, err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(header); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(in, headerSize, info.Size()-headerSize)); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
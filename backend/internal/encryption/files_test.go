@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string, k *Keyring) ([]byte, error) {
+	t.Helper()
+	f, err := Open(path, k)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestOpen(t *testing.T) {
+	k := testKeyring(t, 1)
+	plain := randomBytes(t, ChunkSize+1)
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, plain, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain files are read as they are, with or without a keyring
+	for _, keyring := range []*Keyring{nil, k} {
+		got, err := readFile(t, path, keyring)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("plain file with keyring %v: err %v, content equal %v", keyring != nil, err, bytes.Equal(got, plain))
+		}
+	}
+
+	if err := k.EncryptFile(path, path); err != nil {
+		t.Fatalf("EncryptFile: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != EncryptedSize(int64(len(plain))) || info.Mode().Perm() != 0o644 {
+		t.Errorf("encrypted file has size %d and mode %v", info.Size(), info.Mode().Perm())
+	}
+
+	got, err := readFile(t, path, k)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("encrypted file: err %v, content equal %v", err, bytes.Equal(got, plain))
+	}
+	if _, err := readFile(t, path, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("encrypted file without a keyring: err = %v, want ErrNoKey", err)
+	}
+}
+
+func TestRewrapFile(t *testing.T) {
+	old := testKeyring(t, 1)
+	plain := randomBytes(t, 2*ChunkSize+10)
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, plain, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.EncryptFile(path, path); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testKeyring(t, 2, testKey(1))
+	rewrapped, err := rotated.RewrapFile(path)
+	if err != nil || !rewrapped {
+		t.Fatalf("RewrapFile = %v, %v, want true", rewrapped, err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the key id, wrap nonce and wrapped key change
+	if !bytes.Equal(after[:fixedEnd], before[:fixedEnd]) {
+		t.Error("RewrapFile changed the magic, chunk size or length")
+	}
+	if bytes.Equal(after[fixedEnd:keyIDEnd], before[fixedEnd:keyIDEnd]) {
+		t.Error("RewrapFile kept the old key id")
+	}
+	if !bytes.Equal(after[headerSize:], before[headerSize:]) {
+		t.Error("RewrapFile changed the sealed content")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("rewrapped file mode %v, err %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// The new master key alone now opens it, the old one no longer does
+	got, err := readFile(t, path, testKeyring(t, 2))
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("reading with the new key: err %v, content equal %v", err, bytes.Equal(got, plain))
+	}
+	if _, err := readFile(t, path, old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("reading with the old key: err = %v, want ErrUnknownKey", err)
+	}
+
+	if rewrapped, err := rotated.RewrapFile(path); err != nil || rewrapped {
+		t.Errorf("second RewrapFile = %v, %v, want false", rewrapped, err)
+	}
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries after rewrapping, want only the file", len(entries))
+	}
+}
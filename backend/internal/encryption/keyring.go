@@ -0,0 +1,134 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"sharex/internal/config"
+)
+
+const keySize = 32
+
+// ErrUnknownKey is returned for blobs wrapped by a master key the keyring
+// does not hold
+var ErrUnknownKey = errors.New("blob was encrypted with an unknown master key")
+
+// masterKey wraps and unwraps per-blob data keys
+type masterKey struct {
+	id   [8]byte
+	aead cipher.AEAD
+}
+
+func newMasterKey(key []byte) (*masterKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	mk := &masterKey{aead: aead}
+	copy(mk.id[:], sum[:8])
+	return mk, nil
+}
+
+// Keyring holds the current master key, used for new blobs, and retired
+// master keys that can still unwrap older blobs during a rotation
+type Keyring struct {
+	current *masterKey
+	keys    map[[8]byte]*masterKey
+}
+
+// NewKeyring creates a keyring from raw 32-byte keys
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	mk, err := newMasterKey(current)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{current: mk, keys: map[[8]byte]*masterKey{mk.id: mk}}
+	for _, key := range previous {
+		old, err := newMasterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[old.id]; !ok {
+			k.keys[old.id] = old
+		}
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the master keys named in the encryption section. It
+// returns nil without an error when no key is configured and encryption is
+// disabled, since there is then nothing to encrypt or decrypt.
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	var current []byte
+	switch {
+	case cfg.Encryption.KeyFile != "":
+		data, err := os.ReadFile(cfg.Encryption.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		current = data
+	case os.Getenv(cfg.Encryption.KeyEnv) != "":
+		current = []byte(os.Getenv(cfg.Encryption.KeyEnv))
+	case cfg.Encryption.Enabled:
+		return nil, fmt.Errorf("encryption is enabled but neither encryption.key_file nor $%s is set", cfg.Encryption.KeyEnv)
+	default:
+		return nil, nil
+	}
+
+	key, err := ParseKey(current)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	var previous [][]byte
+	for _, path := range cfg.Encryption.PreviousKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous encryption key file: %w", err)
+		}
+		old, err := ParseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous encryption key in %s: %w", path, err)
+		}
+		previous = append(previous, old)
+	}
+
+	return NewKeyring(key, previous...)
+}
+
+// ParseKey decodes a master key written as 64 hex characters or as base64
+func ParseKey(data []byte) ([]byte, error) {
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes encoded as hex or base64", keySize)
+}
+
+// KeyID returns the identifier of the current master key, as stored in blob
+// headers
+func (k *Keyring) KeyID() string {
+	return hex.EncodeToString(k.current.id[:])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
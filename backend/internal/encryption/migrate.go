@@ -0,0 +1,212 @@
+package encryption
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/health"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// errBatchDone stops a walk once a pass has done its share of work
+var errBatchDone = errors.New("batch done")
+
+// MigrationResult counts what a migration pass did
+type MigrationResult struct {
+	Encrypted int  `json:"encrypted"`
+	Rewrapped int  `json:"rewrapped"`
+	Failed    int  `json:"failed"`
+	Remaining bool `json:"remaining"` // the pass stopped at its batch size
+}
+
+// Migrator brings the store in line with the keyring: plain files are
+// encrypted and files sealed with a retired master key are rewrapped. It runs
+// in small batches so enabling encryption on a live instance does not stall it.
+type Migrator struct {
+	config    *config.Config
+	db        *storage.DB
+	keyring   *Keyring
+	logger    *utils.Logger
+	heartbeat *health.Heartbeat
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewMigrator(cfg *config.Config, db *storage.DB, keyring *Keyring, logger *utils.Logger) *Migrator {
+	return &Migrator{
+		config:    cfg,
+		db:        db,
+		keyring:   keyring,
+		logger:    logger,
+		heartbeat: health.NewHeartbeat("encryption_migration", time.Duration(cfg.Encryption.MigrateInterval)*time.Second),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start migrates the store in the background until a pass finds nothing left
+// to do. It does nothing without a keyring.
+func (m *Migrator) Start() {
+	if m.keyring == nil {
+		return
+	}
+
+	m.logger.Info("Starting encryption migration", map[string]interface{}{
+		"encrypt":          m.config.Encryption.Enabled,
+		"key_id":           m.keyring.KeyID(),
+		"migrate_batch":    m.config.Encryption.MigrateBatch,
+		"migrate_interval": m.config.Encryption.MigrateInterval,
+	})
+
+	m.wg.Add(1)
+	go m.run()
+}
+
+func (m *Migrator) run() {
+	defer m.wg.Done()
+	defer m.heartbeat.Stop()
+
+	ticker := time.NewTicker(time.Duration(m.config.Encryption.MigrateInterval) * time.Second)
+	defer ticker.Stop()
+
+	var total MigrationResult
+	for {
+		m.heartbeat.Beat()
+		result, err := m.Pass(m.config.Encryption.MigrateBatch, m.config.Encryption.Enabled)
+		if err != nil {
+			m.logger.Error("Encryption migration pass failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		total.Encrypted += result.Encrypted
+		total.Rewrapped += result.Rewrapped
+		total.Failed += result.Failed
+
+		if err == nil && !result.Remaining {
+			if total.Encrypted > 0 || total.Rewrapped > 0 || total.Failed > 0 {
+				m.logger.Info("Encryption migration complete", map[string]interface{}{
+					"encrypted": total.Encrypted,
+					"rewrapped": total.Rewrapped,
+					"failed":    total.Failed,
+				})
+			}
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// Pass rewraps files sealed with a retired master key and, when encrypt is
+// set, encrypts plain files, stopping after limit files have been changed.
+// A limit of 0 processes the whole store.
+func (m *Migrator) Pass(limit int, encrypt bool) (MigrationResult, error) {
+	var result MigrationResult
+
+	err := filepath.WalkDir(m.config.Storage.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		select {
+		case <-m.stopChan:
+			result.Remaining = true
+			return errBatchDone
+		default:
+		}
+		if limit > 0 && result.Encrypted+result.Rewrapped >= limit {
+			result.Remaining = true
+			return errBatchDone
+		}
+
+		// A batch of large files can take longer than the interval
+		m.heartbeat.Beat()
+		changed, err := m.migrateFile(path, d.Name(), encrypt)
+		if err != nil {
+			m.logger.Error("Failed to migrate file", map[string]interface{}{
+				"error": err.Error(),
+				"path":  path,
+			})
+			result.Failed++
+			return nil
+		}
+		switch changed {
+		case "encrypted":
+			result.Encrypted++
+		case "rewrapped":
+			result.Rewrapped++
+		}
+		return nil
+	})
+	if err == errBatchDone {
+		err = nil
+	}
+	return result, err
+}
+
+// migrateFile returns "encrypted", "rewrapped" or "" when the file was left alone
+func (m *Migrator) migrateFile(path, name string, encrypt bool) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := IsEncrypted(file)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if encrypted {
+		rewrapped, err := m.keyring.RewrapFile(path)
+		if err != nil || !rewrapped {
+			return "", err
+		}
+		return "rewrapped", nil
+	}
+
+	if !encrypt {
+		return "", nil
+	}
+
+	// Only files that belong to an image are migrated; anything else is
+	// left for an administrator to look at
+	uuid := strings.TrimSuffix(name, filepath.Ext(name))
+	image, err := m.db.GetImage(uuid)
+	if err != nil || image == nil {
+		return "", err
+	}
+
+	if err := m.keyring.EncryptFile(path, path); err != nil {
+		return "", err
+	}
+
+	// The image may have been deleted while it was being encrypted, in which
+	// case the rename just brought the file back
+	if image, err := m.db.GetImage(uuid); err == nil && image == nil {
+		os.Remove(path)
+	}
+	return "encrypted", nil
+}
+
+// Heartbeat reports whether the migration is still running
+func (m *Migrator) Heartbeat() *health.Heartbeat {
+	return m.heartbeat
+}
+
+// Close stops the migration and waits for the file being migrated
+func (m *Migrator) Close() {
+	close(m.stopChan)
+	m.wg.Wait()
+}
@@ -0,0 +1,204 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types pushed to subscribers
+const (
+	TypeView    = "view"
+	TypeUpload  = "upload"
+	TypeDelete  = "delete"
+	TypePrivacy = "privacy"
+	TypeQuota   = "quota"
+
+	// TypeTransfer reports the progress of an archive export or import
+	TypeTransfer = "transfer"
+)
+
+// Event is a single server-side event. IDs have the form <instance>-<seq>:
+// the instance is random per broker and the sequence increases monotonically
+// for its lifetime, so clients can resume with Last-Event-ID and an ID from a
+// restarted process or another replica is never mistaken for a local one.
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+
+	seq uint64
+}
+
+// Subscriber receives events on C. When its buffer overflows the broker drops
+// the subscriber and closes C, the client is expected to reconnect and resume
+// from the last event it received.
+type Subscriber struct {
+	C chan Event
+}
+
+// Broker fans published events out to subscribers and keeps a bounded history
+// of recent events for Last-Event-ID resume
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscriber]struct{}
+	history     []Event
+	historySize int
+	bufferSize  int
+	instance    string
+	lastID      uint64
+	listeners   []func(Event)
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewBroker(historySize, bufferSize int) *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscriber]struct{}),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		instance:    newInstanceID(),
+		done:        make(chan struct{}),
+	}
+}
+
+// newInstanceID returns a random prefix for the event IDs of one broker
+func newInstanceID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to the boot time, still distinct across restarts
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Close tells subscribers to end their streams. The server calls it when it
+// shuts down; clients reconnect, and when they reach another instance they
+// are told to resync because its event IDs are not comparable.
+func (b *Broker) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+// Done is closed once the broker is closed
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// AddListener registers a function called synchronously for every published
+// event. Unlike subscribers, listeners never miss events.
+func (b *Broker) AddListener(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Publish records an event, delivers it to every subscriber without blocking
+// and then calls the listeners
+func (b *Broker) Publish(eventType string, data interface{}) Event {
+	event, listeners := b.publish(eventType, data)
+	for _, fn := range listeners {
+		fn(event)
+	}
+	return event
+}
+
+func (b *Broker) publish(eventType string, data interface{}) (Event, []func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{
+		ID:   b.instance + "-" + strconv.FormatUint(b.lastID, 10),
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+		seq:  b.lastID,
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.C <- event:
+		default:
+			// Slow subscriber, drop it rather than block publishers
+			delete(b.subscribers, sub)
+			close(sub.C)
+		}
+	}
+
+	return event, b.listeners
+}
+
+// Subscribe registers a new subscriber. If lastEventID is set the events
+// published after it are returned for replay; complete is false when some of
+// them have already left the history, or the ID was issued by another
+// instance, and the client needs a full refresh.
+func (b *Broker) Subscribe(lastEventID string) (sub *Subscriber, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscriber{C: make(chan Event, b.bufferSize)}
+	b.subscribers[sub] = struct{}{}
+
+	complete = true
+	if lastEventID == "" {
+		return sub, nil, complete
+	}
+
+	// IDs of a previous process or another replica say nothing about what
+	// this instance published
+	instance, seq, ok := parseID(lastEventID)
+	if !ok || instance != b.instance || seq > b.lastID {
+		return sub, nil, false
+	}
+
+	if len(b.history) > 0 && b.history[0].seq > seq+1 {
+		complete = false
+	}
+	for _, event := range b.history {
+		if event.seq > seq {
+			missed = append(missed, event)
+		}
+	}
+
+	return sub, missed, complete
+}
+
+// parseID splits an event ID into its instance and sequence number
+func parseID(id string) (instance string, seq uint64, ok bool) {
+	instance, rest, found := strings.Cut(id, "-")
+	if !found || instance == "" {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(rest, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return instance, seq, true
+}
+
+// Unsubscribe removes a subscriber, it is safe to call after the broker dropped it
+func (b *Broker) Unsubscribe(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// SubscriberCount returns the number of connected subscribers
+func (b *Broker) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
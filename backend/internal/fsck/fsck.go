@@ -0,0 +1,509 @@
+package fsck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/health"
+	"sharex/internal/metrics"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+)
+
+// Kinds of issues a check reports
+const (
+	MissingFile      = "missing_file"
+	OrphanFile       = "orphan_file"
+	SizeMismatch     = "size_mismatch"
+	ChecksumMismatch = "checksum_mismatch"
+	Unreadable       = "unreadable"
+)
+
+// Kinds lists every kind of issue
+var Kinds = []string{MissingFile, OrphanFile, SizeMismatch, ChecksumMismatch, Unreadable}
+
+// orphanGrace keeps files written moments ago out of the orphan list, since
+// uploads store the file just before the record
+const orphanGrace = 5 * time.Minute
+
+// ErrRunning is returned when a check is started while another is running
+var ErrRunning = errors.New("a storage check is already running")
+
+// Options selects what a check reads and repairs. Without repair actions a
+// check only reports.
+type Options struct {
+	Checksums bool     `json:"checksums"`
+	Repair    []string `json:"repair"`
+}
+
+func (o Options) repairs(action string) bool {
+	for _, a := range o.Repair {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Issue is one disagreement between the database and the storage path
+type Issue struct {
+	Kind        string `json:"kind"`
+	UUID        string `json:"uuid,omitempty"`
+	Path        string `json:"path"`
+	Detail      string `json:"detail"`
+	Repair      string `json:"repair,omitempty"`       // action taken
+	RepairError string `json:"repair_error,omitempty"` // why the action failed or was not possible
+}
+
+// Report is the outcome of one check
+type Report struct {
+	StartedAt         time.Time      `json:"started_at"`
+	FinishedAt        time.Time      `json:"finished_at"`
+	Options           Options        `json:"options"`
+	Images            int            `json:"images"`
+	Files             int            `json:"files"`
+	ChecksumsRecorded int            `json:"checksums_recorded"`
+	Counts            map[string]int `json:"counts"`
+	Issues            []Issue        `json:"issues"`
+}
+
+func (r *Report) add(issue Issue) {
+	r.Counts[issue.Kind]++
+	r.Issues = append(r.Issues, issue)
+}
+
+// Repaired counts the repairs applied, by action taken
+func (r *Report) Repaired() map[string]int {
+	repaired := map[string]int{}
+	for _, issue := range r.Issues {
+		if issue.Repair != "" {
+			repaired[issue.Repair]++
+		}
+	}
+	return repaired
+}
+
+// Checker runs storage checks, on demand and on the schedule in the fsck
+// section
+type Checker struct {
+	config    *config.Config
+	db        *storage.DB
+	keyring   *encryption.Keyring
+	logger    *utils.Logger
+	uuidRe    *regexp.Regexp
+	heartbeat *health.Heartbeat
+	running   sync.Mutex
+	mu        sync.Mutex
+	last      *Report
+	stopChan  chan struct{}
+}
+
+func New(cfg *config.Config, db *storage.DB, keyring *encryption.Keyring, logger *utils.Logger) *Checker {
+	return &Checker{
+		config:    cfg,
+		db:        db,
+		keyring:   keyring,
+		logger:    logger,
+		uuidRe:    regexp.MustCompile(cfg.App.UUIDFormat),
+		heartbeat: health.NewHeartbeat("fsck", time.Duration(cfg.Fsck.Interval)*time.Hour),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start runs a check every fsck.interval hours with the configured options.
+// It does nothing when the interval is 0.
+func (c *Checker) Start() {
+	if c.config.Fsck.Interval <= 0 {
+		return
+	}
+
+	c.logger.Info("Starting storage check routine", map[string]interface{}{
+		"interval":  c.config.Fsck.Interval,
+		"checksums": c.config.Fsck.Checksums,
+		"repair":    c.config.Fsck.Repair,
+	})
+
+	go c.run()
+}
+
+func (c *Checker) run() {
+	defer c.heartbeat.Stop()
+
+	ticker := time.NewTicker(time.Duration(c.config.Fsck.Interval) * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		c.heartbeat.Beat()
+		select {
+		case <-ticker.C:
+			c.Run(Options{Checksums: c.config.Fsck.Checksums, Repair: c.config.Fsck.Repair})
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// Heartbeat reports whether the check schedule is still running
+func (c *Checker) Heartbeat() *health.Heartbeat {
+	return c.heartbeat
+}
+
+// Close stops the schedule and waits for a check that is running
+func (c *Checker) Close() {
+	close(c.stopChan)
+	c.running.Lock()
+	defer c.running.Unlock()
+}
+
+// Last returns the report of the most recent check, or nil
+func (c *Checker) Last() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// Run checks every image record against the storage path and applies the
+// requested repairs
+func (c *Checker) Run(opts Options) (*Report, error) {
+	if err := config.ValidateRepairActions(opts.Repair); err != nil {
+		return nil, err
+	}
+	if !c.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer c.running.Unlock()
+
+	report := &Report{
+		StartedAt: time.Now().UTC(),
+		Options:   opts,
+		Counts:    map[string]int{},
+		Issues:    []Issue{},
+	}
+
+	images, err := c.db.ListImages("", "", "")
+	if err != nil {
+		return nil, err
+	}
+	report.Images = len(images)
+
+	byPath := make(map[string]*models.Image, len(images))
+	for i := range images {
+		byPath[storage.ImagePath(c.config.Storage.BasePath, &images[i])] = &images[i]
+	}
+
+	// Walk the storage path, matching files to records
+	seen := make(map[string]bool, len(images))
+	var orphans []string
+	err = filepath.WalkDir(c.config.Storage.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		// Dotfiles are uploads, imports, migrations and health checks in
+		// flight. They are not stored files, and become orphans only once
+		// they outlive the grace period.
+		temporary := strings.HasPrefix(d.Name(), ".")
+		if !temporary {
+			report.Files++
+		}
+
+		if image, ok := byPath[path]; ok {
+			seen[path] = true
+			c.checkImage(report, opts, image, path)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) < orphanGrace {
+			return nil
+		}
+		orphans = append(orphans, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range orphans {
+		c.checkOrphan(report, opts, path)
+	}
+
+	for i := range images {
+		image := &images[i]
+		path := storage.ImagePath(c.config.Storage.BasePath, image)
+		if seen[path] {
+			// The file is back, so the record is no longer missing
+			if image.Missing && opts.repairs(config.RepairMarkMissing) {
+				if err := c.db.SetImageMissing(image.ID, false); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		c.checkMissing(report, opts, image, path)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	c.finish(report)
+	return report, nil
+}
+
+// checkImage compares a stored file with its record
+func (c *Checker) checkImage(report *Report, opts Options, image *models.Image, path string) {
+	file, err := encryption.Open(path, c.keyring)
+	if err != nil {
+		report.add(Issue{Kind: Unreadable, UUID: image.UUID, Path: path, Detail: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		report.add(Issue{Kind: Unreadable, UUID: image.UUID, Path: path, Detail: err.Error()})
+		return
+	}
+	if size != image.Size {
+		report.add(Issue{
+			Kind:   SizeMismatch,
+			UUID:   image.UUID,
+			Path:   path,
+			Detail: fmt.Sprintf("record says %d bytes, file has %d", image.Size, size),
+		})
+		return
+	}
+
+	if !opts.Checksums {
+		return
+	}
+
+	digest, err := checksum(file)
+	if err != nil {
+		report.add(Issue{Kind: Unreadable, UUID: image.UUID, Path: path, Detail: err.Error()})
+		return
+	}
+
+	switch {
+	case image.SHA256 == "":
+		if opts.repairs(config.RepairRecordChecksums) {
+			if err := c.db.SetImageChecksum(image.ID, digest); err != nil {
+				c.logger.Error("Failed to record checksum", map[string]interface{}{
+					"error": err.Error(),
+					"uuid":  image.UUID,
+				})
+				return
+			}
+			report.ChecksumsRecorded++
+		}
+	case image.SHA256 != digest:
+		report.add(Issue{
+			Kind:   ChecksumMismatch,
+			UUID:   image.UUID,
+			Path:   path,
+			Detail: fmt.Sprintf("record has sha256 %s, file has %s", image.SHA256, digest),
+		})
+	}
+}
+
+// checkMissing reports a record without a file
+func (c *Checker) checkMissing(report *Report, opts Options, image *models.Image, path string) {
+	issue := Issue{Kind: MissingFile, UUID: image.UUID, Path: path, Detail: "no file for this image"}
+	if image.Missing {
+		issue.Detail = "no file for this image, already marked missing"
+	}
+
+	switch {
+	case opts.repairs(config.RepairDeleteMissing):
+		issue.Repair = "deleted record"
+		if err := c.db.DeleteImageByID(image.ID); err != nil {
+			issue.Repair, issue.RepairError = "", err.Error()
+		}
+	case opts.repairs(config.RepairMarkMissing) && !image.Missing:
+		issue.Repair = "marked missing"
+		if err := c.db.SetImageMissing(image.ID, true); err != nil {
+			issue.Repair, issue.RepairError = "", err.Error()
+		}
+	}
+	report.add(issue)
+}
+
+// checkOrphan reports a file without a record
+func (c *Checker) checkOrphan(report *Report, opts Options, path string) {
+	issue := Issue{Kind: OrphanFile, Path: path, Detail: "no image record for this file"}
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		issue.Detail = "stale temporary file"
+	}
+
+	switch {
+	case opts.repairs(config.RepairDeleteOrphans):
+		issue.Repair = "deleted file"
+		if err := os.Remove(path); err != nil {
+			issue.Repair, issue.RepairError = "", err.Error()
+		}
+	case opts.repairs(config.RepairImportOrphans):
+		image, err := c.importOrphan(path)
+		if err != nil {
+			issue.RepairError = err.Error()
+		} else {
+			issue.UUID = image.UUID
+			issue.Repair = "imported"
+		}
+	}
+	report.add(issue)
+}
+
+// importOrphan creates the record for a file that sits where an upload would
+// have put it: <base>/<year>/<month>/<day>/<uuid>.<ext>
+func (c *Checker) importOrphan(path string) (*models.Image, error) {
+	rel, err := filepath.Rel(c.config.Storage.BasePath, path)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 4 {
+		return nil, errors.New("not in a year/month/day directory")
+	}
+	year, yerr := strconv.Atoi(parts[0])
+	month, merr := strconv.Atoi(parts[1])
+	day, derr := strconv.Atoi(parts[2])
+	if yerr != nil || merr != nil || derr != nil {
+		return nil, errors.New("not in a year/month/day directory")
+	}
+
+	name := parts[3]
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	uuid := strings.TrimSuffix(name, filepath.Ext(name))
+	if strings.HasPrefix(name, ".") || !c.uuidRe.MatchString(uuid) {
+		return nil, errors.New("file name is not a valid image UUID")
+	}
+	allowed := false
+	for _, allowedExt := range c.config.Storage.AllowedExtensions {
+		if ext == allowedExt {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("file type '.%s' not allowed", ext)
+	}
+
+	existing, err := c.db.GetImage(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("UUID belongs to an image stored at %s", storage.ImagePath(c.config.Storage.BasePath, existing))
+	}
+
+	file, err := encryption.Open(path, c.keyring)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, known := upload.FormatForExtension(ext); known {
+		limits := upload.Limits{
+			MaxPixels:       c.config.Uploads.MaxPixels,
+			MaxDimension:    c.config.Uploads.MaxDimension,
+			AllowUnverified: c.config.Uploads.AllowUnverified,
+		}
+		if err := upload.Validate(file, ext, limits); err != nil {
+			return nil, err
+		}
+	} else if !c.config.Uploads.AllowUnverified {
+		return nil, fmt.Errorf("file type '.%s' cannot be verified", ext)
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := checksum(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the directory's date so the record points back at this file
+	mtime := info.ModTime().UTC()
+	uploadedAt := time.Date(year, time.Month(month), day, mtime.Hour(), mtime.Minute(), mtime.Second(), 0, time.UTC)
+	if storage.ImagePath(c.config.Storage.BasePath, &models.Image{UUID: uuid, Extension: ext, UploadedAt: uploadedAt}) != path {
+		return nil, errors.New("not in a year/month/day directory")
+	}
+
+	image := &models.Image{
+		UUID:       uuid,
+		Filename:   name,
+		Extension:  ext,
+		Size:       size,
+		UploadedAt: uploadedAt,
+		SHA256:     digest,
+	}
+	if err := c.db.CreateImage(image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// finish keeps the report and logs a summary
+func (c *Checker) finish(report *Report) {
+	c.mu.Lock()
+	c.last = report
+	c.mu.Unlock()
+
+	for _, kind := range Kinds {
+		metrics.FsckIssues.Set(float64(report.Counts[kind]), kind)
+	}
+
+	data := map[string]interface{}{
+		"images":             report.Images,
+		"files":              report.Files,
+		"issues":             len(report.Issues),
+		"checksums_recorded": report.ChecksumsRecorded,
+		"repair":             report.Options.Repair,
+		"duration":           report.FinishedAt.Sub(report.StartedAt).String(),
+	}
+	for kind, count := range report.Counts {
+		data[kind] = count
+	}
+	if len(report.Issues) > 0 {
+		c.logger.Warn("Storage check found issues", data)
+	} else {
+		c.logger.Info("Storage check passed", data)
+	}
+}
+
+// checksum returns the SHA-256 of a stored file's content
+func checksum(file encryption.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"sharex/internal/config"
+	"sharex/internal/models"
+	"sharex/internal/utils"
+)
+
+// EraseIPAnalytics deletes the raw view rows recorded for an IP address, for
+// data-subject erasure requests. Rows stored in truncated form cover the whole
+// /24 or /48 prefix and hold other visitors' views too, so they are only
+// erased when include_prefix is set.
+func (h *Handler) EraseIPAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	var req struct {
+		IP            string `json:"ip"`
+		IncludePrefix bool   `json:"include_prefix"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ip := strings.TrimSpace(req.IP)
+	if ip == "" {
+		http.Error(w, "IP address is required", http.StatusBadRequest)
+		return
+	}
+
+	// Views may have been recorded under any anonymization mode over time,
+	// so erase every stored form that identifies only this address
+	forms := []string{ip}
+	if h.config.Get().Analytics.HashKey != "" {
+		forms = append(forms, utils.AnonymizeIP(ip, config.IPAnonymizationHash, h.config.Get().Analytics.HashKey))
+	}
+
+	deleted, err := h.db.EraseImageViewsByIP(forms...)
+	if err != nil {
+		h.logger.Error("Failed to erase IP analytics", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var prefixDeleted int64
+	if req.IncludePrefix {
+		prefix := utils.AnonymizeIP(ip, config.IPAnonymizationTruncate, "")
+		if prefix != ip {
+			prefixDeleted, err = h.db.EraseImageViewsByIP(prefix)
+			if err != nil {
+				h.logger.Error("Failed to erase IP prefix analytics", map[string]interface{}{
+					"error": err.Error(),
+				})
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	h.logger.Info("Erased IP analytics", map[string]interface{}{
+		"deleted":        deleted,
+		"prefix_deleted": prefixDeleted,
+	})
+	// The erased address itself is deliberately not recorded
+	h.audit(r, "", models.AuditAnalyticsErase, "analytics", "", nil, map[string]interface{}{
+		"deleted":        deleted,
+		"include_prefix": req.IncludePrefix,
+		"prefix_deleted": prefixDeleted,
+	})
+
+	response := map[string]interface{}{
+		"success": true,
+		"deleted": deleted,
+	}
+	if req.IncludePrefix {
+		response["prefix_deleted"] = prefixDeleted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"sharex/internal/archive"
+	"sharex/internal/events"
+	"sharex/internal/models"
+)
+
+// progressInterval limits how often transfer progress is published
+const progressInterval = time.Second
+
+// importOverhead is allowed on top of max_storage for an imported archive's
+// manifest and zip structures
+const importOverhead = 64 << 20
+
+// ExportArchive streams a zip archive of the library that another instance
+// can import. It takes the type, from and to filters of ListImages, and
+// views=true adds view counts per day and country. Admin only.
+func (h *Handler) ExportArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := archive.ExportOptions{
+		Type:     query.Get("type"),
+		DateFrom: query.Get("from"),
+		DateTo:   query.Get("to"),
+		Views:    query.Get("views") == "true",
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("export-%s.zip", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Large libraries take longer than server.write_timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// The status is sent with the first image, so a failure part way can only
+	// cut the archive short. An archive without its manifest is rejected on
+	// import.
+	manifest, err := archive.New(h.config.Get(), h.db, h.keyring, h.scanner).Export(w, opts, h.transferProgress("export"))
+	if err != nil {
+		h.logger.Error("Failed to export archive", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.audit(r, "", models.AuditLibraryExport, "library", "", nil, map[string]interface{}{
+		"images": len(manifest.Images),
+		"type":   opts.Type,
+		"from":   opts.DateFrom,
+		"to":     opts.DateTo,
+		"views":  opts.Views,
+	})
+}
+
+// ImportArchive adds the images of an archive made by ExportArchive, sent as
+// the request body. conflict=skip, rename or keep decides what happens to
+// images whose UUID is taken. Admin only.
+func (h *Handler) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	conflict, err := archive.ValidateConflict(r.URL.Query().Get("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// An archive cannot hold more than the library may store, so the body is
+	// cut off there before it fills the disk
+	cfg := h.config.Get()
+	maxStorage, err := cfg.GetMaxStorage()
+	if err != nil {
+		h.logger.Error("Failed to get max storage", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if maxStorage >= 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxStorage+importOverhead)
+	}
+
+	// Large archives take longer to upload and import than
+	// server.read_timeout and write_timeout allow
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	// Zip archives are read from the end, so spool the body to the storage
+	// volume first. The dot keeps fsck and backups away from it.
+	tmp, err := os.CreateTemp(cfg.Storage.BasePath, ".import-*.zip")
+	if err != nil {
+		h.logger.Error("Failed to create temporary file", map[string]interface{}{
+			"error": err.Error(),
+			"path":  cfg.Storage.BasePath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Archive exceeds max_storage", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read archive", http.StatusBadRequest)
+		return
+	}
+
+	transfer := archive.New(cfg, h.db, h.keyring, h.scanner)
+	report, err := transfer.Import(tmp, size, archive.ImportOptions{Conflict: conflict}, h.transferProgress("import"))
+	if err != nil && report == nil {
+		// Nothing was imported, the archive itself is unusable
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Import stopped", map[string]interface{}{
+			"error":     err.Error(),
+			"processed": len(report.Results),
+		})
+	}
+
+	h.audit(r, "", models.AuditLibraryImport, "library", report.Source, nil, map[string]interface{}{
+		"conflict": conflict,
+		"counts":   report.Counts,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report": report,
+	})
+}
+
+// transferProgress publishes the progress of an export or import to the live
+// event feed, at most once per progressInterval and always when it is done
+func (h *Handler) transferProgress(operation string) archive.Progress {
+	var last time.Time
+	return func(done, total int) {
+		if done < total && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		h.events.Publish(events.TypeTransfer, map[string]interface{}{
+			"operation": operation,
+			"done":      done,
+			"total":     total,
+		})
+	}
+}
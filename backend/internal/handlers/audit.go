@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+const (
+	auditDefaultPageSize = 50
+	auditMaxPageSize     = 500
+)
+
+// audit records an action in the audit log. The actor defaults to the
+// authenticated user; before and after are stored as JSON and may be nil.
+// Failures are logged but never fail the request.
+func (h *Handler) audit(r *http.Request, actor, action, targetType, target string, before, after interface{}) {
+	if actor == "" {
+		actor = middleware.GetUsername(r)
+	}
+
+	event := &models.AuditEvent{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		IP:         utils.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Before:     auditValue(before),
+		After:      auditValue(after),
+	}
+	if err := h.db.RecordAuditEvent(event); err != nil {
+		h.logger.Error("Failed to record audit event", map[string]interface{}{
+			"error":  err.Error(),
+			"action": action,
+			"actor":  actor,
+			"target": target,
+		})
+	}
+}
+
+func auditValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// AuditEvents lists audit events, newest first. Filters: actor, action (a
+// trailing * matches a prefix such as image.*), target_type, target, ip,
+// from and to (RFC 3339 or YYYY-MM-DD). Paginated with page and per_page.
+func (h *Handler) AuditEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		Target:     query.Get("target"),
+		IP:         query.Get("ip"),
+	}
+
+	var err error
+	if filter.From, err = parseAuditTime(query.Get("from"), false); err != nil {
+		http.Error(w, "Invalid from, expected RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseAuditTime(query.Get("to"), true); err != nil {
+		http.Error(w, "Invalid to, expected RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	page, perPage := 1, auditDefaultPageSize
+	if v := query.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > auditMaxPageSize {
+			http.Error(w, "Invalid per_page, expected 1 to 500", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, total, err := h.db.ListAuditEvents(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		h.logger.Error("Failed to list audit events", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":   events,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// VerifyAuditLog recomputes the audit log's hash chain
+func (h *Handler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	status, err := h.db.VerifyAuditChain()
+	if err != nil {
+		h.logger.Error("Failed to verify audit log", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !status.Valid {
+		h.logger.Warn("Audit log hash chain is broken", map[string]interface{}{
+			"broken_at": status.BrokenAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates. A date used as
+// the end of a range includes the whole day.
+func parseAuditTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
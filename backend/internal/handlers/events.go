@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"sharex/internal/events"
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+)
+
+// Events streams view, upload, delete and privacy events to the dashboard as
+// Server-Sent Events. Clients resume after a reconnect with Last-Event-ID.
+// The session is checked again before every event and heartbeat, and the
+// stream ends when the access token expires, so clients reconnect with a
+// fresh one.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	controller := http.NewResponseController(w)
+
+	// The stream stays open for as long as the dashboard does
+	controller.SetWriteDeadline(time.Time{})
+
+	// The last event ID comes from the header on reconnects, or from the query
+	// string for clients that cannot set headers. IDs this instance did not
+	// issue, including malformed ones, end in a resync.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+
+	sub, missed, complete := h.events.Subscribe(lastID)
+	defer h.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+
+	// Ask the browser to reconnect quickly if the stream drops
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+
+	// Part of the history was lost, tell the client to refetch its data
+	if !complete {
+		fmt.Fprintf(w, "event: resync\ndata: {}\n\n")
+	}
+
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		h.logger.Error("Event stream does not support flushing", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.logger.Debug("Event subscriber connected", map[string]interface{}{
+		"last_event_id": lastID,
+		"replayed":      len(missed),
+		"subscribers":   h.events.SubscriberCount(),
+	})
+
+	heartbeat := time.NewTicker(time.Duration(h.config.Get().Events.HeartbeatInterval) * time.Second)
+	defer heartbeat.Stop()
+
+	var expired <-chan time.Time
+	if expires := middleware.GetAccessExpiry(r); !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	sessionID := middleware.GetSessionID(r)
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, the client reconnects and resumes
+				h.logger.Warn("Dropped slow event subscriber", nil)
+				return
+			}
+			if !h.streamAuthorized(w, sessionID) {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if !h.streamAuthorized(w, sessionID) {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-expired:
+			writeUnauthorized(w)
+			controller.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		case <-h.events.Done():
+			return
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamAuthorized reports whether the session behind an event stream is
+// still active and held by an admin. Logouts, revocations and role changes
+// end the stream with an unauthorized event, a failed check ends it without.
+func (h *Handler) streamAuthorized(w http.ResponseWriter, sessionID string) bool {
+	role, err := h.db.SessionRole(sessionID)
+	if err != nil {
+		h.logger.Error("Failed to check event stream session", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return false
+	}
+	if role != models.RoleAdmin {
+		writeUnauthorized(w)
+		http.NewResponseController(w).Flush()
+		return false
+	}
+	return true
+}
+
+// writeUnauthorized tells the client its session no longer grants the stream
+func writeUnauthorized(w io.Writer) {
+	fmt.Fprint(w, "event: unauthorized\ndata: {}\n\n")
+}
+
+// writeEvent writes a single event in text/event-stream format
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/events"
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// openEventStream signs in an admin, opens the event stream through the auth
+// middleware and returns its reader
+func openEventStream(t *testing.T, heartbeat int) (*storage.DB, *events.Broker, *models.User, *bufio.Reader) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.App.JWTSecret = "test-secret"
+	cfg.Events.HeartbeatInterval = heartbeat
+
+	db, err := storage.NewDB("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	logger, err := utils.NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := events.NewBroker(16, 16)
+	h := NewHandler(config.NewStore(cfg), db, logger, broker, nil, nil, nil, nil)
+
+	if err := db.CreateUser("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	session := &models.Session{ID: "session", UserID: user.ID, CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := db.CreateSession(session, "refresh"); err != nil {
+		t.Fatal(err)
+	}
+	accessToken, _, err := utils.GenerateTokenPair(user.Username, session.ID, "refresh", cfg.App.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(middleware.AuthMiddleware(cfg, db)(http.HandlerFunc(h.Events)))
+	t.Cleanup(server.Close)
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	return db, broker, user, bufio.NewReader(resp.Body)
+}
+
+// readEventTypes returns the event types of a stream until it ends
+func readEventTypes(t *testing.T, stream *bufio.Reader) []string {
+	t.Helper()
+	var types []string
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			return types
+		}
+		if eventType, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, strings.TrimSpace(eventType))
+		}
+	}
+}
+
+func TestEventsEndWithSession(t *testing.T) {
+	tests := []struct {
+		name      string
+		heartbeat int
+		change    func(t *testing.T, db *storage.DB, user *models.User, broker *events.Broker)
+	}{
+		{
+			name:      "session revoked before an event",
+			heartbeat: 60,
+			change: func(t *testing.T, db *storage.DB, user *models.User, broker *events.Broker) {
+				if _, err := db.RevokeAllSessions(user.ID, "", storage.RevokedLogout); err != nil {
+					t.Fatal(err)
+				}
+				broker.Publish(events.TypeUpload, map[string]interface{}{"uuid": "abc"})
+			},
+		},
+		{
+			name:      "role downgraded before a heartbeat",
+			heartbeat: 1,
+			change: func(t *testing.T, db *storage.DB, user *models.User, broker *events.Broker) {
+				if err := db.SetUserRole(user.ID, models.RoleUser); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, broker, user, stream := openEventStream(t, tt.heartbeat)
+
+			// The retry line arrives once the subscription is in place
+			if _, err := stream.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, db, user, broker)
+
+			types := readEventTypes(t, stream)
+			if len(types) != 1 || types[0] != "unauthorized" {
+				t.Errorf("stream sent events %v, want only unauthorized", types)
+			}
+		})
+	}
+}
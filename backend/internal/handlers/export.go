@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// exportFlushInterval is the number of rows written between response flushes
+const exportFlushInterval = 500
+
+// exportColumn describes one column of a view export
+type exportColumn struct {
+	name  string
+	value func(v models.RecentView, botClass string) interface{}
+}
+
+// standardExportColumns mirror the fields shown in the dashboard
+var standardExportColumns = []exportColumn{
+	{"id", func(v models.RecentView, _ string) interface{} { return v.ID }},
+	{"image_id", func(v models.RecentView, _ string) interface{} { return v.ImageID }},
+	{"image_uuid", func(v models.RecentView, _ string) interface{} { return v.ImageUUID }},
+	{"ip", func(v models.RecentView, _ string) interface{} { return v.IP }},
+	{"country", func(v models.RecentView, _ string) interface{} { return v.Country }},
+	{"user_agent", func(v models.RecentView, _ string) interface{} { return v.UserAgent }},
+	{"bot_class", func(_ models.RecentView, botClass string) interface{} { return botClass }},
+	{"viewed_at", func(v models.RecentView, _ string) interface{} { return v.ViewedAt.UTC().Format(time.RFC3339) }},
+}
+
+// parquetExportColumns use flat, consistently typed columns with a date column
+// for partitioning, so the output converts to Parquet without a schema mapping
+var parquetExportColumns = []exportColumn{
+	{"view_id", func(v models.RecentView, _ string) interface{} { return v.ID }},
+	{"image_id", func(v models.RecentView, _ string) interface{} { return v.ImageID }},
+	{"image_uuid", func(v models.RecentView, _ string) interface{} { return v.ImageUUID }},
+	{"view_date", func(v models.RecentView, _ string) interface{} { return v.ViewedAt.UTC().Format("2006-01-02") }},
+	{"viewed_at_ms", func(v models.RecentView, _ string) interface{} { return v.ViewedAt.UTC().UnixMilli() }},
+	{"ip", func(v models.RecentView, _ string) interface{} { return v.IP }},
+	{"country_code", func(v models.RecentView, _ string) interface{} { return strings.ToUpper(v.Country) }},
+	{"user_agent", func(v models.RecentView, _ string) interface{} { return v.UserAgent }},
+	{"bot_class", func(_ models.RecentView, botClass string) interface{} { return botClass }},
+}
+
+// ExportViews streams raw view rows as CSV or NDJSON, straight from the database
+func (h *Handler) ExportViews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.ViewFilter{
+		DateFrom: query.Get("from"),
+		DateTo:   query.Get("to"),
+		Country:  strings.TrimSpace(query.Get("country")),
+	}
+
+	for _, date := range []string{filter.DateFrom, filter.DateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The image can be given either by ID or by UUID
+	if imageParam := query.Get("image"); imageParam != "" {
+		var image *models.Image
+		var err error
+		if id, parseErr := strconv.ParseInt(imageParam, 10, 64); parseErr == nil {
+			image, err = h.db.GetImageByID(id)
+		} else {
+			image, err = h.db.GetImage(imageParam)
+		}
+		if err != nil {
+			h.logger.Error("Failed to get image", map[string]interface{}{
+				"error": err.Error(),
+				"image": imageParam,
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if image == nil {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		filter.ImageID = image.ID
+	}
+
+	botFilter := strings.ToLower(query.Get("bot"))
+	switch botFilter {
+	case "", "all":
+		botFilter = ""
+	case utils.BotClassHuman, utils.BotClassBot, utils.BotClassPreview:
+	default:
+		http.Error(w, "Invalid bot class, expected human, bot, preview or all", http.StatusBadRequest)
+		return
+	}
+
+	columns := standardExportColumns
+	switch query.Get("layout") {
+	case "", "standard":
+	case "parquet":
+		columns = parquetExportColumns
+	default:
+		http.Error(w, "Invalid layout, expected standard or parquet", http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		http.Error(w, "Invalid format, expected csv or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("views-%s.%s", time.Now().Format("20060102-150405"), format)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Large exports take longer than server.write_timeout allows
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+	buffered := bufio.NewWriter(w)
+	csvWriter := csv.NewWriter(buffered)
+
+	if format == "csv" {
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		csvWriter.Write(header)
+	}
+
+	var rowCount int64
+	err := h.db.StreamImageViews(r.Context(), filter, func(view models.RecentView) error {
+		botClass := utils.ClassifyUserAgent(view.UserAgent)
+		if botFilter != "" && botClass != botFilter {
+			return nil
+		}
+
+		if format == "csv" {
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				record[i] = fmt.Sprint(column.value(view, botClass))
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return err
+			}
+		} else {
+			if err := writeNDJSONRow(buffered, columns, view, botClass); err != nil {
+				return err
+			}
+		}
+
+		rowCount++
+		if rowCount%exportFlushInterval == 0 {
+			csvWriter.Flush()
+			if err := buffered.Flush(); err != nil {
+				return err
+			}
+			controller.Flush()
+		}
+		return nil
+	})
+
+	csvWriter.Flush()
+	buffered.Flush()
+
+	if err != nil {
+		// Headers are already sent, all we can do is log and cut the stream short
+		h.logger.Error("Failed to export views", map[string]interface{}{
+			"error": err.Error(),
+			"rows":  rowCount,
+		})
+		return
+	}
+
+	h.logger.Info("Exported views", map[string]interface{}{
+		"rows":   rowCount,
+		"format": format,
+	})
+}
+
+// writeNDJSONRow writes a single view as a JSON object with keys in column order
+func writeNDJSONRow(w *bufio.Writer, columns []exportColumn, view models.RecentView, botClass string) error {
+	w.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		key, _ := json.Marshal(column.name)
+		value, err := json.Marshal(column.value(view, botClass))
+		if err != nil {
+			return err
+		}
+		w.Write(key)
+		w.WriteByte(':')
+		w.Write(value)
+	}
+	w.WriteByte('}')
+	return w.WriteByte('\n')
+}
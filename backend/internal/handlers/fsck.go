@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"sharex/internal/config"
+	"sharex/internal/fsck"
+	"sharex/internal/models"
+)
+
+// Fsck returns the last storage check report on GET and runs a check on POST.
+// A POST without repair actions only reports.
+func (h *Handler) Fsck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"report": h.checker.Last(),
+		})
+		return
+	}
+
+	var opts fsck.Options
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := config.ValidateRepairActions(opts.Repair); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.checker.Run(opts)
+	if errors.Is(err, fsck.ErrRunning) {
+		http.Error(w, "A storage check is already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Storage check failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(opts.Repair) > 0 {
+		h.audit(r, "", models.AuditStorageRepair, "storage", "", nil, map[string]interface{}{
+			"repair":             opts.Repair,
+			"repaired":           report.Repaired(),
+			"checksums_recorded": report.ChecksumsRecorded,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report": report,
+	})
+}
@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -12,30 +12,73 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"sharex/internal/config"
+	"sharex/internal/encryption"
+	"sharex/internal/events"
+	"sharex/internal/fsck"
+	"sharex/internal/lockout"
+	"sharex/internal/metrics"
 	"sharex/internal/middleware"
 	"sharex/internal/models"
+	"sharex/internal/oidc"
 	"sharex/internal/storage"
+	"sharex/internal/upload"
 	"sharex/internal/utils"
+	"sharex/internal/webhooks"
 )
 
 type Handler struct {
-	config *config.Config
-	db     *storage.DB
-	logger *utils.Logger
+	config   *config.Store
+	db       *storage.DB
+	logger   *utils.Logger
+	events   *events.Broker
+	webhooks *webhooks.Dispatcher
+	sso      *oidc.Provider
+	guard    *lockout.Guard
+	scanner  upload.Scanner      // nil when uploads are not scanned
+	keyring  *encryption.Keyring // nil when no encryption key is configured
+	checker  *fsck.Checker
+
+	challengeFailures *challengeFailures
+	unlockFailures    *unlockFailures
+	unlockSlots       chan struct{} // bounds the password hashes checked at once
+	storageUsage      usageCache    // storage usage reported by the metrics
 }
 
-func NewHandler(cfg *config.Config, db *storage.DB, logger *utils.Logger) *Handler {
+func NewHandler(configs *config.Store, db *storage.DB, logger *utils.Logger, broker *events.Broker, dispatcher *webhooks.Dispatcher, scanner upload.Scanner, keyring *encryption.Keyring, checker *fsck.Checker) *Handler {
+	cfg := configs.Get()
+	var sso *oidc.Provider
+	if cfg.OIDC.Enabled {
+		sso = oidc.NewProvider(cfg)
+	}
+
 	return &Handler{
-		config: cfg,
-		db:     db,
-		logger: logger,
+		config:   configs,
+		db:       db,
+		logger:   logger,
+		events:   broker,
+		webhooks: dispatcher,
+		sso:      sso,
+		guard:    lockout.NewGuard(cfg, db, logger),
+		scanner:  scanner,
+		keyring:  keyring,
+		checker:  checker,
+
+		challengeFailures: newChallengeFailures(),
+		unlockFailures:    newUnlockFailures(),
+		unlockSlots:       make(chan struct{}, maxConcurrentUnlocks),
 	}
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if h.config.Get().OIDC.DisablePasswordLogin {
+		http.Error(w, "Password login is disabled, sign in with single sign-on", http.StatusForbidden)
+		return
+	}
+
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.logger.Warn("Invalid login request", map[string]interface{}{
@@ -45,6 +88,14 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse attempts for throttled accounts and IPs before looking at the password
+	ip := utils.GetIPFromAddr(r)
+	release, allowed := h.loginAllowed(w, r, req.Username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
+
 	user, err := h.db.GetUser(req.Username)
 	if err != nil {
 		h.logger.Error("Failed to get user", map[string]interface{}{
@@ -55,17 +106,82 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user == nil || user.Password != req.Password {
+	// Single sign-on users have no local password, and disabled users cannot log in
+	if !checkUserPassword(user, req.Password) || user.Disabled {
 		h.logger.Warn("Invalid login attempt", map[string]interface{}{
 			"username": req.Username,
+			"ip":       ip,
 		})
+		h.loginFailed(r, req.Username, ip, "password")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate token pair
-	accessToken, refreshToken, err := utils.GenerateTokenPair(user.Username, h.config.App.JWTSecret)
-	if err != nil {
+	// Passwords older versions stored verbatim are hashed once they are proven
+	if !utils.IsPasswordHash(user.Password) {
+		if err := h.db.RehashUserPassword(user.ID, req.Password); err != nil {
+			h.logger.Error("Failed to hash legacy password", map[string]interface{}{
+				"error":    err.Error(),
+				"username": user.Username,
+			})
+		}
+	}
+
+	// With two-factor enabled the password step only yields a challenge token
+	if user.TOTPEnabled {
+		challengeToken, err := utils.GenerateChallengeToken(user.Username, h.config.Get().App.JWTSecret)
+		if err != nil {
+			h.logger.Error("Failed to generate challenge token", map[string]interface{}{
+				"error":    err.Error(),
+				"username": user.Username,
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Password accepted, awaiting second factor", map[string]interface{}{
+			"username": user.Username,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         int(utils.TwoFactorChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
+
+// unknownUserHash is checked against when there is no stored password, so
+// unknown users take as long to reject as wrong passwords
+var unknownUserHash = sync.OnceValue(func() string {
+	hash, _ := utils.HashPassword("")
+	return hash
+})
+
+// checkUserPassword reports whether password is the local password of user,
+// taking about as long whether or not the user has one
+func checkUserPassword(user *models.User, password string) bool {
+	if user == nil || user.Password == "" {
+		utils.CheckPassword(password, unknownUserHash())
+		return false
+	}
+	return utils.CheckPassword(password, user.Password)
+}
+
+// completeLogin issues the session cookies once every login step has passed
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if err := h.guard.Succeed(user.Username); err != nil {
+		h.logger.Error("Failed to reset login failures", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+	}
+
+	if err := h.startSession(w, r, user); err != nil {
 		h.logger.Error("Failed to generate tokens", map[string]interface{}{
 			"error":    err.Error(),
 			"username": user.Username,
@@ -74,8 +190,57 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.logger.Info("User logged in successfully", map[string]interface{}{
+		"username": user.Username,
+	})
+	h.audit(r, user.Username, models.AuditLogin, "user", user.Username, nil, map[string]interface{}{
+		"provider":   models.AuthProviderLocal,
+		"two_factor": user.TOTPEnabled,
+	})
+
+	// Set content type header
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return only necessary information
+	json.NewEncoder(w).Encode(models.LoginResponse{
+		Username: user.Username,
+	})
+}
+
+// startSession records a new server-side session and sets the token, CSRF
+// and upload key cookies for a user
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	sessionID, err := utils.GenerateTokenID()
+	if err != nil {
+		return err
+	}
+	refreshID, err := utils.GenerateTokenID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		IP:         utils.GetIPFromAddr(r),
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL),
+	}
+	if err := h.db.CreateSession(session, refreshID); err != nil {
+		return err
+	}
+
+	// Generate token pair
+	accessToken, refreshToken, err := utils.GenerateTokenPair(user.Username, sessionID, refreshID, h.config.Get().App.JWTSecret)
+	if err != nil {
+		return err
+	}
+
 	// Set secure cookies
-	utils.SetTokenCookies(w, accessToken, refreshToken, h.config.App.Environment == "production")
+	utils.SetTokenCookies(w, accessToken, refreshToken, h.config.Get().App.Environment == "production")
 
 	// Generate CSRF token
 	csrfToken := middleware.GenerateCSRFToken()
@@ -84,7 +249,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		Value:    csrfToken,
 		Path:     "/",
 		HttpOnly: false, // Needs to be accessible by JavaScript
-		Secure:   h.config.App.Environment == "production",
+		Secure:   h.config.Get().App.Environment == "production",
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   int((24 * time.Hour).Seconds()),
 	})
@@ -92,25 +257,15 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	// Set upload key cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "upload_key",
-		Value:    h.config.App.UploadKey,
+		Value:    h.config.Get().App.UploadKey,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   h.config.App.Environment == "production",
+		Secure:   h.config.Get().App.Environment == "production",
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   int((24 * time.Hour).Seconds()),
 	})
 
-	h.logger.Info("User logged in successfully", map[string]interface{}{
-		"username": user.Username,
-	})
-
-	// Set content type header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Return only necessary information
-	json.NewEncoder(w).Encode(models.LoginResponse{
-		Username: user.Username,
-	})
+	return nil
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -119,14 +274,27 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Revoke the session so its refresh token can no longer be used
+	if claims, err := utils.ValidateToken(utils.GetTokenFromCookie(r, "refresh_token"), h.config.Get().App.JWTSecret); err == nil && claims.SessionID != "" {
+		if user, err := h.db.GetUser(claims.Username); err == nil && user != nil {
+			if _, err := h.db.RevokeSession(user.ID, claims.SessionID, storage.RevokedLogout); err != nil {
+				h.logger.Error("Failed to revoke session", map[string]interface{}{
+					"error":    err.Error(),
+					"username": claims.Username,
+				})
+			}
+			h.audit(r, claims.Username, models.AuditLogout, "session", claims.SessionID, nil, nil)
+		}
+	}
+
 	// Clear all auth cookies with proper attributes
-	utils.ClearTokenCookies(w, h.config.App.Environment == "production")
+	utils.ClearTokenCookies(w, h.config.Get().App.Environment == "production")
 	http.SetCookie(w, &http.Cookie{
 		Name:     "csrf_token",
 		Value:    "",
 		Path:     "/",
 		HttpOnly: false,
-		Secure:   h.config.App.Environment == "production",
+		Secure:   h.config.Get().App.Environment == "production",
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   -1,
 	})
@@ -137,7 +305,7 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   h.config.App.Environment == "production",
+		Secure:   h.config.Get().App.Environment == "production",
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   -1,
 	})
@@ -161,21 +329,57 @@ func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate refresh token
-	claims, err := utils.ValidateToken(refreshToken, h.config.App.JWTSecret)
-	if err != nil || claims.TokenType != utils.RefreshToken {
+	claims, err := utils.ValidateToken(refreshToken, h.config.Get().App.JWTSecret)
+	if err != nil || claims.TokenType != utils.RefreshToken || claims.SessionID == "" || claims.ID == "" {
 		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
+	newRefreshID, err := utils.GenerateTokenID()
+	if err != nil {
+		http.Error(w, "Failed to generate new tokens", http.StatusInternalServerError)
+		return
+	}
+
+	// Rotate the refresh token, a token that was already used revokes its session
+	_, err = h.db.RotateRefreshToken(claims.ID, newRefreshID, utils.GetIPFromAddr(r), r.UserAgent(),
+		time.Now().Add(utils.RefreshTokenTTL), refreshReuseGrace)
+	if errors.Is(err, storage.ErrRefreshTokenReused) {
+		h.logger.Warn("Refresh token reuse detected, session revoked", map[string]interface{}{
+			"username": claims.Username,
+			"session":  claims.SessionID,
+			"ip":       utils.GetIPFromAddr(r),
+		})
+		h.audit(r, claims.Username, models.AuditTokenReused, "session", claims.SessionID, nil, map[string]interface{}{
+			"revoked": true,
+		})
+		utils.ClearTokenCookies(w, h.config.Get().App.Environment == "production")
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, storage.ErrInvalidRefreshToken) {
+		utils.ClearTokenCookies(w, h.config.Get().App.Environment == "production")
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to rotate refresh token", map[string]interface{}{
+			"error":    err.Error(),
+			"username": claims.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Generate new token pair
-	accessToken, newRefreshToken, err := utils.GenerateTokenPair(claims.Username, h.config.App.JWTSecret)
+	accessToken, newRefreshToken, err := utils.GenerateTokenPair(claims.Username, claims.SessionID, newRefreshID, h.config.Get().App.JWTSecret)
 	if err != nil {
 		http.Error(w, "Failed to generate new tokens", http.StatusInternalServerError)
 		return
 	}
 
 	// Set new cookies
-	utils.SetTokenCookies(w, accessToken, newRefreshToken, h.config.App.Environment == "production")
+	utils.SetTokenCookies(w, accessToken, newRefreshToken, h.config.Get().App.Environment == "production")
 
 	// Generate new CSRF token
 	csrfToken := middleware.GenerateCSRFToken()
@@ -184,7 +388,7 @@ func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		Value:    csrfToken,
 		Path:     "/",
 		HttpOnly: false, // Needs to be accessible by JavaScript
-		Secure:   h.config.App.Environment == "production",
+		Secure:   h.config.Get().App.Environment == "production",
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   int((24 * time.Hour).Seconds()),
 	})
@@ -205,20 +409,46 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Settings stay the same for the whole upload, even across a reload
+	cfg := h.config.Get()
+
 	// Check upload key from both cookie and form value
 	uploadKeyCookie, cookieErr := r.Cookie("upload_key")
 	formKey := strings.TrimSpace(r.FormValue("key"))
-	expectedKey := strings.TrimSpace(strings.Trim(h.config.App.UploadKey, `"'`))
+	expectedKey := strings.TrimSpace(strings.Trim(cfg.App.UploadKey, `"'`))
 
 	// Validate either cookie or form key matches
-	validCookie := cookieErr == nil && uploadKeyCookie.Value == expectedKey
-	validFormKey := formKey == expectedKey
+	validCookie := cookieErr == nil &&
+		subtle.ConstantTimeCompare([]byte(uploadKeyCookie.Value), []byte(expectedKey)) == 1
+	validFormKey := subtle.ConstantTimeCompare([]byte(formKey), []byte(expectedKey)) == 1
 
+	// API tokens are accepted as a bearer token or in place of the upload key
+	validToken := false
 	if !validCookie && !validFormKey {
+		token := formKey
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = strings.TrimSpace(bearer)
+		}
+		name, ok, err := h.db.UseAPIToken(token)
+		if err != nil {
+			h.logger.Error("Failed to check API token", map[string]interface{}{
+				"error": err.Error(),
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			validToken = true
+			h.logger.Debug("Upload authenticated with API token", map[string]interface{}{
+				"token": name,
+			})
+		}
+	}
+
+	if !validCookie && !validFormKey && !validToken {
 		h.logger.Warn("Invalid upload key", map[string]interface{}{
-			"provided_form_key": formKey,
-			"has_cookie":        cookieErr == nil,
-			"expected_key":      expectedKey,
+			"has_form_key": formKey != "",
+			"has_cookie":   cookieErr == nil,
 		})
 		http.Error(w, "Invalid upload key", http.StatusUnauthorized)
 		return
@@ -245,7 +475,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Check file size
-	maxFileSize, err := h.config.GetMaxFileSize()
+	maxFileSize, err := cfg.GetMaxFileSize()
 	if err != nil {
 		h.logger.Error("Failed to parse max file size", map[string]interface{}{
 			"error": err.Error(),
@@ -265,7 +495,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check total storage size
-	maxStorage, err := h.config.GetMaxStorage()
+	maxStorage, err := cfg.GetMaxStorage()
 	if err != nil {
 		h.logger.Error("Failed to parse max storage size", map[string]interface{}{
 			"error": err.Error(),
@@ -275,20 +505,11 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate current storage usage
-	var currentStorageSize int64
-	err = filepath.Walk(h.config.Storage.BasePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			currentStorageSize += info.Size()
-		}
-		return nil
-	})
+	currentStorageSize, err := storage.Usage(cfg.Storage.BasePath)
 	if err != nil {
 		h.logger.Error("Failed to calculate storage usage", map[string]interface{}{
 			"error": err.Error(),
-			"path":  h.config.Storage.BasePath,
+			"path":  cfg.Storage.BasePath,
 		})
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -309,7 +530,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	// Check file extension
 	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(header.Filename), "."))
 	allowed := false
-	for _, allowedExt := range h.config.Storage.AllowedExtensions {
+	for _, allowedExt := range cfg.Storage.AllowedExtensions {
 		if ext == allowedExt {
 			allowed = true
 			break
@@ -323,14 +544,21 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": fmt.Sprintf("File type '.%s' not allowed. Allowed types: %s", ext, strings.Join(h.config.Storage.AllowedExtensions, ", ")),
+			"error": fmt.Sprintf("File type '.%s' not allowed. Allowed types: %s", ext, strings.Join(cfg.Storage.AllowedExtensions, ", ")),
 		})
 		return
 	}
 
+	// Verify and scan the content before anything is published
+	tmpPath, digest, ok := h.stageUpload(w, r, file, header.Filename, ext)
+	if !ok {
+		return
+	}
+	defer os.Remove(tmpPath)
+
 	// Check if filename already matches our UUID format
 	filename := strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
-	re := regexp.MustCompile(h.config.App.UUIDFormat)
+	re := regexp.MustCompile(cfg.App.UUIDFormat)
 	var uuid string
 
 	if re.MatchString(filename) {
@@ -348,7 +576,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 			uuid = filename
 		} else {
 			// Generate new UUID if this one exists
-			uuid, err = utils.GenerateFormattedUUID(h.config.App.UUIDFormat)
+			uuid, err = utils.GenerateFormattedUUID(cfg.App.UUIDFormat)
 			if err != nil {
 				h.logger.Error("Failed to generate UUID", map[string]interface{}{
 					"error": err.Error(),
@@ -359,7 +587,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Generate new UUID
-		uuid, err = utils.GenerateFormattedUUID(h.config.App.UUIDFormat)
+		uuid, err = utils.GenerateFormattedUUID(cfg.App.UUIDFormat)
 		if err != nil {
 			h.logger.Error("Failed to generate UUID", map[string]interface{}{
 				"error": err.Error(),
@@ -371,7 +599,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	now := time.Now()
 	path := filepath.Join(
-		h.config.Storage.BasePath,
+		cfg.Storage.BasePath,
 		fmt.Sprintf("%d", now.Year()),
 		fmt.Sprintf("%02d", now.Month()),
 		fmt.Sprintf("%02d", now.Day()),
@@ -388,27 +616,21 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create file
-	dst, err := os.Create(path)
-	if err != nil {
-		h.logger.Error("Failed to create file", map[string]interface{}{
-			"error": err.Error(),
-			"path":  path,
-		})
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	// Move the verified file into place, encrypting it when enabled
+	if h.keyring != nil && cfg.Encryption.Enabled {
+		err = h.keyring.EncryptFile(tmpPath, path)
+	} else {
+		err = os.Rename(tmpPath, path)
 	}
-	defer dst.Close()
-
-	// Copy file
-	if _, err := io.Copy(dst, file); err != nil {
-		h.logger.Error("Failed to copy file", map[string]interface{}{
+	if err != nil {
+		h.logger.Error("Failed to store file", map[string]interface{}{
 			"error": err.Error(),
 			"path":  path,
 		})
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	os.Chmod(path, 0644)
 
 	// Create image record
 	image := &models.Image{
@@ -418,7 +640,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		Size:       header.Size,
 		UploadedAt: now,
 		IsPrivate:  false,
-		PrivateKey: "",
+		SHA256:     digest,
 	}
 
 	if err := h.db.CreateImage(image); err != nil {
@@ -426,6 +648,8 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 			"error": err.Error(),
 			"uuid":  uuid,
 		})
+		// Do not leave a file without a record behind
+		os.Remove(path)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -439,7 +663,6 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		Size       int64  `json:"size"`
 		UploadedAt string `json:"uploadedAt"` // String format
 		IsPrivate  bool   `json:"isPrivate"`
-		PrivateKey string `json:"privateKey,omitempty"`
 		Views      int64  `json:"views"`
 		URL        string `json:"url"`
 		FullLink   string `json:"full_link,omitempty"` // Full URL including domain
@@ -454,12 +677,40 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		Size:       image.Size,
 		UploadedAt: image.UploadedAt.UTC().Format(time.RFC3339), // Format date
 		IsPrivate:  image.IsPrivate,
-		PrivateKey: image.PrivateKey,
 		Views:      image.Views, // Should be 0 initially
 		URL:        baseURL,
-		FullLink:   fmt.Sprintf("http://%s%s", h.config.App.Domain, baseURL),
+		FullLink:   fmt.Sprintf("http://%s%s", cfg.App.Domain, baseURL),
+	}
+
+	metrics.Uploads.Inc()
+	metrics.UploadBytes.Add(float64(image.Size))
+
+	// Warn once, when this upload pushes usage past the quota warning threshold
+	if maxStorage != -1 && cfg.Storage.QuotaWarning > 0 {
+		threshold := maxStorage * int64(cfg.Storage.QuotaWarning) / 100
+		if currentStorageSize < threshold && currentStorageSize+image.Size >= threshold {
+			h.logger.Warn("Storage quota warning threshold reached", map[string]interface{}{
+				"used":        currentStorageSize + image.Size,
+				"max_storage": maxStorage,
+				"threshold":   cfg.Storage.QuotaWarning,
+			})
+			h.events.Publish(events.TypeQuota, map[string]interface{}{
+				"used":       currentStorageSize + image.Size,
+				"max":        maxStorage,
+				"percentage": float64(currentStorageSize+image.Size) / float64(maxStorage) * 100,
+			})
+		}
 	}
 
+	h.events.Publish(events.TypeUpload, map[string]interface{}{
+		"id":         image.ID,
+		"uuid":       image.UUID,
+		"filename":   image.Filename,
+		"extension":  image.Extension,
+		"size":       image.Size,
+		"uploadedAt": responseImage.UploadedAt,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(responseImage)
 }
@@ -491,7 +742,7 @@ func (h *Handler) ServeProxyImage(w http.ResponseWriter, r *http.Request) {
 
 	// Get file path
 	filePath := filepath.Join(
-		h.config.Storage.BasePath,
+		h.config.Get().Storage.BasePath,
 		fmt.Sprintf("%d", image.UploadedAt.Year()),
 		fmt.Sprintf("%02d", image.UploadedAt.Month()),
 		fmt.Sprintf("%02d", image.UploadedAt.Day()),
@@ -517,6 +768,7 @@ func (h *Handler) ServeProxyImage(w http.ResponseWriter, r *http.Request) {
 		contentType = "image/jpeg"
 	}
 	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 
 	// Set cache control headers
 	w.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
@@ -524,7 +776,32 @@ func (h *Handler) ServeProxyImage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Expires", "0")
 
 	// Serve file
-	http.ServeFile(w, r, filePath)
+	h.serveStoredFile(w, r, filePath)
+}
+
+// serveStoredFile serves an uploaded file, decrypting it when it is stored
+// encrypted. Range requests work either way. It returns false after writing
+// an error response.
+func (h *Handler) serveStoredFile(w http.ResponseWriter, r *http.Request, filePath string) bool {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return false
+	}
+
+	file, err := encryption.Open(filePath, h.keyring)
+	if err != nil {
+		h.logger.Error("Failed to open stored file", map[string]interface{}{
+			"error": err.Error(),
+			"path":  filePath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	defer file.Close()
+
+	http.ServeContent(w, r, filepath.Base(filePath), info.ModTime(), file)
+	return true
 }
 
 func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
@@ -555,34 +832,15 @@ func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Handle private image access
-	if image.IsPrivate {
-		key := r.URL.Query().Get("key")
-		if key == "" {
-			// No key provided for private image
-			h.serveStaticFile(w, "404.html")
-			return
-		}
-
-		// Decode base64 key
-		decodedKey, err := base64.StdEncoding.DecodeString(key)
-		if err != nil {
-			// Invalid base64 key
-			h.serveStaticFile(w, "404.html")
-			return
-		}
-
-		// Compare keys
-		if string(decodedKey) != image.PrivateKey {
-			// Key doesn't match
-			h.serveStaticFile(w, "404.html")
-			return
-		}
+	// Private images need a signed link or an unlock cookie
+	if image.IsPrivate && !h.canViewPrivate(r, image) {
+		h.serveUnlockPage(w, image, http.StatusUnauthorized, "")
+		return
 	}
 
 	// Get file path
 	filePath := filepath.Join(
-		h.config.Storage.BasePath,
+		h.config.Get().Storage.BasePath,
 		fmt.Sprintf("%d", image.UploadedAt.Year()),
 		fmt.Sprintf("%02d", image.UploadedAt.Month()),
 		fmt.Sprintf("%02d", image.UploadedAt.Day()),
@@ -608,6 +866,7 @@ func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 		contentType = "image/jpeg"
 	}
 	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 
 	// Set cache control headers
 	if image.IsPrivate {
@@ -617,19 +876,30 @@ func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Serve file
-	http.ServeFile(w, r, filePath)
+	if !h.serveStoredFile(w, r, filePath) {
+		return
+	}
 
 	// Record view if not from admin interface
 	referer := r.Header.Get("Referer")
 	if referer == "" || (!strings.Contains(referer, "/admin") && !strings.Contains(referer, "/images")) {
+		// Determine IP value based on tracking and anonymization settings
+		var ip string
+		if h.config.Get().App.EnableIPTracking {
+			ip = utils.AnonymizeIP(utils.GetIPFromAddr(r), h.config.Get().Analytics.IPAnonymization, h.config.Get().Analytics.HashKey)
+		} else {
+			ip = "IP Tracking disabled"
+		}
+
 		var country string
-		if h.config.App.EnableIPTracking {
+		if h.config.Get().App.EnableIPTracking {
 			// Get IP info
-			ipInfo, err := utils.GetIPInfo(r, h.config.App.IPInfoToken)
+			ipInfo, err := utils.GetIPInfo(r, h.config.Get().App.IPInfoToken)
 			if err != nil {
+				metrics.GeoIPFailures.Inc()
 				h.logger.Error("Failed to get IP info", map[string]interface{}{
 					"error": err.Error(),
-					"ip":    utils.GetIPFromAddr(r),
+					"ip":    ip,
 				})
 				country = "Unknown"
 			} else {
@@ -639,22 +909,24 @@ func (h *Handler) ServeImage(w http.ResponseWriter, r *http.Request) {
 			country = "Unknown"
 		}
 
-		// Determine IP value based on tracking setting
-		var ip string
-		if h.config.App.EnableIPTracking {
-			ip = utils.GetIPFromAddr(r)
-		} else {
-			ip = "IP Tracking disabled"
-		}
-
 		// Record view with country information
 		if err := h.db.AddImageView(image.ID, ip, country, r.UserAgent()); err != nil {
 			h.logger.Error("Failed to record view", map[string]interface{}{
 				"error":    err.Error(),
 				"image_id": image.ID,
-				"ip":       utils.GetIPFromAddr(r),
+				"ip":       ip,
 			})
+			return
 		}
+
+		h.events.Publish(events.TypeView, map[string]interface{}{
+			"imageId":   image.ID,
+			"imageUuid": image.UUID,
+			"ip":        ip,
+			"country":   country,
+			"userAgent": r.UserAgent(),
+			"viewedAt":  time.Now().UTC().Format(time.RFC3339),
+		})
 	}
 }
 
@@ -690,15 +962,16 @@ func (h *Handler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 
 	// Get file path
 	filePath := filepath.Join(
-		h.config.Storage.BasePath,
+		h.config.Get().Storage.BasePath,
 		fmt.Sprintf("%d", image.UploadedAt.Year()),
 		fmt.Sprintf("%02d", image.UploadedAt.Month()),
 		fmt.Sprintf("%02d", image.UploadedAt.Day()),
 		fmt.Sprintf("%s.%s", image.UUID, image.Extension),
 	)
 
-	// Delete file
-	if err := os.Remove(filePath); err != nil {
+	// Delete file, a file that is already gone should not keep the record
+	// around
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		h.logger.Error("Failed to delete file", map[string]interface{}{
 			"error": err.Error(),
 			"path":  filePath,
@@ -718,6 +991,18 @@ func (h *Handler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.events.Publish(events.TypeDelete, map[string]interface{}{
+		"id":   image.ID,
+		"uuid": image.UUID,
+	})
+	h.audit(r, "", models.AuditImageDelete, "image", image.UUID, map[string]interface{}{
+		"id":        image.ID,
+		"filename":  image.Filename,
+		"extension": image.Extension,
+		"size":      image.Size,
+		"isPrivate": image.IsPrivate,
+	}, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -816,7 +1101,7 @@ func (h *Handler) ServeFrontend(w http.ResponseWriter, r *http.Request) {
 			Header:     r.Header,
 			RemoteAddr: r.RemoteAddr,
 		}
-		h.ServeImage(w, imageReq)
+		h.ServeImage(w, imageReq.WithContext(r.Context()))
 		return
 	}
 
@@ -936,9 +1221,9 @@ func (h *Handler) ListImages(w http.ResponseWriter, r *http.Request) {
 		Size       int64  `json:"size"`
 		UploadedAt string `json:"uploadedAt"`
 		IsPrivate  bool   `json:"isPrivate"`
-		PrivateKey string `json:"privateKey,omitempty"`
 		Views      int64  `json:"views"`
 		URL        string `json:"url"`
+		Missing    bool   `json:"missing"`
 	}
 
 	imagesWithURL := make([]ImageWithURL, len(images))
@@ -946,14 +1231,8 @@ func (h *Handler) ListImages(w http.ResponseWriter, r *http.Request) {
 		// Format the date to ISO 8601 without timezone
 		formattedDate := img.UploadedAt.Format("2006-01-02T15:04:05")
 
-		// Create base URL
-		baseURL := fmt.Sprintf("/%s.%s", img.UUID, img.Extension)
-
-		// Add private key to URL if image is private
-		url := baseURL
-		if img.IsPrivate && img.PrivateKey != "" {
-			url = fmt.Sprintf("%s?key=%s", baseURL, base64.StdEncoding.EncodeToString([]byte(img.PrivateKey)))
-		}
+		// Private images get a signed link
+		url := h.imageURL(&img)
 
 		imagesWithURL[i] = ImageWithURL{
 			ID:         img.ID,
@@ -963,9 +1242,9 @@ func (h *Handler) ListImages(w http.ResponseWriter, r *http.Request) {
 			Size:       img.Size,
 			UploadedAt: formattedDate,
 			IsPrivate:  img.IsPrivate,
-			PrivateKey: img.PrivateKey,
 			Views:      img.Views,
 			URL:        url,
+			Missing:    img.Missing,
 		}
 	}
 
@@ -1014,6 +1293,18 @@ func (h *Handler) GetImageStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Raw views are purged after the retention period, the counts per day
+	// and country include the aggregates that replace them
+	aggregates, err := h.db.ImageViewAggregates(image.ID)
+	if err != nil {
+		h.logger.Error("Failed to get image view aggregates", map[string]interface{}{
+			"error": err.Error(),
+			"uuid":  image.UUID,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Define temporary structs for JSON response with string dates
 	type jsonImageView struct {
 		ID          int64  `json:"id"`
@@ -1024,6 +1315,12 @@ func (h *Handler) GetImageStats(w http.ResponseWriter, r *http.Request) {
 		UserAgent   string `json:"user_agent"`
 		ViewedAt    string `json:"viewed_at"`
 	}
+	type jsonViewAggregate struct {
+		Date        string `json:"date"`
+		Country     string `json:"country_name"`
+		CountryCode string `json:"country_code"`
+		Views       int64  `json:"views"`
+	}
 	type jsonImage struct {
 		ID         int64  `json:"id"`
 		UUID       string `json:"uuid"`
@@ -1032,14 +1329,10 @@ func (h *Handler) GetImageStats(w http.ResponseWriter, r *http.Request) {
 		Size       int64  `json:"size"`
 		UploadedAt string `json:"uploadedAt"`
 		IsPrivate  bool   `json:"isPrivate"`
-		PrivateKey string `json:"privateKey,omitempty"`
 		Views      int64  `json:"views"`
 	}
 
-	// Format views with string dates
-	formattedViews := make([]jsonImageView, len(viewsData))
-	for i, v := range viewsData {
-		countryCode := v.Country
+	country := func(countryCode string) (string, string) {
 		countryName := "Unknown"
 
 		// Convert country code to country name using CountryCodeMap
@@ -1055,18 +1348,35 @@ func (h *Handler) GetImageStats(w http.ResponseWriter, r *http.Request) {
 			countryCode = "unknown"
 			countryName = "Unknown"
 		}
+		return countryName, strings.ToUpper(countryCode)
+	}
 
+	// Format views with string dates
+	formattedViews := make([]jsonImageView, len(viewsData))
+	for i, v := range viewsData {
+		countryName, countryCode := country(v.Country)
 		formattedViews[i] = jsonImageView{
 			ID:          v.ID,
 			ImageID:     v.ImageID,
 			IP:          v.IP,
 			Country:     countryName,
-			CountryCode: strings.ToUpper(countryCode),
+			CountryCode: countryCode,
 			UserAgent:   v.UserAgent,
 			ViewedAt:    v.ViewedAt.UTC().Format(time.RFC3339),
 		}
 	}
 
+	formattedAggregates := make([]jsonViewAggregate, len(aggregates))
+	for i, a := range aggregates {
+		countryName, countryCode := country(a.Country)
+		formattedAggregates[i] = jsonViewAggregate{
+			Date:        a.Date,
+			Country:     countryName,
+			CountryCode: countryCode,
+			Views:       a.Views,
+		}
+	}
+
 	// Prepare image data with string date
 	formattedImage := jsonImage{
 		ID:         image.ID,
@@ -1076,15 +1386,15 @@ func (h *Handler) GetImageStats(w http.ResponseWriter, r *http.Request) {
 		Size:       image.Size,
 		UploadedAt: image.UploadedAt.UTC().Format(time.RFC3339),
 		IsPrivate:  image.IsPrivate,
-		PrivateKey: image.PrivateKey,
 		Views:      image.Views,
 	}
 
 	// Encode the response with formatted data
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"image": formattedImage,
-		"views": formattedViews,
+		"image":        formattedImage,
+		"views":        formattedViews,
+		"views_by_day": formattedAggregates,
 	})
 }
 
@@ -1127,6 +1437,7 @@ func (h *Handler) TogglePrivacy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update privacy status
+	wasPrivate := image.IsPrivate
 	image.IsPrivate = req.IsPrivate
 
 	// Handle private key
@@ -1135,10 +1446,18 @@ func (h *Handler) TogglePrivacy(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Password is required for private images", http.StatusBadRequest)
 			return
 		}
-		// Store the password as the private key
-		image.PrivateKey = req.Password
+		hash, err := utils.HashPassword(req.Password)
+		if err != nil {
+			h.logger.Error("Failed to hash image password", map[string]interface{}{
+				"error": err.Error(),
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// A new hash also revokes links and unlock cookies issued for the old password
+		image.PasswordHash = hash
 	} else {
-		image.PrivateKey = ""
+		image.PasswordHash = ""
 	}
 
 	// Update in database
@@ -1148,6 +1467,16 @@ func (h *Handler) TogglePrivacy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.events.Publish(events.TypePrivacy, map[string]interface{}{
+		"id":        image.ID,
+		"uuid":      image.UUID,
+		"isPrivate": image.IsPrivate,
+	})
+	// The password itself is never recorded
+	h.audit(r, "", models.AuditImagePrivacy, "image", image.UUID,
+		map[string]interface{}{"isPrivate": wasPrivate},
+		map[string]interface{}{"isPrivate": image.IsPrivate})
+
 	// Return updated image with proper JSON formatting
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1158,9 +1487,8 @@ func (h *Handler) TogglePrivacy(w http.ResponseWriter, r *http.Request) {
 		"size":       image.Size,
 		"uploadedAt": image.UploadedAt.UTC().Format(time.RFC3339),
 		"isPrivate":  image.IsPrivate,
-		"privateKey": image.PrivateKey,
 		"views":      image.Views,
-		"url":        fmt.Sprintf("/%s.%s", image.UUID, image.Extension),
+		"url":        h.imageURL(image),
 	})
 }
 
@@ -1177,34 +1505,9 @@ func (h *Handler) VerifyToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate token
-	claims, err := utils.ValidateToken(accessToken, h.config.App.JWTSecret)
+	// Validate token, expired tokens are renewed through /api/refresh
+	claims, err := utils.ValidateToken(accessToken, h.config.Get().App.JWTSecret)
 	if err != nil {
-		if refreshToken := utils.GetTokenFromCookie(r, "refresh_token"); refreshToken != "" {
-			// Try to refresh the token
-			refreshClaims, err := utils.ValidateToken(refreshToken, h.config.App.JWTSecret)
-			if err != nil || refreshClaims.TokenType != utils.RefreshToken {
-				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
-				return
-			}
-
-			// Generate new token pair
-			newAccessToken, newRefreshToken, err := utils.GenerateTokenPair(refreshClaims.Username, h.config.App.JWTSecret)
-			if err != nil {
-				http.Error(w, "Failed to generate new tokens", http.StatusInternalServerError)
-				return
-			}
-
-			// Set new cookies
-			utils.SetTokenCookies(w, newAccessToken, newRefreshToken, h.config.App.Environment == "production")
-
-			// Return the username
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"username": refreshClaims.Username,
-			})
-			return
-		}
 		http.Error(w, "Invalid access token", http.StatusUnauthorized)
 		return
 	}
@@ -1214,6 +1517,20 @@ func (h *Handler) VerifyToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	active, err := h.db.IsSessionActive(claims.SessionID)
+	if err != nil {
+		h.logger.Error("Failed to check session", map[string]interface{}{
+			"error":    err.Error(),
+			"username": claims.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+		return
+	}
+
 	// Return the username in the response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -1231,20 +1548,11 @@ func (h *Handler) GetDiskUsage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Calculate application storage usage
-	var appStorageSize int64
-	err := filepath.Walk(h.config.Storage.BasePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			appStorageSize += info.Size()
-		}
-		return nil
-	})
+	appStorageSize, err := storage.Usage(h.config.Get().Storage.BasePath)
 	if err != nil {
 		h.logger.Error("Failed to calculate application storage usage", map[string]interface{}{
 			"error": err.Error(),
-			"path":  h.config.Storage.BasePath,
+			"path":  h.config.Get().Storage.BasePath,
 		})
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Internal server error",
@@ -1256,13 +1564,13 @@ func (h *Handler) GetDiskUsage(w http.ResponseWriter, r *http.Request) {
 	const GB = 1024 * 1024 * 1024
 	appStorageGB := float64(appStorageSize) / GB
 
-	if h.config.IsFullStorageAllowed() {
+	if h.config.Get().IsFullStorageAllowed() {
 		// If FULL storage is enabled, show system disk usage
 		var total, free uint64
-		if err := utils.GetDiskUsage(h.config.Storage.BasePath, &total, &free); err != nil {
+		if err := utils.GetDiskUsage(h.config.Get().Storage.BasePath, &total, &free); err != nil {
 			h.logger.Error("Failed to get disk usage", map[string]interface{}{
 				"error": err.Error(),
-				"path":  h.config.Storage.BasePath,
+				"path":  h.config.Get().Storage.BasePath,
 			})
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"error": "Internal server error",
@@ -1282,7 +1590,7 @@ func (h *Handler) GetDiskUsage(w http.ResponseWriter, r *http.Request) {
 		})
 	} else {
 		// If specific storage limit is set, show usage against that limit
-		maxStorage, err := h.config.GetMaxStorage()
+		maxStorage, err := h.config.Get().GetMaxStorage()
 		if err != nil {
 			h.logger.Error("Failed to parse max storage size", map[string]interface{}{
 				"error": err.Error(),
@@ -1402,14 +1710,8 @@ func (h *Handler) GetImageById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create base URL
-	baseURL := fmt.Sprintf("/%s.%s", image.UUID, image.Extension)
-
-	// Add private key to URL if image is private
-	url := baseURL
-	if image.IsPrivate && image.PrivateKey != "" {
-		url = fmt.Sprintf("%s?key=%s", baseURL, base64.StdEncoding.EncodeToString([]byte(image.PrivateKey)))
-	}
+	// Private images get a signed link
+	url := h.imageURL(image)
 
 	// Format the date
 	formattedDate := image.UploadedAt.Format("2006-01-02T15:04:05")
@@ -1423,7 +1725,6 @@ func (h *Handler) GetImageById(w http.ResponseWriter, r *http.Request) {
 		"size":       image.Size,
 		"uploadedAt": formattedDate,
 		"isPrivate":  image.IsPrivate,
-		"privateKey": image.PrivateKey,
 		"views":      image.Views,
 		"url":        url,
 	})
@@ -1443,10 +1744,12 @@ func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg := h.config.Get()
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"enable_ip_tracking": h.config.App.EnableIPTracking,
-		"max_file_size":      h.config.App.MaxFileSize,
+		"enable_ip_tracking": cfg.App.EnableIPTracking,
+		"max_file_size":      cfg.App.MaxFileSize,
 	})
 }
 
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/storage"
+)
+
+// loginAllowed reserves the login attempt for the account and IP, waiting for
+// any attempt already being verified, and checks the throttle. It writes a
+// 429 response with Retry-After and returns false when the account or IP is
+// currently throttled. Otherwise the caller must call release once the
+// attempt's outcome is recorded.
+func (h *Handler) loginAllowed(w http.ResponseWriter, r *http.Request, username, ip string) (func(), bool) {
+	release, err := h.guard.Acquire(r.Context(), username, ip)
+	if err != nil {
+		// The client went away while waiting
+		http.Error(w, "Request canceled", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	wait, err := h.guard.Check(username, ip)
+	if err != nil {
+		release()
+		h.logger.Error("Failed to check login throttle", map[string]interface{}{
+			"error":    err.Error(),
+			"username": username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if wait <= 0 {
+		return release, true
+	}
+	release()
+
+	h.logger.Warn("Throttled login attempt", map[string]interface{}{
+		"username":    username,
+		"ip":          ip,
+		"retry_after": wait.Round(time.Second).String(),
+	})
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+	return nil, false
+}
+
+// loginFailed records a failed login attempt in the throttle and the audit log
+func (h *Handler) loginFailed(r *http.Request, username, ip, reason string) {
+	h.audit(r, username, models.AuditLoginFailed, "user", username, nil, map[string]interface{}{
+		"reason": reason,
+	})
+	if err := h.guard.Fail(username, ip); err != nil {
+		h.logger.Error("Failed to record login failure", map[string]interface{}{
+			"error":    err.Error(),
+			"username": username,
+		})
+	}
+}
+
+// Lockouts lists accounts and IPs with recent failed logins on GET and
+// unlocks one on DELETE with ?username= or ?ip=
+func (h *Handler) Lockouts(w http.ResponseWriter, r *http.Request) {
+	admin := h.requireAdmin(w, r)
+	if admin == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		throttles, err := h.db.ListLoginThrottles(time.Duration(h.config.Get().LoginProtection.Window) * time.Minute)
+		if err != nil {
+			h.logger.Error("Failed to list login throttles", map[string]interface{}{
+				"error": err.Error(),
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lockouts": throttles,
+		})
+	case http.MethodDelete:
+		kind, key := storage.ThrottleAccount, r.URL.Query().Get("username")
+		if key == "" {
+			kind, key = storage.ThrottleIP, r.URL.Query().Get("ip")
+		}
+		if key == "" {
+			http.Error(w, "username or ip is required", http.StatusBadRequest)
+			return
+		}
+
+		unlocked, err := h.guard.Unlock(kind, key)
+		if err != nil {
+			h.logger.Error("Failed to unlock login", map[string]interface{}{
+				"error": err.Error(),
+				"kind":  kind,
+				"key":   key,
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !unlocked {
+			http.Error(w, "No failed logins recorded", http.StatusNotFound)
+			return
+		}
+
+		h.logger.Info("Login unlocked", map[string]interface{}{
+			"kind": kind,
+			"key":  key,
+			"by":   admin.Username,
+		})
+		h.audit(r, admin.Username, models.AuditLockoutClear, kind, key, nil, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
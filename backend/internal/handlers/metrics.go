@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/metrics"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// storageUsageTTL is how long the storage gauge reuses a measured usage, so
+// frequent scrapes do not walk a large library over and over
+const storageUsageTTL = 5 * time.Minute
+
+// usageCache remembers the storage usage last measured for the metrics
+type usageCache struct {
+	mu       sync.Mutex
+	basePath string
+	used     int64
+	measured time.Time
+}
+
+// get returns the usage of basePath, measuring it again once storageUsageTTL
+// has passed. Concurrent scrapes wait for a single walk.
+func (c *usageCache) get(basePath string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.basePath == basePath && time.Since(c.measured) < storageUsageTTL {
+		return c.used, nil
+	}
+	used, err := storage.Usage(basePath)
+	if err != nil {
+		return 0, err
+	}
+	c.basePath, c.used, c.measured = basePath, used, time.Now()
+	return used, nil
+}
+
+// Metrics serves Prometheus metrics to scrapers holding the configured token
+// or connecting from an allowed IP
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	cfg := h.config.Get()
+	if !cfg.Metrics.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		h.serveStaticFile(w, "404.html")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !metricsAccessAllowed(cfg, r) {
+		h.logger.Warn("Rejected metrics scrape", map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Storage usage is measured at most every storageUsageTTL
+	if used, err := h.storageUsage.get(cfg.Storage.BasePath); err != nil {
+		h.logger.Error("Failed to calculate storage usage for metrics", map[string]interface{}{
+			"error": err.Error(),
+			"path":  cfg.Storage.BasePath,
+		})
+	} else {
+		metrics.StorageUsedBytes.Set(float64(used))
+	}
+	if maxStorage, err := cfg.GetMaxStorage(); err == nil {
+		metrics.StorageMaxBytes.Set(float64(maxStorage))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Default.Write(w)
+}
+
+// metricsAccessAllowed checks the bearer token and the IP allowlist
+func metricsAccessAllowed(cfg *config.Config, r *http.Request) bool {
+	if token := cfg.Metrics.Token; token != "" {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	// Forwarded headers only count when they come from a trusted proxy
+	ip := net.ParseIP(utils.ClientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, entry := range cfg.Metrics.AllowedIPs {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
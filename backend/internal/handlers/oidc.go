@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sharex/internal/models"
+	"sharex/internal/oidc"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// errSSODenied marks identities the configuration does not allow to sign in
+var errSSODenied = errors.New("not allowed to sign in")
+
+// OIDCLogin starts the single sign-on flow by redirecting to the identity provider
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.sso == nil {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		h.logger.Error("Failed to generate login state", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.sso.AuthCodeURL(r.Context(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		h.logger.Error("Failed to reach identity provider", map[string]interface{}{
+			"error":  err.Error(),
+			"issuer": h.config.Get().OIDC.Issuer,
+		})
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	signed, err := state.Sign(h.config.Get().App.JWTSecret)
+	if err != nil {
+		h.logger.Error("Failed to sign login state", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Lax so the cookie is sent on the top-level redirect back from the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    signed,
+		Path:     "/api/oidc/",
+		HttpOnly: true,
+		Secure:   h.config.Get().App.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidc.StateTTL.Seconds()),
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the single sign-on flow, provisions the user and
+// issues the usual session cookies
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.sso == nil {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/oidc/",
+		HttpOnly: true,
+		Secure:   h.config.Get().App.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		h.logger.Warn("Identity provider returned an error", map[string]interface{}{
+			"error":       errCode,
+			"description": query.Get("error_description"),
+		})
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	state, err := oidc.ParseLoginState(cookie.Value, query.Get("state"), h.config.Get().App.JWTSecret)
+	if err != nil {
+		h.logger.Warn("Invalid single sign-on state", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	rawIDToken, err := h.sso.Exchange(r.Context(), query.Get("code"), state.Verifier)
+	if err != nil {
+		h.logger.Error("Failed to exchange authorization code", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Single sign-on failed", http.StatusBadGateway)
+		return
+	}
+
+	idToken, err := h.sso.VerifyIDToken(r.Context(), rawIDToken, state.Nonce)
+	if err != nil {
+		h.logger.Warn("Rejected ID token", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.provisionOIDCUser(r, idToken)
+	if errors.Is(err, errSSODenied) {
+		h.logger.Warn("Single sign-on denied", map[string]interface{}{
+			"error":   err.Error(),
+			"subject": idToken.Subject,
+			"email":   idToken.Email,
+		})
+		http.Error(w, "You are not allowed to sign in", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to provision user", map[string]interface{}{
+			"error":   err.Error(),
+			"subject": idToken.Subject,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.startSession(w, r, user); err != nil {
+		h.logger.Error("Failed to generate tokens", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("User logged in with single sign-on", map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+	})
+	h.audit(r, user.Username, models.AuditLogin, "user", user.Username, nil, map[string]interface{}{
+		"provider": models.AuthProviderOIDC,
+	})
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// provisionOIDCUser checks the identity against the allowed domains and
+// groups, then finds, links or creates the matching user and syncs its role
+func (h *Handler) provisionOIDCUser(r *http.Request, idToken *oidc.IDToken) (*models.User, error) {
+	cfg := h.config.Get().OIDC
+
+	if len(cfg.AllowedDomains) > 0 {
+		at := strings.LastIndex(idToken.Email, "@")
+		if at < 0 || !containsFold(cfg.AllowedDomains, idToken.Email[at+1:]) {
+			return nil, fmt.Errorf("%w: email domain not allowed", errSSODenied)
+		}
+	}
+
+	groups := idToken.Strings(cfg.GroupsClaim)
+	if len(cfg.AllowedGroups) > 0 {
+		allowed := false
+		for _, group := range groups {
+			if containsFold(cfg.AllowedGroups, group) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: not in an allowed group", errSSODenied)
+		}
+	}
+
+	role := cfg.DefaultRole
+	for _, value := range idToken.Strings(cfg.RoleClaim) {
+		if mapped, ok := cfg.RoleMapping[value]; ok {
+			role = mapped
+			if role == models.RoleAdmin {
+				break
+			}
+		}
+	}
+
+	user, err := h.db.GetUserByOIDCSubject(idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		username := idToken.Email
+		if username == "" {
+			username, _ = idToken.Claims["preferred_username"].(string)
+		}
+		if username == "" {
+			return nil, fmt.Errorf("%w: identity has no verified email or username", errSSODenied)
+		}
+
+		existing, err := h.db.GetUser(username)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case existing == nil:
+			user, err = h.db.CreateOIDCUser(username, idToken.Subject, role)
+			if err != nil {
+				return nil, err
+			}
+			h.logger.Info("Provisioned single sign-on user", map[string]interface{}{
+				"username": username,
+				"role":     role,
+			})
+			h.audit(r, username, models.AuditUserCreate, "user", username, nil, map[string]interface{}{
+				"role":          role,
+				"auth_provider": models.AuthProviderOIDC,
+			})
+			return user, nil
+		case existing.OIDCSubject != "":
+			return nil, fmt.Errorf("%w: username is already linked to another identity", errSSODenied)
+		case !cfg.LinkLocalAccounts || idToken.Email == "":
+			return nil, fmt.Errorf("%w: a local account already has this username", errSSODenied)
+		default:
+			// A local account named after the verified email is linked to the
+			// identity when the configuration allows it
+			if err := h.db.LinkOIDCSubject(existing.ID, idToken.Subject); err != nil {
+				return nil, err
+			}
+			h.logger.Info("Linked user to single sign-on identity", map[string]interface{}{
+				"username": existing.Username,
+			})
+			h.audit(r, existing.Username, models.AuditUserUpdate, "user", existing.Username,
+				map[string]interface{}{"oidc_linked": false},
+				map[string]interface{}{"oidc_linked": true})
+			user = existing
+		}
+	}
+
+	if user.Disabled {
+		return nil, fmt.Errorf("%w: account is disabled", errSSODenied)
+	}
+
+	// The provider is the source of truth for roles, except for local
+	// admins linked to an identity, who keep their role
+	localAdmin := user.AuthProvider == models.AuthProviderLocal && user.IsAdmin()
+	if user.Role != role && !localAdmin {
+		if err := h.db.SetUserRole(user.ID, role); err != nil {
+			return nil, err
+		}
+		h.logger.Info("Updated user role from identity provider", map[string]interface{}{
+			"username": user.Username,
+			"from":     user.Role,
+			"to":       role,
+		})
+		h.audit(r, user.Username, models.AuditUserUpdate, "user", user.Username,
+			map[string]interface{}{"role": user.Role},
+			map[string]interface{}{"role": role})
+		user.Role = role
+	}
+
+	return user, nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/models"
+	"sharex/internal/oidc"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "sharex-test"
+	testKeyID    = "test-key"
+)
+
+// fakeIdP is a stand-in OpenID Connect provider serving discovery, JWKS and
+// token endpoints. It enforces PKCE like a real provider and signs ID tokens
+// with the claims of the identity the test logs in as.
+type fakeIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what the provider remembers about an issued code
+type authorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{t: t, key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the user signing in at the provider: it issues a code for
+// the authorization request the application redirected to
+func (idp *fakeIdP) authorize(authURL string, claims jwt.MapClaims) url.Values {
+	idp.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.codes[code] = authorization{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		claims:    claims,
+	}
+	idp.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	if !ok || r.PostForm.Get("client_id") != testClientID ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != auth.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": auth.nonce,
+	}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Errorf("sign id token: %v", err)
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+	})
+}
+
+// newOIDCTestHandler returns a handler with single sign-on pointed at the
+// stand-in provider and a new database
+func newOIDCTestHandler(t *testing.T, idp *fakeIdP, configure func(*config.Config)) (*Handler, *storage.DB) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.App.JWTSecret = "test-secret"
+	cfg.OIDC.Enabled = true
+	cfg.OIDC.Issuer = idp.server.URL
+	cfg.OIDC.ClientID = testClientID
+	cfg.OIDC.RedirectURL = "http://sharex.test/api/oidc/callback"
+	cfg.OIDC.Scopes = []string{"openid", "email", "profile"}
+	cfg.OIDC.GroupsClaim = "groups"
+	cfg.OIDC.RoleClaim = "groups"
+	cfg.OIDC.DefaultRole = models.RoleUser
+	cfg.LoginProtection.Window = 15
+	if configure != nil {
+		configure(cfg)
+	}
+
+	db, err := storage.NewDB("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	logger, err := utils.NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(config.NewStore(cfg), db, logger, nil, nil, nil, nil, nil), db
+}
+
+// startLogin calls OIDCLogin and returns the authorization URL and the state
+// cookie the browser would carry back
+func startLogin(t *testing.T, h *Handler) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("OIDCLogin status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return rec.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("OIDCLogin set no state cookie")
+	return "", nil
+}
+
+// callback calls OIDCCallback like the browser returning from the provider
+func callback(h *Handler, params url.Values, state *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+params.Encode(), nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	rec := httptest.NewRecorder()
+	h.OIDCCallback(rec, req)
+	return rec
+}
+
+func TestOIDCCallback(t *testing.T) {
+	alice := jwt.MapClaims{
+		"sub":            "alice-subject",
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+		"groups":         []string{"staff"},
+	}
+	with := func(claims jwt.MapClaims, name string, value interface{}) jwt.MapClaims {
+		copied := jwt.MapClaims{}
+		for k, v := range claims {
+			copied[k] = v
+		}
+		copied[name] = value
+		return copied
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		claims    jwt.MapClaims
+		status    int
+		wantRole  string // role of the provisioned user when the login succeeds
+	}{
+		{
+			name:     "default role",
+			claims:   alice,
+			status:   http.StatusFound,
+			wantRole: models.RoleUser,
+		},
+		{
+			name: "mapped role",
+			configure: func(c *config.Config) {
+				c.OIDC.RoleMapping = map[string]string{"sharex-admins": models.RoleAdmin}
+			},
+			claims:   with(alice, "groups", []string{"staff", "sharex-admins"}),
+			status:   http.StatusFound,
+			wantRole: models.RoleAdmin,
+		},
+		{
+			name: "nested role claim",
+			configure: func(c *config.Config) {
+				c.OIDC.RoleClaim = "realm_access.roles"
+				c.OIDC.RoleMapping = map[string]string{"admin": models.RoleAdmin}
+			},
+			claims:   with(alice, "realm_access", map[string]interface{}{"roles": []string{"admin"}}),
+			status:   http.StatusFound,
+			wantRole: models.RoleAdmin,
+		},
+		{
+			name: "allowed domain",
+			configure: func(c *config.Config) {
+				c.OIDC.AllowedDomains = []string{"example.com"}
+			},
+			claims:   alice,
+			status:   http.StatusFound,
+			wantRole: models.RoleUser,
+		},
+		{
+			name: "domain not allowed",
+			configure: func(c *config.Config) {
+				c.OIDC.AllowedDomains = []string{"example.org"}
+			},
+			claims: alice,
+			status: http.StatusForbidden,
+		},
+		{
+			name: "unverified email does not pass the domain check",
+			configure: func(c *config.Config) {
+				c.OIDC.AllowedDomains = []string{"example.com"}
+			},
+			claims: with(alice, "email_verified", false),
+			status: http.StatusForbidden,
+		},
+		{
+			name: "allowed group",
+			configure: func(c *config.Config) {
+				c.OIDC.AllowedGroups = []string{"Staff"}
+			},
+			claims:   alice,
+			status:   http.StatusFound,
+			wantRole: models.RoleUser,
+		},
+		{
+			name: "group not allowed",
+			configure: func(c *config.Config) {
+				c.OIDC.AllowedGroups = []string{"sharex-users"}
+			},
+			claims: alice,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unverified email without username",
+			claims: with(alice, "email_verified", "false"),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "wrong audience",
+			claims: with(alice, "aud", "another-client"),
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	idp := newFakeIdP(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newOIDCTestHandler(t, idp, tt.configure)
+
+			authURL, state := startLogin(t, h)
+			rec := callback(h, idp.authorize(authURL, tt.claims), state)
+			if rec.Code != tt.status {
+				t.Fatalf("OIDCCallback status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			user, err := db.GetUserByOIDCSubject("alice-subject")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantRole == "" {
+				if user != nil {
+					t.Errorf("rejected login provisioned user %q", user.Username)
+				}
+				return
+			}
+			if user == nil {
+				t.Fatal("no user was provisioned")
+			}
+			if user.Username != "alice@example.com" || user.Role != tt.wantRole || user.AuthProvider != models.AuthProviderOIDC {
+				t.Errorf("provisioned %q with role %q and provider %q, want alice@example.com, %q and oidc",
+					user.Username, user.Role, user.AuthProvider, tt.wantRole)
+			}
+			if !hasCookie(rec, "access_token") {
+				t.Error("no session was started")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsStolenCode(t *testing.T) {
+	idp := newFakeIdP(t)
+	h, db := newOIDCTestHandler(t, idp, nil)
+
+	// The victim's code arrives with the attacker's own login state, which
+	// carries a different PKCE verifier
+	victimURL, _ := startLogin(t, h)
+	attackerURL, attackerState := startLogin(t, h)
+	params := idp.authorize(victimURL, jwt.MapClaims{"sub": "victim", "email": "victim@example.com", "email_verified": true})
+	params.Set("state", mustQuery(t, attackerURL).Get("state"))
+
+	rec := callback(h, params, attackerState)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("OIDCCallback status = %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+	if user, _ := db.GetUserByOIDCSubject("victim"); user != nil {
+		t.Error("code redeemed without its PKCE verifier")
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	idp := newFakeIdP(t)
+	h, _ := newOIDCTestHandler(t, idp, nil)
+	claims := jwt.MapClaims{"sub": "alice-subject", "email": "alice@example.com", "email_verified": true}
+
+	authURL, state := startLogin(t, h)
+	params := idp.authorize(authURL, claims)
+	params.Set("state", "forged")
+	if rec := callback(h, params, state); rec.Code != http.StatusBadRequest {
+		t.Errorf("forged state: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	authURL, _ = startLogin(t, h)
+	if rec := callback(h, idp.authorize(authURL, claims), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing state cookie: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackChecksNonce(t *testing.T) {
+	idp := newFakeIdP(t)
+	h, db := newOIDCTestHandler(t, idp, nil)
+
+	// An ID token minted for another login is replayed into this one
+	authURL, state := startLogin(t, h)
+	rec := callback(h, idp.authorize(authURL, jwt.MapClaims{
+		"sub":            "alice-subject",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"nonce":          "replayed",
+	}), state)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("OIDCCallback status = %d, want %d: %s", rec.Code, http.StatusUnauthorized, rec.Body)
+	}
+	if user, _ := db.GetUserByOIDCSubject("alice-subject"); user != nil {
+		t.Error("token with a foreign nonce was accepted")
+	}
+}
+
+func TestOIDCCallbackLinksOnlyVerifiedEmail(t *testing.T) {
+	idp := newFakeIdP(t)
+	h, db := newOIDCTestHandler(t, idp, func(c *config.Config) {
+		c.OIDC.LinkLocalAccounts = true
+	})
+	if err := db.CreateUser("alice@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{
+		"sub":                "alice-subject",
+		"email":              "alice@example.com",
+		"email_verified":     false,
+		"preferred_username": "alice@example.com",
+	}
+
+	// An unverified email, even with a matching username, never takes over
+	// the local account
+	authURL, state := startLogin(t, h)
+	if rec := callback(h, idp.authorize(authURL, claims), state); rec.Code != http.StatusForbidden {
+		t.Fatalf("unverified email: status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+	}
+	local, err := db.GetUser("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.OIDCSubject != "" {
+		t.Fatal("local account was linked to an unverified email")
+	}
+
+	// Once verified, the local admin is linked and keeps its role
+	claims["email_verified"] = true
+	authURL, state = startLogin(t, h)
+	if rec := callback(h, idp.authorize(authURL, claims), state); rec.Code != http.StatusFound {
+		t.Fatalf("verified email: status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+	linked, err := db.GetUserByOIDCSubject("alice-subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked == nil || linked.ID != local.ID || linked.Role != models.RoleAdmin {
+		t.Errorf("linked user %+v, want the local admin %d", linked, local.ID)
+	}
+}
+
+func TestOIDCCallbackSyncsRole(t *testing.T) {
+	idp := newFakeIdP(t)
+	h, db := newOIDCTestHandler(t, idp, func(c *config.Config) {
+		c.OIDC.RoleMapping = map[string]string{"sharex-admins": models.RoleAdmin}
+	})
+	claims := jwt.MapClaims{
+		"sub":            "alice-subject",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"sharex-admins"},
+	}
+
+	for _, tt := range []struct {
+		groups []string
+		role   string
+	}{
+		{[]string{"sharex-admins"}, models.RoleAdmin},
+		{[]string{"staff"}, models.RoleUser},
+	} {
+		claims["groups"] = tt.groups
+		authURL, state := startLogin(t, h)
+		if rec := callback(h, idp.authorize(authURL, claims), state); rec.Code != http.StatusFound {
+			t.Fatalf("groups %v: status = %d, want %d: %s", tt.groups, rec.Code, http.StatusFound, rec.Body)
+		}
+		user, err := db.GetUserByOIDCSubject("alice-subject")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != tt.role {
+			t.Errorf("groups %v: role = %q, want %q", tt.groups, user.Role, tt.role)
+		}
+	}
+}
+
+func hasCookie(rec *httptest.ResponseRecorder, name string) bool {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func mustQuery(t *testing.T, raw string) url.Values {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
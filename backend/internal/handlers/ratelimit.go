@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sharex/internal/ratelimit"
+)
+
+// RateLimitPolicies lists the effective rate-limit policies in match order
+func (h *Handler) RateLimitPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	rl := h.config.Get().RateLimit
+	policies, err := ratelimit.NewPolicies(rl)
+	if err != nil {
+		h.logger.Error("Failed to compile rate limit policies", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	routes := []map[string]interface{}{}
+	for _, policy := range policies.Rules() {
+		routes = append(routes, policyJSON(policy))
+	}
+
+	exemptIPs, exemptUsers := rl.ExemptIPs, rl.ExemptUsers
+	if exemptIPs == nil {
+		exemptIPs = []string{}
+	}
+	if exemptUsers == nil {
+		exemptUsers = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":      rl.Enabled,
+		"store":        rl.Store,
+		"algorithm":    rl.Algorithm,
+		"fallback":     rl.Fallback,
+		"default":      policyJSON(policies.Default()),
+		"routes":       routes,
+		"exempt_ips":   exemptIPs,
+		"exempt_users": exemptUsers,
+	})
+}
+
+func policyJSON(policy *ratelimit.Policy) map[string]interface{} {
+	return map[string]interface{}{
+		"pattern":  policy.Name,
+		"method":   policy.Method,
+		"path":     policy.Path,
+		"requests": policy.Limit.Requests,
+		"period":   int64(policy.Limit.Period.Seconds()),
+		"burst":    policy.Limit.Burst,
+		"key":      policy.Key,
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sharex/internal/config"
+	"sharex/internal/models"
+)
+
+// Reload reads the configuration again and logs every setting that changed.
+// source says what asked for it, like signal or api. An invalid configuration
+// is rejected and the current one stays in effect.
+func (h *Handler) Reload(source string) ([]config.Change, error) {
+	changes, err := h.config.Reload()
+	if err != nil {
+		h.logger.Error("Rejected configuration reload, keeping the current configuration", map[string]interface{}{
+			"error":  err.Error(),
+			"source": source,
+		})
+		return nil, err
+	}
+
+	for _, change := range changes {
+		fields := map[string]interface{}{
+			"key": change.Key,
+			"old": change.Old,
+			"new": change.New,
+		}
+		if change.RestartRequired {
+			h.logger.Warn("Configuration change needs a restart to take effect", fields)
+		} else {
+			h.logger.Info("Configuration changed", fields)
+		}
+	}
+	applied := config.Applied(changes)
+	h.logger.Info("Reloaded configuration", map[string]interface{}{
+		"source":           source,
+		"applied":          applied,
+		"restart_required": len(changes) - applied,
+	})
+	return changes, nil
+}
+
+// ReloadConfig reloads the configuration, like SIGHUP, and returns what changed
+func (h *Handler) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	changes, err := h.Reload("api")
+	if err != nil {
+		http.Error(w, "Configuration rejected: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if config.Applied(changes) > 0 {
+		h.audit(r, "", models.AuditConfigReload, "config", h.config.Get().Path(), nil, changes)
+	}
+	if changes == nil {
+		changes = []config.Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+	})
+}
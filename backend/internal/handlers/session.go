@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+)
+
+// currentUser loads the authenticated user, writing an error response and
+// returning nil when it cannot
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) *models.User {
+	username := middleware.GetUsername(r)
+	if username == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	user, err := h.db.GetUser(username)
+	if err != nil {
+		h.logger.Error("Failed to get user", map[string]interface{}{
+			"error":    err.Error(),
+			"username": username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil || user.Disabled {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	return user
+}
+
+// requireAdmin loads the authenticated user and rejects the request unless
+// they have the admin role
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) *models.User {
+	user := h.currentUser(w, r)
+	if user == nil {
+		return nil
+	}
+
+	if !user.IsAdmin() {
+		h.logger.Warn("Admin access denied", map[string]interface{}{
+			"username": user.Username,
+			"path":     r.URL.Path,
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	return user
+}
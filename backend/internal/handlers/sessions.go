@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+)
+
+// refreshReuseGrace is how long a rotated refresh token may still be used,
+// so tabs refreshing at the same moment do not revoke their own session
+const refreshReuseGrace = 10 * time.Second
+
+// Sessions lists the current user's active sessions on GET and revokes all
+// of them except the current one on DELETE
+func (h *Handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	current := middleware.GetSessionID(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := h.db.ListSessions(user.ID)
+		if err != nil {
+			h.logger.Error("Failed to list sessions", map[string]interface{}{
+				"error":    err.Error(),
+				"username": user.Username,
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sessions": sessions,
+		})
+	case http.MethodDelete:
+		revoked, err := h.db.RevokeAllSessions(user.ID, current, storage.RevokedByUser)
+		if err != nil {
+			h.logger.Error("Failed to revoke sessions", map[string]interface{}{
+				"error":    err.Error(),
+				"username": user.Username,
+			})
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Revoked other sessions", map[string]interface{}{
+			"username": user.Username,
+			"revoked":  revoked,
+		})
+		h.audit(r, user.Username, models.AuditSessionsRevoked, "user", user.Username, nil, map[string]interface{}{
+			"revoked": revoked,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"revoked": revoked,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeSession handles DELETE /api/sessions/{id}
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/")
+	if id == "" {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	revoked, err := h.db.RevokeSession(user.ID, id, storage.RevokedByUser)
+	if err != nil {
+		h.logger.Error("Failed to revoke session", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Session revoked", map[string]interface{}{
+		"username": user.Username,
+		"session":  id,
+	})
+	h.audit(r, user.Username, models.AuditSessionRevoked, "session", id, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"current": id == middleware.GetSessionID(r),
+	})
+}
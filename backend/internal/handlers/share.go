@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/utils"
+)
+
+const (
+	// maxConcurrentUnlocks is how many viewer passwords are checked at once.
+	// Each check is a full PBKDF2 run, so unbounded attempts would tie up
+	// every CPU.
+	maxConcurrentUnlocks = 4
+
+	// unlockSlotWait is how long an attempt waits for a free check before
+	// it is turned away
+	unlockSlotWait = 5 * time.Second
+)
+
+// unlockFailures counts wrong viewer passwords per image and client IP, and
+// per image alone, so private images cannot be brute forced through the
+// unlock page
+type unlockFailures struct {
+	mu       sync.Mutex
+	failures map[string]int
+	expires  map[string]time.Time
+}
+
+func newUnlockFailures() *unlockFailures {
+	return &unlockFailures{
+		failures: make(map[string]int),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+// blocked returns how long unlocking stays paused for a key, or 0
+func (u *unlockFailures) blocked(key string, max int) time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failures[key] < max {
+		return 0
+	}
+	return time.Until(u.expires[key])
+}
+
+func (u *unlockFailures) fail(key string, window time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Forget failures that have aged out
+	now := time.Now()
+	for k, exp := range u.expires {
+		if now.After(exp) {
+			delete(u.expires, k)
+			delete(u.failures, k)
+		}
+	}
+
+	u.failures[key]++
+	u.expires[key] = now.Add(window)
+}
+
+func (u *unlockFailures) reset(key string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.failures, key)
+	delete(u.expires, key)
+}
+
+// imageURL returns the path an image is shared at. Private images get a
+// signed link that embeds without a password until the password changes or
+// share.link_ttl runs out.
+func (h *Handler) imageURL(image *models.Image) string {
+	url := fmt.Sprintf("/%s.%s", image.UUID, image.Extension)
+	if !image.IsPrivate || image.PasswordHash == "" {
+		return url
+	}
+
+	var expires time.Time
+	if h.config.Get().Share.LinkTTL > 0 {
+		expires = time.Now().Add(time.Duration(h.config.Get().Share.LinkTTL) * 24 * time.Hour)
+	}
+	token := utils.SignShareToken(h.config.Get().App.JWTSecret, utils.ShareLink, image.UUID, image.PasswordHash, expires)
+	return url + "?sig=" + token
+}
+
+func shareCookieName(uuid string) string {
+	return "share_" + uuid
+}
+
+// canViewPrivate reports whether a request carries a signed link or an unlock
+// cookie for a private image
+func (h *Handler) canViewPrivate(r *http.Request, image *models.Image) bool {
+	if image.PasswordHash == "" {
+		return false
+	}
+	if sig := r.URL.Query().Get("sig"); sig != "" &&
+		utils.VerifyShareToken(h.config.Get().App.JWTSecret, utils.ShareLink, image.UUID, image.PasswordHash, sig) {
+		return true
+	}
+	if cookie, err := r.Cookie(shareCookieName(image.UUID)); err == nil &&
+		utils.VerifyShareToken(h.config.Get().App.JWTSecret, utils.ShareCookie, image.UUID, image.PasswordHash, cookie.Value) {
+		return true
+	}
+	return false
+}
+
+// serveUnlockPage asks for the password of a private image
+func (h *Handler) serveUnlockPage(w http.ResponseWriter, image *models.Image, status int, message string) {
+	tmpl, err := template.ParseFiles(filepath.Join("frontend", "static", "unlock.html"))
+	if err != nil {
+		h.logger.Error("Failed to load unlock page", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	tmpl.Execute(w, map[string]interface{}{
+		"Action": fmt.Sprintf("/unlock/%s.%s", image.UUID, image.Extension),
+		"Error":  message,
+	})
+}
+
+// UnlockImage checks the password posted from the unlock page and, when it
+// matches, sets a cookie scoped to the image that grants access for
+// share.unlock_duration minutes
+func (h *Handler) UnlockImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/unlock/")
+	uuid, ext, ok := strings.Cut(name, ".")
+	if !ok || strings.Contains(ext, ".") || strings.Contains(name, "/") {
+		h.serveStaticFile(w, "404.html")
+		return
+	}
+
+	image, err := h.db.GetImage(uuid)
+	if err != nil {
+		h.logger.Error("Failed to get image", map[string]interface{}{
+			"error": err.Error(),
+			"uuid":  uuid,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if image == nil || image.Extension != ext {
+		h.serveStaticFile(w, "404.html")
+		return
+	}
+
+	imagePath := fmt.Sprintf("/%s.%s", image.UUID, image.Extension)
+	if !image.IsPrivate {
+		http.Redirect(w, r, imagePath, http.StatusSeeOther)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	attemptKey := image.UUID + "|" + ip
+	share := h.config.Get().Share
+	wait := h.unlockFailures.blocked(attemptKey, share.MaxAttempts)
+	if share.MaxImageAttempts > 0 {
+		if imageWait := h.unlockFailures.blocked(image.UUID, share.MaxImageAttempts); imageWait > wait {
+			wait = imageWait
+		}
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+		h.serveUnlockPage(w, image, http.StatusTooManyRequests,
+			fmt.Sprintf("Too many wrong passwords. Try again in %d minutes.", int(wait.Minutes())+1))
+		return
+	}
+
+	select {
+	case h.unlockSlots <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	case <-time.After(unlockSlotWait):
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(unlockSlotWait.Seconds())))
+		h.serveUnlockPage(w, image, http.StatusServiceUnavailable, "Too many unlock attempts right now. Try again shortly.")
+		return
+	}
+	valid := utils.CheckPassword(r.PostFormValue("password"), image.PasswordHash)
+	<-h.unlockSlots
+
+	if !valid {
+		window := time.Duration(share.AttemptWindow) * time.Minute
+		h.unlockFailures.fail(attemptKey, window)
+		if share.MaxImageAttempts > 0 {
+			h.unlockFailures.fail(image.UUID, window)
+		}
+		h.logger.Warn("Wrong password for private image", map[string]interface{}{
+			"uuid": image.UUID,
+			"ip":   ip,
+		})
+		h.serveUnlockPage(w, image, http.StatusUnauthorized, "Wrong password.")
+		return
+	}
+	h.unlockFailures.reset(attemptKey)
+
+	duration := time.Duration(h.config.Get().Share.UnlockDuration) * time.Minute
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookieName(image.UUID),
+		Value:    utils.SignShareToken(h.config.Get().App.JWTSecret, utils.ShareCookie, image.UUID, image.PasswordHash, time.Now().Add(duration)),
+		Path:     imagePath,
+		HttpOnly: true,
+		Secure:   h.config.Get().App.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(duration.Seconds()),
+	})
+	http.Redirect(w, r, imagePath, http.StatusSeeOther)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// newShareTestHandler returns a handler serving one private image with the
+// password "secret", run from a directory holding the unlock page
+func newShareTestHandler(t *testing.T, maxImageAttempts int) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "frontend", "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "frontend", "static", "unlock.html"), []byte("{{.Error}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	cfg := &config.Config{}
+	cfg.App.JWTSecret = "test-secret"
+	cfg.Share.UnlockDuration = 60
+	cfg.Share.MaxAttempts = 2
+	cfg.Share.MaxImageAttempts = maxImageAttempts
+	cfg.Share.AttemptWindow = 15
+
+	db, err := storage.NewDB("sqlite", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	logger, err := utils.NewLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := utils.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := &models.Image{
+		UUID:         "private",
+		Filename:     "private.png",
+		Extension:    "png",
+		Size:         1,
+		UploadedAt:   time.Now().UTC(),
+		IsPrivate:    true,
+		PasswordHash: hash,
+	}
+	if err := db.CreateImage(image); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(config.NewStore(cfg), db, logger, nil, nil, nil, nil, nil)
+}
+
+func unlock(h *Handler, ip, password string) int {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/unlock/private.png", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":5000"
+	rec := httptest.NewRecorder()
+	h.UnlockImage(rec, req)
+	return rec.Code
+}
+
+func TestUnlockImageAttemptLimits(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxImageAttempts int
+		// status of a wrong password from a new IP after three wrong ones
+		// from three other IPs
+		want int
+	}{
+		{"image-wide pause", 3, http.StatusTooManyRequests},
+		{"image-wide pause disabled", -1, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newShareTestHandler(t, tt.maxImageAttempts)
+			for i := 1; i <= 3; i++ {
+				if status := unlock(h, fmt.Sprintf("198.51.100.%d", i), "wrong"); status != http.StatusUnauthorized {
+					t.Fatalf("wrong password %d: status %d, want %d", i, status, http.StatusUnauthorized)
+				}
+			}
+			if status := unlock(h, "198.51.100.9", "wrong"); status != tt.want {
+				t.Errorf("wrong password from a new IP: status %d, want %d", status, tt.want)
+			}
+
+			if tt.maxImageAttempts > 0 {
+				return
+			}
+
+			// Without the image-wide pause the per-IP limit still applies
+			if status := unlock(h, "198.51.100.1", "wrong"); status != http.StatusUnauthorized {
+				t.Fatalf("second wrong password from one IP: status %d, want %d", status, http.StatusUnauthorized)
+			}
+			if status := unlock(h, "198.51.100.1", "secret"); status != http.StatusTooManyRequests {
+				t.Errorf("third attempt from one IP: status %d, want %d", status, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"sharex/internal/middleware"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+const (
+	// maxChallengeAttempts is how many wrong codes a single challenge token accepts
+	maxChallengeAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+// challengeFailures counts wrong second-factor codes per challenge token so a
+// stolen challenge cannot be used to brute force the six digit code
+type challengeFailures struct {
+	mu       sync.Mutex
+	failures map[string]int
+	expires  map[string]time.Time
+}
+
+func newChallengeFailures() *challengeFailures {
+	return &challengeFailures{
+		failures: make(map[string]int),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+func (c *challengeFailures) exceeded(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures[id] >= maxChallengeAttempts
+}
+
+func (c *challengeFailures) fail(id string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget challenges that can no longer be used anyway
+	now := time.Now()
+	for key, exp := range c.expires {
+		if now.After(exp) {
+			delete(c.expires, key)
+			delete(c.failures, key)
+		}
+	}
+
+	c.failures[id]++
+	c.expires[id] = expiresAt
+}
+
+// LoginTwoFactor completes a login started with a password by checking a TOTP
+// or recovery code against the challenge token
+func (h *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := utils.ValidateToken(req.ChallengeToken, h.config.Get().App.JWTSecret)
+	if err != nil || claims.TokenType != utils.TwoFactorChallenge || claims.ID == "" {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	if h.challengeFailures.exceeded(claims.ID) {
+		http.Error(w, "Too many attempts, please log in again", http.StatusTooManyRequests)
+		return
+	}
+
+	ip := utils.GetIPFromAddr(r)
+	release, allowed := h.loginAllowed(w, r, claims.Username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
+
+	user, err := h.db.GetUser(claims.Username)
+	if err != nil {
+		h.logger.Error("Failed to get user", map[string]interface{}{
+			"error":    err.Error(),
+			"username": claims.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Disabled || !user.TOTPEnabled {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	var ok bool
+	method := "totp"
+	switch {
+	case req.Code != "":
+		if counter, valid := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now()); valid {
+			// Only the first use of a code within its window is accepted
+			ok, err = h.db.AdvanceTOTPCounter(user.ID, counter)
+		}
+	case req.RecoveryCode != "":
+		method = "recovery_code"
+		ok, err = h.db.UseRecoveryCode(user.ID, utils.HashRecoveryCode(req.RecoveryCode))
+	default:
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to verify second factor", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		h.challengeFailures.fail(claims.ID, claims.ExpiresAt.Time)
+		h.loginFailed(r, user.Username, ip, method)
+		h.logger.Warn("Invalid second factor", map[string]interface{}{
+			"username": user.Username,
+			"method":   method,
+		})
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if method == "recovery_code" {
+		h.logger.Warn("Recovery code used for login", map[string]interface{}{
+			"username": user.Username,
+		})
+	}
+
+	h.completeLogin(w, r, user)
+}
+
+// TwoFactorStatus reports whether the current user has two-factor enabled
+func (h *Handler) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	remaining, err := h.db.CountRecoveryCodes(user.ID)
+	if err != nil {
+		h.logger.Error("Failed to count recovery codes", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  user.TOTPEnabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// TwoFactorSetup generates a new secret for the current user. It only takes
+// effect once confirmed with a valid code through TwoFactorEnable.
+func (h *Handler) TwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.AuthProvider == models.AuthProviderOIDC {
+		http.Error(w, "Two-factor authentication is managed by your identity provider", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate TOTP secret", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		h.logger.Error("Failed to store TOTP secret", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(h.config.Get().App.TOTPIssuer, user.Username, secret),
+	})
+}
+
+// TwoFactorEnable confirms the pending secret and returns a fresh set of
+// recovery codes. The codes are only shown this once.
+func (h *Handler) TwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, "Two-factor setup has not been started", http.StatusBadRequest)
+		return
+	}
+
+	counter, valid := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+
+	if err := h.db.EnableTOTP(user.ID, counter, hashes); err != nil {
+		h.logger.Error("Failed to enable two-factor authentication", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Two-factor authentication enabled", map[string]interface{}{
+		"username": user.Username,
+	})
+	h.audit(r, user.Username, models.AuditTwoFactorEnable, "user", user.Username,
+		map[string]interface{}{"totp_enabled": false},
+		map[string]interface{}{"totp_enabled": true})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// TwoFactorDisable turns two-factor off for the current user after checking
+// both their password and a current code
+func (h *Handler) TwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	// Wrong passwords and codes count against the same throttle as logins so
+	// a stolen session cannot brute force the code to strip two-factor
+	ip := utils.GetIPFromAddr(r)
+	release, allowed := h.loginAllowed(w, r, user.Username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
+	if !checkUserPassword(user, req.Password) {
+		h.loginFailed(r, user.Username, ip, "password")
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	var ok bool
+	var err error
+	method := "totp"
+	if req.RecoveryCode != "" {
+		method = "recovery_code"
+		ok, err = h.db.UseRecoveryCode(user.ID, utils.HashRecoveryCode(req.RecoveryCode))
+	} else if counter, valid := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now()); valid {
+		ok, err = h.db.AdvanceTOTPCounter(user.ID, counter)
+	}
+	if err != nil {
+		h.logger.Error("Failed to verify second factor", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.loginFailed(r, user.Username, ip, method)
+		h.logger.Warn("Invalid second factor", map[string]interface{}{
+			"username": user.Username,
+			"method":   method,
+		})
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.db.DisableTOTP(user.ID); err != nil {
+		h.logger.Error("Failed to disable two-factor authentication", map[string]interface{}{
+			"error":    err.Error(),
+			"username": user.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Two-factor authentication disabled", map[string]interface{}{
+		"username": user.Username,
+	})
+	h.audit(r, user.Username, models.AuditTwoFactorOff, "user", user.Username,
+		map[string]interface{}{"totp_enabled": true},
+		map[string]interface{}{"totp_enabled": false})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// TwoFactorReset lets an administrator remove two-factor from an account
+// whose owner lost both their device and recovery codes
+func (h *Handler) TwoFactorReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	target, err := h.db.GetUser(req.Username)
+	if err != nil {
+		h.logger.Error("Failed to get user", map[string]interface{}{
+			"error":    err.Error(),
+			"username": req.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if target == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.db.DisableTOTP(target.ID); err != nil {
+		h.logger.Error("Failed to reset two-factor authentication", map[string]interface{}{
+			"error":    err.Error(),
+			"username": target.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever holds the lost device may also hold a live session
+	revoked, err := h.db.RevokeAllSessions(target.ID, "", storage.RevokedByUser)
+	if err != nil {
+		h.logger.Error("Failed to revoke sessions", map[string]interface{}{
+			"error":    err.Error(),
+			"username": target.Username,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Warn("Two-factor authentication reset", map[string]interface{}{
+		"username":         target.Username,
+		"by":               middleware.GetUsername(r),
+		"revoked_sessions": revoked,
+	})
+	h.audit(r, "", models.AuditTwoFactorReset, "user", target.Username,
+		map[string]interface{}{"totp_enabled": target.TOTPEnabled},
+		map[string]interface{}{"totp_enabled": false})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
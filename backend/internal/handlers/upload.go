@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sharex/internal/metrics"
+	"sharex/internal/models"
+	"sharex/internal/upload"
+	"sharex/internal/utils"
+)
+
+// stageUpload copies an upload to a temporary file inside the storage path,
+// verifies that its content matches its extension and runs it past the
+// malware scanner. Flagged files are moved to the quarantine directory. On
+// success the caller renames the returned file into place; it must remove it
+// otherwise. The SHA-256 of the content is returned with it. When ok is false
+// the response has already been written.
+func (h *Handler) stageUpload(w http.ResponseWriter, r *http.Request, src io.Reader, filename, ext string) (string, string, bool) {
+	tmp, err := os.CreateTemp(h.config.Get().Storage.BasePath, ".upload-*")
+	if err != nil {
+		h.logger.Error("Failed to create temporary upload file", map[string]interface{}{
+			"error": err.Error(),
+			"path":  h.config.Get().Storage.BasePath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", "", false
+	}
+	tmpPath := tmp.Name()
+	defer tmp.Close()
+
+	fail := func() (string, string, bool) {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", "", false
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err != nil {
+		h.logger.Error("Failed to copy file", map[string]interface{}{
+			"error": err.Error(),
+			"path":  tmpPath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return fail()
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	// Verify the content against the claimed type
+	if _, known := upload.FormatForExtension(ext); known {
+		limits := upload.Limits{
+			MaxPixels:       h.config.Get().Uploads.MaxPixels,
+			MaxDimension:    h.config.Get().Uploads.MaxDimension,
+			AllowUnverified: h.config.Get().Uploads.AllowUnverified,
+		}
+		if err := upload.Validate(tmp, ext, limits); err != nil {
+			var invalid *upload.InvalidError
+			if !errors.As(err, &invalid) {
+				h.logger.Error("Failed to validate upload", map[string]interface{}{
+					"error": err.Error(),
+					"path":  tmpPath,
+				})
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return fail()
+			}
+			h.logger.Warn("Upload content rejected", map[string]interface{}{
+				"error":    err.Error(),
+				"filename": filename,
+				"ip":       utils.ClientIP(r),
+			})
+			metrics.UploadRejections.Inc("invalid")
+			writeUploadError(w, http.StatusBadRequest, fmt.Sprintf("File content is not a valid .%s image: %s", ext, invalid.Reason))
+			return fail()
+		}
+	} else if !h.config.Get().Uploads.AllowUnverified {
+		h.logger.Warn("Upload type cannot be verified", map[string]interface{}{
+			"extension": ext,
+			"filename":  filename,
+		})
+		metrics.UploadRejections.Inc("unverified")
+		writeUploadError(w, http.StatusBadRequest, fmt.Sprintf("File type '.%s' cannot be verified", ext))
+		return fail()
+	}
+
+	if h.scanner == nil {
+		return tmpPath, digest, true
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		h.logger.Error("Failed to rewind upload", map[string]interface{}{
+			"error": err.Error(),
+			"path":  tmpPath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return fail()
+	}
+
+	result, err := h.scanner.Scan(r.Context(), tmp)
+	if err != nil {
+		if h.config.Get().Uploads.Scanner.FailOpen {
+			h.logger.Warn("Upload scan failed, accepting the file unscanned", map[string]interface{}{
+				"error":    err.Error(),
+				"scanner":  h.scanner.Name(),
+				"filename": filename,
+			})
+			return tmpPath, digest, true
+		}
+		h.logger.Error("Upload scan failed", map[string]interface{}{
+			"error":    err.Error(),
+			"scanner":  h.scanner.Name(),
+			"filename": filename,
+		})
+		metrics.UploadRejections.Inc("scan_error")
+		writeUploadError(w, http.StatusServiceUnavailable, "Upload scanning is unavailable, try again later")
+		return fail()
+	}
+	if result.Clean {
+		return tmpPath, digest, true
+	}
+
+	// Flagged, keep the file where it can never be served
+	tmp.Close()
+	now := time.Now()
+	quarantinePath := filepath.Join(h.config.Get().Uploads.QuarantineDir,
+		fmt.Sprintf("%s-%s.quarantined", now.UTC().Format("20060102T150405"), digest[:16]))
+	if err := moveFile(tmpPath, quarantinePath); err != nil {
+		h.logger.Error("Failed to quarantine upload", map[string]interface{}{
+			"error": err.Error(),
+			"path":  quarantinePath,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return fail()
+	}
+
+	record := &models.QuarantinedUpload{
+		Filename:      filename,
+		Extension:     ext,
+		Size:          size,
+		SHA256:        digest,
+		Path:          quarantinePath,
+		Scanner:       h.scanner.Name(),
+		Signature:     result.Signature,
+		IP:            utils.ClientIP(r),
+		QuarantinedAt: now,
+	}
+	if err := h.db.CreateQuarantinedUpload(record); err != nil {
+		h.logger.Error("Failed to record quarantined upload", map[string]interface{}{
+			"error": err.Error(),
+			"path":  quarantinePath,
+		})
+	}
+
+	h.logger.Warn("Upload flagged by malware scanner and quarantined", map[string]interface{}{
+		"filename":  filename,
+		"signature": result.Signature,
+		"scanner":   h.scanner.Name(),
+		"sha256":    digest,
+		"ip":        record.IP,
+	})
+	h.audit(r, "", models.AuditUploadFlagged, "quarantine", strconv.FormatInt(record.ID, 10), nil, map[string]interface{}{
+		"filename":  filename,
+		"signature": result.Signature,
+		"sha256":    digest,
+	})
+	metrics.UploadRejections.Inc("quarantined")
+
+	writeUploadError(w, http.StatusUnprocessableEntity, "File was flagged by the malware scanner")
+	return "", "", false
+}
+
+func writeUploadError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": message,
+	})
+}
+
+// moveFile renames a file, copying it when the destination is on another
+// file system
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Quarantine lists uploads the malware scanner flagged
+func (h *Handler) Quarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	uploads, err := h.db.ListQuarantinedUploads()
+	if err != nil {
+		h.logger.Error("Failed to list quarantined uploads", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"uploads": uploads,
+	})
+}
+
+// DeleteQuarantined permanently removes a quarantined upload and its file
+func (h *Handler) DeleteQuarantined(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/quarantine/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid quarantine ID", http.StatusBadRequest)
+		return
+	}
+
+	record, err := h.db.GetQuarantinedUpload(id)
+	if err != nil {
+		h.logger.Error("Failed to get quarantined upload", map[string]interface{}{
+			"error": err.Error(),
+			"id":    id,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		http.Error(w, "Quarantined upload not found", http.StatusNotFound)
+		return
+	}
+
+	if err := os.Remove(record.Path); err != nil && !os.IsNotExist(err) {
+		h.logger.Error("Failed to delete quarantined file", map[string]interface{}{
+			"error": err.Error(),
+			"path":  record.Path,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.DeleteQuarantinedUpload(id); err != nil {
+		h.logger.Error("Failed to delete quarantined upload", map[string]interface{}{
+			"error": err.Error(),
+			"id":    id,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "", models.AuditQuarantineClear, "quarantine", strconv.FormatInt(id, 10), map[string]interface{}{
+		"filename":  record.Filename,
+		"signature": record.Signature,
+		"sha256":    record.SHA256,
+	}, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/webhooks"
+)
+
+// Webhooks lists webhooks on GET and creates one on POST
+func (h *Handler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.listWebhooks(w)
+	case http.MethodPost:
+		h.createWebhook(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookRoutes handles the per-webhook routes:
+//
+//	DELETE /api/webhooks/{id}
+//	GET    /api/webhooks/{id}/deliveries
+//	POST   /api/webhooks/{id}/deliveries/{deliveryId}/replay
+func (h *Handler) WebhookRoutes(w http.ResponseWriter, r *http.Request) {
+	if h.requireAdmin(w, r) == nil {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/webhooks/"), "/"), "/")
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.db.GetWebhook(id)
+	if err != nil {
+		h.logger.Error("Failed to get webhook", map[string]interface{}{
+			"error":      err.Error(),
+			"webhook_id": id,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if webhook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		h.deleteWebhook(w, r, webhook)
+	case len(parts) == 2 && parts[1] == "deliveries" && r.Method == http.MethodGet:
+		h.listWebhookDeliveries(w, r, webhook)
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "replay" && r.Method == http.MethodPost:
+		h.replayWebhookDelivery(w, webhook, parts[2])
+	case len(parts) <= 4:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func (h *Handler) listWebhooks(w http.ResponseWriter) {
+	list, err := h.db.ListWebhooks()
+	if err != nil {
+		h.logger.Error("Failed to list webhooks", map[string]interface{}{
+			"error": err.Error(),
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Secrets are only shown once, when the webhook is created
+	for i := range list {
+		list[i].Secret = ""
+	}
+	if list == nil {
+		list = []models.Webhook{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhooks": list,
+		"events":   webhooks.EventNames,
+	})
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "A valid http or https URL is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Events) == 0 {
+		http.Error(w, "At least one event is required", http.StatusBadRequest)
+		return
+	}
+	for _, event := range req.Events {
+		if event != "*" && !webhooks.IsValidEvent(event) {
+			http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	webhook := &models.Webhook{
+		URL:       target.String(),
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Enabled:   true,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.db.CreateWebhook(webhook); err != nil {
+		h.logger.Error("Failed to create webhook", map[string]interface{}{
+			"error": err.Error(),
+			"url":   webhook.URL,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.reloadWebhooks()
+
+	h.logger.Info("Webhook created", map[string]interface{}{
+		"webhook_id": webhook.ID,
+		"url":        webhook.URL,
+		"events":     webhook.Events,
+	})
+	// The signing secret is not recorded
+	h.audit(r, "", models.AuditWebhookCreate, "webhook", strconv.FormatInt(webhook.ID, 10), nil, map[string]interface{}{
+		"url":    webhook.URL,
+		"events": webhook.Events,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request, webhook *models.Webhook) {
+	if err := h.db.DeleteWebhook(webhook.ID); err != nil {
+		h.logger.Error("Failed to delete webhook", map[string]interface{}{
+			"error":      err.Error(),
+			"webhook_id": webhook.ID,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.reloadWebhooks()
+
+	h.logger.Info("Webhook deleted", map[string]interface{}{
+		"webhook_id": webhook.ID,
+		"url":        webhook.URL,
+	})
+	h.audit(r, "", models.AuditWebhookDelete, "webhook", strconv.FormatInt(webhook.ID, 10), map[string]interface{}{
+		"url":    webhook.URL,
+		"events": webhook.Events,
+	}, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Webhook deleted successfully",
+	})
+}
+
+func (h *Handler) listWebhookDeliveries(w http.ResponseWriter, r *http.Request, webhook *models.Webhook) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 500 {
+			http.Error(w, "Invalid limit, expected 1-500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := h.db.ListWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		h.logger.Error("Failed to list webhook deliveries", map[string]interface{}{
+			"error":      err.Error(),
+			"webhook_id": webhook.ID,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": deliveries,
+	})
+}
+
+// replayWebhookDelivery queues a fresh delivery with the payload of an earlier
+// one, leaving the original entry in the log untouched
+func (h *Handler) replayWebhookDelivery(w http.ResponseWriter, webhook *models.Webhook, deliveryParam string) {
+	deliveryID, err := strconv.ParseInt(deliveryParam, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	original, err := h.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		h.logger.Error("Failed to get webhook delivery", map[string]interface{}{
+			"error":       err.Error(),
+			"delivery_id": deliveryID,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if original == nil || original.WebhookID != webhook.ID {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	delivery, err := h.webhooks.Enqueue(webhook.ID, original.EventType, original.Payload)
+	if err != nil {
+		h.logger.Error("Failed to replay webhook delivery", map[string]interface{}{
+			"error":       err.Error(),
+			"delivery_id": deliveryID,
+		})
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Webhook delivery replayed", map[string]interface{}{
+		"webhook_id":  webhook.ID,
+		"delivery_id": deliveryID,
+		"replay_id":   delivery.ID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// reloadWebhooks refreshes the dispatcher's webhook cache after a change
+func (h *Handler) reloadWebhooks() {
+	if err := h.webhooks.Reload(); err != nil {
+		h.logger.Error("Failed to reload webhooks", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"sharex/internal/config"
+	"sharex/internal/ratelimit"
+	"sharex/internal/size"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// Database checks that the database answers
+func Database(db *storage.DB) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		stats := db.Stats()
+		details := map[string]interface{}{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+		}
+		return details, db.PingContext(ctx)
+	}
+}
+
+// Schema checks that the database schema is migrated to this version
+func Schema(db *storage.DB) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, db.CheckSchema(ctx)
+	}
+}
+
+// Storage checks that files can be written to the storage path. The test
+// file is a dotfile like an upload in progress, so the storage check reports
+// one left behind as a stale temporary file.
+func Storage(dir string) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return nil, fmt.Errorf("storage path is not writable: %w", err)
+		}
+		defer os.Remove(f.Name())
+
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write to the storage path: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to sync the storage path: %w", err)
+		}
+		return nil, f.Close()
+	}
+}
+
+// FreeDisk checks that the volume of the storage path has at least
+// health.min_free_disk free
+func FreeDisk(dir string, configs *config.Store) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		minFree, err := configs.Get().GetMinFreeDisk()
+		if err != nil {
+			return nil, err
+		}
+		if minFree == 0 {
+			return nil, Skip("health.min_free_disk is 0")
+		}
+
+		var total, free uint64
+		if err := utils.GetDiskUsage(dir, &total, &free); err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{
+			"free":     size.Format(int64(free)),
+			"total":    size.Format(int64(total)),
+			"min_free": size.Format(minFree),
+		}
+		if free < uint64(minFree) {
+			return details, fmt.Errorf("%s free, below health.min_free_disk", size.Format(int64(free)))
+		}
+		return details, nil
+	}
+}
+
+// RedisPinger is implemented by the rate limiter
+type RedisPinger interface {
+	PingRedis(ctx context.Context) (used bool, fallback string, err error)
+}
+
+// Redis checks the Redis server requests are rate limited with. Requests
+// keep being served by the fallback policy while it is unreachable, so only
+// the deny policy fails the probe.
+func Redis(limiter RedisPinger) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		used, fallback, err := limiter.PingRedis(ctx)
+		if !used {
+			return nil, Skip("rate limiting does not use Redis")
+		}
+		details := map[string]interface{}{"fallback": fallback}
+		if err != nil && fallback != ratelimit.FallbackDeny {
+			return details, Warn(err)
+		}
+		return details, err
+	}
+}
@@ -0,0 +1,255 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/utils"
+)
+
+// Statuses of a check and of a whole probe
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded" // a check warned, the probe still passes
+	StatusWarn     = "warn"     // the check failed without failing the probe
+	StatusFail     = "fail"
+	StatusSkipped  = "skipped" // the check does not apply to this configuration
+)
+
+// CheckFunc checks one dependency. It returns details to report and an
+// error when the dependency is unhealthy, or one made by Warn or Skip.
+type CheckFunc func(ctx context.Context) (map[string]interface{}, error)
+
+type warnError struct{ err error }
+
+func (e warnError) Error() string { return e.err.Error() }
+
+// Warn marks a failure that should be reported without failing the probe,
+// like a dependency with a fallback
+func Warn(err error) error {
+	return warnError{err}
+}
+
+type skipError struct{ reason string }
+
+func (e skipError) Error() string { return e.reason }
+
+// Skip reports that a check does not apply, for example to a disabled feature
+func Skip(reason string) error {
+	return skipError{reason}
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status   string                 `json:"status"`
+	Duration float64                `json:"duration_ms"`
+	Error    string                 `json:"error,omitempty"`
+	Reason   string                 `json:"reason,omitempty"` // why a check was skipped
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the response of a probe
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// check is a registered check. Probes that arrive while it is running wait
+// for that run instead of starting another, so a hung dependency does not
+// pile up goroutines.
+type check struct {
+	name     string
+	liveness bool
+	fn       CheckFunc
+
+	mu       sync.Mutex
+	inflight *call
+}
+
+type call struct {
+	done   chan struct{}
+	result Result
+}
+
+// Prober serves the liveness and readiness probes
+type Prober struct {
+	configs *config.Store
+	logger  *utils.Logger
+	checks  []*check
+
+	mu     sync.Mutex
+	status map[string]string // last status of every check, to log changes
+}
+
+func NewProber(configs *config.Store, logger *utils.Logger) *Prober {
+	return &Prober{
+		configs: configs,
+		logger:  logger,
+		status:  map[string]string{},
+	}
+}
+
+// Add registers a readiness check. Liveness checks are run by both probes
+// and should only fail when restarting the server would help.
+func (p *Prober) Add(name string, liveness bool, fn CheckFunc) {
+	p.checks = append(p.checks, &check{name: name, liveness: liveness, fn: fn})
+}
+
+// Liveness serves /healthz, which fails when the server should be restarted
+func (p *Prober) Liveness(w http.ResponseWriter, r *http.Request) {
+	p.serve(w, r, true)
+}
+
+// Readiness serves /readyz, which fails while the server cannot serve
+// requests, like when the database is unreachable
+func (p *Prober) Readiness(w http.ResponseWriter, r *http.Request) {
+	p.serve(w, r, false)
+}
+
+func (p *Prober) serve(w http.ResponseWriter, r *http.Request, liveness bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := p.Run(r.Context(), liveness)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// cutoff is how long after the timeout a check that ignores its context is
+// waited for. Checks that stop at the timeout report it themselves, as a
+// warning where that applies.
+const cutoff = 100 * time.Millisecond
+
+// Run runs the checks of a probe at the same time, each for at most the
+// configured timeout
+func (p *Prober) Run(ctx context.Context, liveness bool) Report {
+	timeout := time.Duration(p.configs.Get().Health.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout+cutoff)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	report := Report{Status: StatusOK, Checks: map[string]Result{}}
+	for _, c := range p.checks {
+		if liveness && !c.liveness {
+			continue
+		}
+		wg.Add(1)
+		go func(c *check) {
+			defer wg.Done()
+			result := c.run(ctx, timeout)
+			p.logChange(c.name, result)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			switch {
+			case result.Status == StatusFail:
+				report.Status = StatusFail
+			case result.Status == StatusWarn && report.Status == StatusOK:
+				report.Status = StatusDegraded
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+// run returns the result of the check, joining a run that is in progress
+func (c *check) run(ctx context.Context, timeout time.Duration) Result {
+	c.mu.Lock()
+	cl := c.inflight
+	if cl == nil {
+		cl = &call{done: make(chan struct{})}
+		c.inflight = cl
+		go func() {
+			checkCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			start := time.Now()
+			details, err := c.fn(checkCtx)
+			cl.result = newResult(details, err, time.Since(start))
+			if checkCtx.Err() != nil && (cl.result.Status == StatusFail || cl.result.Status == StatusWarn) {
+				cl.result.Error = fmt.Sprintf("timed out after %s", timeout)
+			}
+
+			c.mu.Lock()
+			c.inflight = nil
+			c.mu.Unlock()
+			close(cl.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.result
+	case <-ctx.Done():
+		return Result{
+			Status:   StatusFail,
+			Duration: milliseconds(timeout),
+			Error:    fmt.Sprintf("timed out after %s", timeout),
+		}
+	}
+}
+
+func newResult(details map[string]interface{}, err error, elapsed time.Duration) Result {
+	result := Result{Status: StatusOK, Duration: milliseconds(elapsed), Details: details}
+	var warn warnError
+	var skip skipError
+	switch {
+	case err == nil:
+	case errors.As(err, &skip):
+		result.Status = StatusSkipped
+		result.Reason = skip.reason
+	case errors.As(err, &warn):
+		result.Status = StatusWarn
+		result.Error = warn.err.Error()
+	default:
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// logChange logs a check that started or stopped failing. Probes run every
+// few seconds, so unchanged results are not logged.
+func (p *Prober) logChange(name string, result Result) {
+	p.mu.Lock()
+	previous := p.status[name]
+	p.status[name] = result.Status
+	p.mu.Unlock()
+
+	failing := result.Status == StatusFail || result.Status == StatusWarn
+	wasFailing := previous == StatusFail || previous == StatusWarn
+	switch {
+	case failing && previous != result.Status:
+		p.logger.Warn("Health check failed", map[string]interface{}{
+			"check":  name,
+			"status": result.Status,
+			"error":  result.Error,
+		})
+	case !failing && wasFailing:
+		p.logger.Info("Health check recovered", map[string]interface{}{
+			"check": name,
+		})
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat tracks whether the loop of a background worker keeps coming
+// round. The worker beats on every pass and stops the heartbeat when its loop
+// returns.
+type Heartbeat struct {
+	name     string
+	interval time.Duration
+	last     atomic.Int64 // unix nanoseconds of the last beat, 0 before the loop started
+	stopped  atomic.Bool
+}
+
+// NewHeartbeat returns the heartbeat of a worker that passes through its loop
+// every interval
+func NewHeartbeat(name string, interval time.Duration) *Heartbeat {
+	return &Heartbeat{name: name, interval: interval}
+}
+
+// Beat records a pass through the worker's loop
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Stop records that the worker's loop returned, because it was closed or had
+// nothing left to do
+func (h *Heartbeat) Stop() {
+	h.stopped.Store(true)
+}
+
+// stale is how long a worker may go without a beat. A pass may take longer
+// than the interval, like a backup of a large library, so it gets a second
+// interval and a minute on top.
+func (h *Heartbeat) stale() time.Duration {
+	return 2*h.interval + time.Minute
+}
+
+// Workers checks that the loops of the started workers have beaten recently
+func Workers(heartbeats ...*Heartbeat) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		now := time.Now()
+		details := map[string]interface{}{}
+		var stuck []string
+		for _, h := range heartbeats {
+			last := h.last.Load()
+			switch {
+			case h.stopped.Load():
+				details[h.name] = "stopped"
+			case last == 0:
+				details[h.name] = "disabled"
+			default:
+				since := now.Sub(time.Unix(0, last))
+				details[h.name] = fmt.Sprintf("last ran %s ago", since.Round(time.Second))
+				if since > h.stale() {
+					stuck = append(stuck, h.name)
+				}
+			}
+		}
+		if len(stuck) > 0 {
+			return details, fmt.Errorf("stuck: %s", strings.Join(stuck, ", "))
+		}
+		return details, nil
+	}
+}
@@ -0,0 +1,203 @@
+package lockout
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// Guard slows down and locks out repeated failed logins per account and per
+// client IP. State lives in the database so it applies without Redis and
+// survives restarts.
+type Guard struct {
+	config *config.Config
+	db     *storage.DB
+	logger *utils.Logger
+
+	mu       sync.Mutex
+	inFlight map[target]*attemptSlot
+}
+
+// attemptSlot admits one login attempt for an account or IP at a time
+type attemptSlot struct {
+	held    chan struct{}
+	waiters int
+}
+
+func NewGuard(cfg *config.Config, db *storage.DB, logger *utils.Logger) *Guard {
+	return &Guard{
+		config:   cfg,
+		db:       db,
+		logger:   logger,
+		inFlight: make(map[target]*attemptSlot),
+	}
+}
+
+// Acquire waits until no other login attempt for the account or the IP is
+// being verified, and reserves both until release is called. Checking a
+// password is slow, so without this a burst of parallel guesses would all
+// pass Check before the first failure is recorded. Attempts are serialized
+// within this process only.
+func (g *Guard) Acquire(ctx context.Context, username, ip string) (release func(), err error) {
+	var held []target
+	release = func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			g.releaseSlot(held[i])
+		}
+	}
+	// Accounts come before IPs in every attempt, so waits cannot form a cycle
+	for _, t := range g.targets(username, ip) {
+		if err := g.acquireSlot(ctx, t); err != nil {
+			release()
+			return nil, err
+		}
+		held = append(held, t)
+	}
+	return release, nil
+}
+
+func (g *Guard) acquireSlot(ctx context.Context, t target) error {
+	g.mu.Lock()
+	slot := g.inFlight[t]
+	if slot == nil {
+		slot = &attemptSlot{held: make(chan struct{}, 1)}
+		g.inFlight[t] = slot
+	}
+	slot.waiters++
+	g.mu.Unlock()
+
+	select {
+	case slot.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		g.dropSlot(t, slot)
+		return ctx.Err()
+	}
+}
+
+func (g *Guard) releaseSlot(t target) {
+	g.mu.Lock()
+	slot := g.inFlight[t]
+	g.mu.Unlock()
+	<-slot.held
+	g.dropSlot(t, slot)
+}
+
+// dropSlot forgets a slot once nobody holds or waits for it
+func (g *Guard) dropSlot(t target, slot *attemptSlot) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	slot.waiters--
+	if slot.waiters == 0 {
+		delete(g.inFlight, t)
+	}
+}
+
+// Check returns how long the caller has to wait before another login attempt
+// for this account from this IP is accepted, or 0 if it may proceed. Call it
+// after Acquire so earlier attempts have recorded their failures.
+func (g *Guard) Check(username, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, target := range g.targets(username, ip) {
+		t, err := g.db.GetLoginThrottle(target.kind, target.key)
+		if err != nil {
+			return 0, err
+		}
+		if t == nil || t.BlockedUntil == nil {
+			continue
+		}
+		if remaining := time.Until(*t.BlockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt and applies backoff or lockout
+func (g *Guard) Fail(username, ip string) error {
+	lp := g.config.LoginProtection
+	window := time.Duration(lp.Window) * time.Minute
+
+	for _, target := range g.targets(username, ip) {
+		failures, err := g.db.RecordLoginFailure(target.kind, target.key, window)
+		if err != nil {
+			return err
+		}
+
+		threshold := lp.LockoutThreshold
+		if target.kind == storage.ThrottleIP {
+			threshold = lp.IPLockoutThreshold
+		}
+
+		switch {
+		case failures >= threshold:
+			until := time.Now().Add(time.Duration(lp.LockoutDuration) * time.Minute)
+			if err := g.db.BlockLogin(target.kind, target.key, until, true); err != nil {
+				return err
+			}
+			// Attempts are refused while blocked, so every lockout here is a new one
+			g.logger.Warn("Login locked after repeated failures", map[string]interface{}{
+				"kind":     target.kind,
+				"key":      target.key,
+				"failures": failures,
+				"until":    until.UTC().Format(time.RFC3339),
+			})
+		case failures > lp.FreeAttempts:
+			if err := g.db.BlockLogin(target.kind, target.key, time.Now().Add(g.delay(failures)), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Succeed clears the account's failures after a successful login. The IP
+// keeps its count so one valid account cannot be used to reset it.
+func (g *Guard) Succeed(username string) error {
+	_, err := g.db.ResetLoginThrottle(storage.ThrottleAccount, normalize(username))
+	return err
+}
+
+// Unlock clears the failures and any lockout of an account or IP
+func (g *Guard) Unlock(kind, key string) (bool, error) {
+	if kind == storage.ThrottleAccount {
+		key = normalize(key)
+	}
+	return g.db.ResetLoginThrottle(kind, key)
+}
+
+// delay doubles the base delay for every failure past the free attempts
+func (g *Guard) delay(failures int) time.Duration {
+	lp := g.config.LoginProtection
+	exp := failures - lp.FreeAttempts - 1
+	seconds := float64(lp.BaseDelay) * math.Pow(2, float64(exp))
+	if seconds > float64(lp.MaxDelay) {
+		seconds = float64(lp.MaxDelay)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type target struct {
+	kind, key string
+}
+
+func (g *Guard) targets(username, ip string) []target {
+	targets := []target{}
+	if username = normalize(username); username != "" {
+		targets = append(targets, target{storage.ThrottleAccount, username})
+	}
+	if ip != "" {
+		targets = append(targets, target{storage.ThrottleIP, ip})
+	}
+	return targets
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,73 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sharex/internal/config"
+)
+
+func TestAcquireSerializesAttempts(t *testing.T) {
+	g := NewGuard(&config.Config{}, nil, nil)
+
+	release, err := g.Acquire(t.Context(), "Alice", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		ip       string
+		blocked  bool
+	}{
+		{"same account and IP", "alice", "192.0.2.1", true},
+		{"same account from another IP", " ALICE ", "192.0.2.2", true},
+		{"another account from the same IP", "bob", "192.0.2.1", true},
+		{"another account and IP", "bob", "192.0.2.2", false},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		other, err := g.Acquire(ctx, tt.username, tt.ip)
+		cancel()
+		if tt.blocked {
+			if err == nil {
+				other()
+				t.Errorf("%s: Acquire succeeded while the first attempt is in flight", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Acquire: %v", tt.name, err)
+			continue
+		}
+		other()
+	}
+
+	// A waiting attempt proceeds once the first is released
+	acquired := make(chan func())
+	go func() {
+		next, err := g.Acquire(t.Context(), "alice", "192.0.2.1")
+		if err != nil {
+			t.Errorf("waiting Acquire: %v", err)
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second attempt was admitted before the first was released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("second attempt was not admitted after the first was released")
+	}
+
+	if len(g.inFlight) != 0 {
+		t.Errorf("%d slots are left after every attempt was released", len(g.inFlight))
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+// Application metrics exposed on /metrics
+var (
+	HTTPRequests = NewCounter(
+		"llmstor_http_requests_total",
+		"Total HTTP requests by route, method and status.",
+		"route", "method", "status",
+	)
+	HTTPRequestDuration = NewHistogram(
+		"llmstor_http_request_duration_seconds",
+		"HTTP request latency by route, method and status.",
+		DefBuckets,
+		"route", "method", "status",
+	)
+	HTTPResponseBytes = NewCounter(
+		"llmstor_http_response_bytes_total",
+		"Total response bytes served by route.",
+		"route",
+	)
+	Uploads = NewCounter(
+		"llmstor_uploads_total",
+		"Total successful uploads.",
+	)
+	UploadBytes = NewCounter(
+		"llmstor_upload_bytes_total",
+		"Total bytes of successful uploads.",
+	)
+	UploadRejections = NewCounter(
+		"llmstor_upload_rejections_total",
+		"Uploads rejected by content validation or the malware scanner by reason.",
+		"reason",
+	)
+	StorageUsedBytes = NewGauge(
+		"llmstor_storage_used_bytes",
+		"Bytes used under storage.base_path, measured at most every five minutes.",
+	)
+	StorageMaxBytes = NewGauge(
+		"llmstor_storage_max_bytes",
+		"Configured max_storage in bytes, -1 when storage is FULL.",
+	)
+	FsckIssues = NewGauge(
+		"llmstor_fsck_issues",
+		"Issues found by the last storage check by kind.",
+		"kind",
+	)
+	BackupLastSuccess = NewGauge(
+		"llmstor_backup_last_success_timestamp_seconds",
+		"Unix time of the last successful backup.",
+	)
+	BackupFailures = NewCounter(
+		"llmstor_backup_failures_total",
+		"Total failed backups.",
+	)
+	RateLimitRejections = NewCounter(
+		"llmstor_rate_limit_rejections_total",
+		"Requests rejected by the rate limiter by route.",
+		"route",
+	)
+	GeoIPFailures = NewCounter(
+		"llmstor_geoip_failures_total",
+		"Failed IP geolocation lookups.",
+	)
+	DBQueryDuration = NewHistogram(
+		"llmstor_db_query_duration_seconds",
+		"Database query latency by operation.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		"operation",
+	)
+)
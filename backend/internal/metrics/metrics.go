@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default latency buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is anything the registry can render in Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write renders every registered metric in Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Default is the registry metrics created by this package are added to
+var Default = &Registry{}
+
+// series is a set of values keyed by their joined label values
+type series struct {
+	mu     sync.Mutex
+	labels []string
+	keys   []string
+	values map[string][]string
+}
+
+func newSeries(labels []string) series {
+	return series{labels: labels, values: make(map[string][]string)}
+}
+
+// key returns the map key for a set of label values, recording new ones
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := s.values[key]; !ok {
+		s.values[key] = append([]string(nil), labelValues...)
+		s.keys = append(s.keys, key)
+		sort.Strings(s.keys)
+	}
+	return key
+}
+
+// labelString renders label pairs, with an optional extra pair for buckets
+func (s *series) labelString(key string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range s.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(s.values[key][i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value partitioned by labels
+type Counter struct {
+	name, help string
+	series
+	counts map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, series: newSeries(labels), counts: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+// Add increases the counter for the given label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(labelValues)] += v
+}
+
+// Inc increases the counter by one for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.keys) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(key, "", ""), formatFloat(c.counts[key]))
+	}
+}
+
+// Gauge is a value that can go up and down, partitioned by labels
+type Gauge struct {
+	name, help string
+	series
+	gauges map[string]float64
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{name: name, help: help, series: newSeries(labels), gauges: make(map[string]float64)}
+	Default.register(g)
+	return g
+}
+
+// Set sets the gauge for the given label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(labelValues)] = v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, key := range g.keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(key, "", ""), formatFloat(g.gauges[key]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets, partitioned by labels
+type Histogram struct {
+	name, help string
+	buckets    []float64
+	series
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		series:  newSeries(labels),
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records a single observation for the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.key(labelValues)
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range h.keys {
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", formatFloat(bound)), h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(key, "", ""), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(key, "", ""), h.totals[key])
+	}
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
@@ -1,14 +1,19 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"sharex/internal/config"
+	"sharex/internal/models"
+	"sharex/internal/storage"
 	"sharex/internal/utils"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // AuthMiddleware handles authentication for protected routes
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+func AuthMiddleware(cfg *config.Config, db *storage.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip auth for non-API routes (frontend routes)
@@ -17,11 +22,21 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Uploads check their key themselves. A valid one is recorded so
+			// token-keyed rate limits count against it and not whatever a
+			// client sends.
+			if r.URL.Path == "/api/upload" {
+				next.ServeHTTP(w, checkUploadCredential(r, cfg, db))
+				return
+			}
+
 			// Skip auth for public API routes
 			if r.URL.Path == "/api/login" ||
+				r.URL.Path == "/api/login/2fa" ||
+				r.URL.Path == "/api/oidc/login" ||
+				r.URL.Path == "/api/oidc/callback" ||
 				r.URL.Path == "/api/verify" ||
-				r.URL.Path == "/api/refresh" ||
-				r.URL.Path == "/api/upload" {
+				r.URL.Path == "/api/refresh" {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -33,40 +48,11 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Validate access token
+			// Validate access token. Expired tokens are not renewed here, clients
+			// call /api/refresh so refresh tokens are only rotated in one place.
 			claims, err := utils.ValidateToken(accessToken, cfg.App.JWTSecret)
 			if err != nil {
-				// Try to refresh the token
-				refreshToken := utils.GetTokenFromCookie(r, "refresh_token")
-				if refreshToken == "" {
-					utils.SendUnauthorized(w, "No refresh token found")
-					return
-				}
-
-				// Validate refresh token
-				refreshClaims, err := utils.ValidateToken(refreshToken, cfg.App.JWTSecret)
-				if err != nil || refreshClaims.TokenType != utils.RefreshToken {
-					utils.SendUnauthorized(w, "Invalid refresh token")
-					return
-				}
-
-				// Generate new token pair
-				newAccessToken, newRefreshToken, err := utils.GenerateTokenPair(refreshClaims.Username, cfg.App.JWTSecret)
-				if err != nil {
-					utils.SendInternalError(w, "Failed to generate new tokens")
-					return
-				}
-
-				// Set new cookies
-				utils.SetTokenCookies(w, newAccessToken, newRefreshToken, cfg.App.Environment == "production")
-
-				// Update request with new token
-				r.AddCookie(&http.Cookie{
-					Name:  "access_token",
-					Value: newAccessToken,
-				})
-
-				next.ServeHTTP(w, r)
+				utils.SendUnauthorized(w, "Access token expired or invalid")
 				return
 			}
 
@@ -75,8 +61,81 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Revoked sessions lose access immediately rather than when the
+			// access token expires, and role changes apply to the next request
+			role, err := db.SessionRole(claims.SessionID)
+			if err != nil {
+				utils.SendInternalError(w, "Failed to check session")
+				return
+			}
+			if role == "" {
+				utils.SendUnauthorized(w, "Session revoked")
+				return
+			}
+			if role != models.RoleAdmin && !isSelfServiceRoute(r.URL.Path) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
 			// Token is valid, proceed
-			next.ServeHTTP(w, r)
+			var expires time.Time
+			if claims.ExpiresAt != nil {
+				expires = claims.ExpiresAt.Time
+			}
+			next.ServeHTTP(w, withSession(r, claims.Username, claims.SessionID, expires))
 		})
 	}
 }
+
+// selfServiceRoutes are the API routes every signed-in user may call, to
+// manage their own sessions and two-factor login. The rest manage the shared
+// library, its analytics and the server, and need the admin role. Patterns
+// ending in a slash cover the paths below them.
+var selfServiceRoutes = []string{
+	"/api/logout",
+	"/api/config",
+	"/api/sessions",
+	"/api/sessions/",
+	"/api/2fa",
+	"/api/2fa/setup",
+	"/api/2fa/enable",
+	"/api/2fa/disable",
+}
+
+func isSelfServiceRoute(path string) bool {
+	for _, route := range selfServiceRoutes {
+		if path == route || (strings.HasSuffix(route, "/") && strings.HasPrefix(path, route)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUploadCredential records the upload key or API token a request
+// presents without reading the body: the upload_key cookie, a key query
+// parameter or a bearer token. Invalid credentials are not recorded, the
+// upload handler rejects them.
+func checkUploadCredential(r *http.Request, cfg *config.Config, db *storage.DB) *http.Request {
+	uploadKey := strings.TrimSpace(strings.Trim(cfg.App.UploadKey, `"'`))
+	candidates := []string{r.URL.Query().Get("key")}
+	if cookie, err := r.Cookie("upload_key"); err == nil {
+		candidates = append(candidates, cookie.Value)
+	}
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate != "" && subtle.ConstantTimeCompare([]byte(candidate), []byte(uploadKey)) == 1 {
+			return withCredential(r, "upload_key")
+		}
+	}
+
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		candidates = append(candidates, strings.TrimSpace(bearer))
+	}
+	for _, candidate := range candidates {
+		// A failed lookup leaves the request counted per IP
+		if id, ok, err := db.FindAPIToken(strings.TrimSpace(candidate)); err == nil && ok {
+			return withCredential(r, "api_token:"+strconv.FormatInt(id, 10))
+		}
+	}
+	return r
+}
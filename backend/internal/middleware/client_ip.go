@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"sharex/internal/utils"
+)
+
+// ClientIPMiddleware resolves the client IP once per request and stores it in
+// the request context for every later middleware and handler
+func ClientIPMiddleware(resolver *utils.IPResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, utils.WithClientIP(r, resolver.Resolve(r)))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type contextKey string
+
+const (
+	usernameKey   contextKey = "username"
+	sessionIDKey  contextKey = "session_id"
+	credentialKey contextKey = "credential"
+	expiresKey    contextKey = "expires"
+)
+
+// withSession returns a copy of the request carrying the authenticated
+// username and session, and when the access token expires
+func withSession(r *http.Request, username, sessionID string, expires time.Time) *http.Request {
+	ctx := context.WithValue(r.Context(), usernameKey, username)
+	ctx = context.WithValue(ctx, sessionIDKey, sessionID)
+	ctx = context.WithValue(ctx, expiresKey, expires)
+	return r.WithContext(ctx)
+}
+
+// GetUsername returns the username AuthMiddleware authenticated the request as,
+// or an empty string for public routes
+func GetUsername(r *http.Request) string {
+	username, _ := r.Context().Value(usernameKey).(string)
+	return username
+}
+
+// withCredential returns a copy of the request carrying the upload credential
+// it presented, once that was checked
+func withCredential(r *http.Request, credential string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), credentialKey, credential))
+}
+
+// GetCredential identifies the valid upload key or API token an upload
+// presented, like "upload_key" or "api_token:3". It is empty when the request
+// presented none or an invalid one.
+func GetCredential(r *http.Request) string {
+	credential, _ := r.Context().Value(credentialKey).(string)
+	return credential
+}
+
+// GetSessionID returns the server-side session of the authenticated request
+func GetSessionID(r *http.Request) string {
+	sessionID, _ := r.Context().Value(sessionIDKey).(string)
+	return sessionID
+}
+
+// GetAccessExpiry returns when the access token of the authenticated request
+// expires, or the zero time when it does not
+func GetAccessExpiry(r *http.Request) time.Time {
+	expires, _ := r.Context().Value(expiresKey).(time.Time)
+	return expires
+}
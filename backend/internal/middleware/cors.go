@@ -7,9 +7,13 @@ import (
 	"sharex/internal/config"
 )
 
-func CORSMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// CORSMiddleware answers cross-origin requests with the cors settings in
+// effect, which a reload can change
+func CORSMiddleware(configs *config.Store) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := configs.Get()
+
 			// If CORS is not enabled, just pass through
 			if !cfg.CORS.Enabled {
 				next.ServeHTTP(w, r)
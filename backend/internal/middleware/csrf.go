@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"net/http"
 	"sharex/internal/utils"
+	"strings"
 )
 
 // GenerateCSRFToken generates a new CSRF token
@@ -25,7 +26,13 @@ func CSRFMiddleware(cfg interface{}) func(http.Handler) http.Handler {
 			}
 
 			// Skip CSRF check for public endpoints that don't require prior authentication
-			if r.URL.Path == "/api/login" || r.URL.Path == "/api/upload" {
+			if r.URL.Path == "/api/login" || r.URL.Path == "/api/login/2fa" || r.URL.Path == "/api/upload" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The unlock page posts a plain HTML form and only grants access to one image
+			if strings.HasPrefix(r.URL.Path, "/unlock/") {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -2,23 +2,126 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"time"
 
 	"sharex/internal/config"
 	"sharex/internal/utils"
 )
 
+// maxLoggedBody is the largest request body copied into the debug log
+const maxLoggedBody = 64 * 1024
+
+// streamedBodyPaths hand their bodies to the handler as a stream, which
+// spools or parses them and clears the read deadline first
+var streamedBodyPaths = map[string]bool{
+	"/api/import": true,
+	"/api/upload": true,
+}
+
+// redactedBodyFields are JSON and form fields whose values never reach the log
+var redactedBodyFields = map[string]bool{
+	"password":      true,
+	"code":          true,
+	"recovery_code": true,
+}
+
+// redactedQueryParams are query parameters whose values never reach the log:
+// signed share links, upload keys and API tokens, and the single sign-on
+// callback's authorization code and state
+var redactedQueryParams = map[string]bool{
+	"sig":   true,
+	"key":   true,
+	"code":  true,
+	"state": true,
+}
+
+// logsBody reports whether the request body is small and textual enough to
+// be read ahead of the handler and logged. Other bodies are logged by size.
+func logsBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || streamedBodyPaths[r.URL.Path] {
+		return false
+	}
+	if r.ContentLength < 0 || r.ContentLength > maxLoggedBody {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json" || mediaType == "application/x-www-form-urlencoded"
+}
+
+// redactBody returns a logged body with the values of redactedBodyFields
+// replaced. A body that cannot be parsed is not logged at all, as it may hold
+// one of them.
+func redactBody(contentType string, body []byte) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", false
+		}
+		for key := range values {
+			if redactedBodyFields[key] {
+				values[key] = []string{"[REDACTED]"}
+			}
+		}
+		return values.Encode(), true
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", false
+	}
+	redacted, err := json.Marshal(redactJSON(value))
+	if err != nil {
+		return "", false
+	}
+	return string(redacted), true
+}
+
+// redactQuery returns the request's query values with those of
+// redactedQueryParams replaced
+func redactQuery(query url.Values) url.Values {
+	for key := range query {
+		if redactedQueryParams[key] {
+			query[key] = []string{"[REDACTED]"}
+		}
+	}
+	return query
+}
+
+// redactJSON replaces the values of redactedBodyFields at any depth
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redactedBodyFields[key] {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return value
+}
+
 // LoggingMiddleware creates a middleware that logs all incoming requests
 func LoggingMiddleware(cfg *config.Config, logger *utils.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Read request body if it exists
+			// Read small request bodies, large and streamed ones only by size
 			var bodyBytes []byte
-			if r.Body != nil {
+			captured := logsBody(r)
+			if captured {
 				bodyBytes, _ = io.ReadAll(r.Body)
 				// Restore the body for the next handler
 				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
@@ -37,25 +140,37 @@ func LoggingMiddleware(cfg *config.Config, logger *utils.Logger) func(http.Handl
 			duration := time.Since(start)
 
 			// Log the request
-			logger.Debug("Incoming request", map[string]interface{}{
+			fields := map[string]interface{}{
 				"method":     r.Method,
 				"path":       r.URL.Path,
-				"query":      r.URL.Query(),
+				"query":      redactQuery(r.URL.Query()),
 				"headers":    sanitizeHeaders(r.Header, cfg.App.Environment),
-				"body":       string(bodyBytes),
 				"status":     rw.statusCode,
 				"duration":   duration.String(),
-				"remote_ip":  r.RemoteAddr,
+				"remote_ip":  utils.ClientIP(r),
+				"peer_addr":  r.RemoteAddr,
 				"user_agent": r.UserAgent(),
-			})
+			}
+			var body string
+			if captured {
+				body, captured = redactBody(r.Header.Get("Content-Type"), bodyBytes)
+			}
+			if captured {
+				fields["body"] = body
+			} else if r.ContentLength != 0 {
+				fields["body_size"] = r.ContentLength
+			}
+			logger.Debug("Incoming request", fields)
 		})
 	}
 }
 
 // responseWriter is a custom response writer that captures the status code
+// and the number of body bytes written
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 // WriteHeader captures the status code before writing it
@@ -64,6 +179,19 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Write counts the body bytes before writing them
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer so http.ResponseController can reach
+// optional interfaces such as http.Flusher
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // sanitizeHeaders removes sensitive information from headers
 func sanitizeHeaders(headers http.Header, environment string) map[string]string {
 	sanitized := make(map[string]string)
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"sharex/internal/metrics"
+)
+
+// MetricsMiddleware records request counts, latency and response bytes by route.
+// Routes are labelled with the mux pattern so the label set stays bounded.
+func MetricsMiddleware(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+
+			rw := &responseWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(rw, r)
+
+			status := strconv.Itoa(rw.statusCode)
+			method := methodLabel(r.Method)
+			metrics.HTTPRequests.Inc(route, method, status)
+			metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, method, status)
+			metrics.HTTPResponseBytes.Add(float64(rw.bytes), route)
+		})
+	}
+}
+
+// methodLabel returns the method of a request as a label. Any token is a
+// valid method, so clients could otherwise create unlimited series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
@@ -3,128 +3,305 @@ package middleware
 import (
 	"context"
 	"fmt"
-	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"sharex/internal/config"
+	"sharex/internal/metrics"
+	"sharex/internal/ratelimit"
 	"sharex/internal/utils"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// retireDelay is how long a store replaced by a reload stays open before it
+// is closed, so a reload that is rolled back can take it back
+const retireDelay = 5 * time.Second
+
 type RateLimiter struct {
-	client *redis.Client
-	config *config.Config
+	mu     sync.Mutex // serializes reloads and closing retired stores
+	state  atomic.Pointer[limiterState]
 	logger *utils.Logger
-	ctx    context.Context
+
+	// The last reload, kept so a rollback restores the state it replaced
+	lastFrom, lastTo *config.Config
+	lastReplaced     *limiterState
+}
+
+// limiterState is what the rate_limit section makes of the limiter. A reload
+// replaces it as a whole.
+type limiterState struct {
+	config   config.RateLimitConfig
+	store    ratelimit.Store // nil when rate limiting is disabled
+	users    *storeUsers     // shared by every state with the same store
+	policies *ratelimit.Policies
+}
+
+// storeUsers counts the requests checking a limit in a store, which is not
+// closed before they are done
+type storeUsers struct {
+	n         atomic.Int64
+	closeOnce sync.Once
 }
 
 func NewRateLimiter(cfg *config.Config, logger *utils.Logger) (*RateLimiter, error) {
-	if !cfg.RateLimit.Enabled {
-		logger.Info("Rate limiting is disabled", nil)
-		return &RateLimiter{
-			config: cfg,
-			logger: logger,
-		}, nil
+	rl := &RateLimiter{logger: logger}
+	state, err := rl.newState(cfg.RateLimit, nil)
+	if err != nil {
+		return nil, err
 	}
+	rl.state.Store(state)
+	return rl, nil
+}
+
+// Reload applies a new rate_limit section. Counters survive when the store
+// settings are unchanged, otherwise a new store is connected and the old one
+// is closed once the requests using it are done. Rolling back the last
+// reload restores the state it replaced, counters included.
+func (rl *RateLimiter) Reload(old, next *config.Config) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
-	// Parse Redis URL
-	redisURL := strings.TrimPrefix(cfg.RateLimit.RedisURL, "redis://")
-	if redisURL == "" {
-		redisURL = "localhost:6379"
+	current := rl.state.Load()
+	var state *limiterState
+	if rl.lastReplaced != nil && old == rl.lastTo && next == rl.lastFrom {
+		state = rl.lastReplaced
+		rl.lastFrom, rl.lastTo, rl.lastReplaced = nil, nil, nil
+	} else {
+		var err error
+		state, err = rl.newState(next.RateLimit, current)
+		if err != nil {
+			return err
+		}
+		rl.lastFrom, rl.lastTo, rl.lastReplaced = old, next, current
 	}
 
-	logger.Info("Initializing Redis connection", map[string]interface{}{
-		"url": redisURL,
-	})
+	rl.state.Store(state)
+	if current.store != nil && current.store != state.store {
+		rl.retire(current)
+	}
+	return nil
+}
+
+// retire closes the store of a replaced state after retireDelay, once no
+// request uses it anymore and no rollback took it back
+func (rl *RateLimiter) retire(state *limiterState) {
+	go func() {
+		time.Sleep(retireDelay)
+		for state.users.n.Load() > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
 
-	// Create Redis client
-	client := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: "", // no password set
-		DB:       0,  // use default DB
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		if rl.state.Load().store == state.store {
+			return
+		}
+		state.users.closeOnce.Do(func() {
+			if err := state.store.Close(); err != nil {
+				rl.logger.Warn("Failed to close replaced rate limit store", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+		})
+	}()
+}
+
+// acquire returns the state in effect and counts the request as a user of
+// its store until release. Checking the state again after counting makes
+// sure a store retired in between is not used.
+func (rl *RateLimiter) acquire() *limiterState {
+	for {
+		state := rl.state.Load()
+		if state.store == nil {
+			return state
+		}
+		state.users.n.Add(1)
+		if rl.state.Load() == state {
+			return state
+		}
+		state.users.n.Add(-1)
+	}
+}
+
+func (state *limiterState) release() {
+	if state.store != nil {
+		state.users.n.Add(-1)
+	}
+}
+
+// newState compiles the policies of cfg and connects its store, reusing the
+// store of current when the store settings match
+func (rl *RateLimiter) newState(cfg config.RateLimitConfig, current *limiterState) (*limiterState, error) {
+	if !cfg.Enabled {
+		rl.logger.Info("Rate limiting is disabled", nil)
+		return &limiterState{config: cfg}, nil
+	}
+
+	policies, err := ratelimit.NewPolicies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	state := &limiterState{config: cfg, policies: policies}
+
+	if current != nil && current.store != nil && sameStore(current.config, cfg) {
+		state.store = current.store
+		state.users = current.users
+		return state, nil
+	}
+	state.users = &storeUsers{}
+
+	if cfg.Store == "memory" {
+		rl.logger.Info("Using in-memory rate limit store", map[string]interface{}{
+			"algorithm": cfg.Algorithm,
+		})
+		state.store = ratelimit.NewMemoryStore(cfg.Algorithm)
+		return state, nil
+	}
+
+	opts, err := redisOptions(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate_limit.redis_url: %w", err)
+	}
+
+	rl.logger.Info("Initializing Redis connection", map[string]interface{}{
+		"url": opts.Addr,
 	})
 
-	// Test connection with timeout
+	client := redis.NewClient(opts)
+
+	// Test connection with timeout. An unreachable Redis is not fatal, the
+	// fallback policy applies until it comes back.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
-		logger.Error("Failed to connect to Redis", map[string]interface{}{
-			"error": err.Error(),
-			"url":   redisURL,
+		rl.logger.Warn("Failed to connect to Redis, using rate limit fallback", map[string]interface{}{
+			"error":    err.Error(),
+			"url":      opts.Addr,
+			"fallback": cfg.Fallback,
+		})
+	} else {
+		rl.logger.Info("Successfully connected to Redis", map[string]interface{}{
+			"url": opts.Addr,
 		})
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	logger.Info("Successfully connected to Redis", map[string]interface{}{
-		"url": redisURL,
+	primary := ratelimit.NewRedisStore(client, cfg.Algorithm)
+	state.store = ratelimit.NewFallbackStore(primary, cfg.Fallback, cfg.Algorithm, func(err error) {
+		rl.logger.Error("Rate limit store unavailable", map[string]interface{}{
+			"error":    err.Error(),
+			"fallback": cfg.Fallback,
+		})
 	})
 
-	return &RateLimiter{
-		client: client,
-		config: cfg,
-		logger: logger,
-		ctx:    context.Background(),
-	}, nil
+	return state, nil
+}
+
+// sameStore reports whether two rate_limit sections use the same store
+func sameStore(a, b config.RateLimitConfig) bool {
+	return a.Store == b.Store && a.RedisURL == b.RedisURL && a.Algorithm == b.Algorithm && a.Fallback == b.Fallback
+}
+
+// redisOptions accepts either a redis:// URL or a plain host:port
+func redisOptions(url string) (*redis.Options, error) {
+	if url == "" {
+		return &redis.Options{Addr: "localhost:6379"}, nil
+	}
+	if !strings.Contains(url, "://") {
+		return &redis.Options{Addr: url}, nil
+	}
+	return redis.ParseURL(url)
+}
+
+// PingRedis checks the Redis server the limiter counts requests in. used is
+// false when rate limiting is disabled or counts in memory, fallback is the
+// policy applied while Redis is unreachable.
+func (rl *RateLimiter) PingRedis(ctx context.Context) (used bool, fallback string, err error) {
+	state := rl.state.Load()
+	p, ok := state.store.(ratelimit.Pinger)
+	if !ok {
+		return false, "", nil
+	}
+	return true, state.config.Fallback, p.Ping(ctx)
 }
 
 func (rl *RateLimiter) Close() error {
-	if rl.client != nil {
-		return rl.client.Close()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var err error
+	if state := rl.state.Load(); state.store != nil {
+		state.users.closeOnce.Do(func() { err = state.store.Close() })
 	}
-	return nil
+	return err
 }
 
 func (rl *RateLimiter) RateLimitMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip rate limiting if disabled
-			if !rl.config.RateLimit.Enabled || rl.client == nil {
+			state := rl.acquire()
+			if state.store == nil {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Get rate limit configuration for the route
-			route := r.URL.Path
-			requests, period := rl.getRateConfig(route)
+			// Find the policy for the route
+			policy := state.policies.Match(r.Method, r.URL.Path)
 
 			// Get client IP
-			clientIP := rl.getClientIP(r)
+			clientIP := utils.ClientIP(r)
+
+			username := GetUsername(r)
+			if state.policies.ExemptIP(clientIP) || state.policies.ExemptUser(username) {
+				state.release()
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			// Create rate limit key
-			key := fmt.Sprintf("rate_limit:%s:%s", route, clientIP)
+			// Create rate limit key, counters are shared by everything the policy matches
+			key := fmt.Sprintf("%s:%s", policy.Name, rl.identity(r, policy, username, clientIP))
 
 			rl.logger.Debug("Checking rate limit", map[string]interface{}{
 				"key":    key,
-				"route":  route,
+				"route":  r.URL.Path,
+				"policy": policy.Name,
 				"ip":     clientIP,
-				"limit":  requests,
-				"period": period,
+				"limit":  policy.Limit.Requests,
+				"period": int64(policy.Limit.Period.Seconds()),
 			})
 
 			// Check rate limit
-			allowed, err := rl.checkRateLimit(key, requests, period)
+			result, err := state.store.Allow(r.Context(), key, policy.Limit)
+			state.release()
 			if err != nil {
 				rl.logger.Error("Rate limit check failed", map[string]interface{}{
 					"error": err.Error(),
-					"route": route,
+					"route": r.URL.Path,
 					"ip":    clientIP,
 				})
-				// On Redis error, allow the request but log the error
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			if !allowed {
+			setRateLimitHeaders(w, policy.Limit, result)
+
+			if !result.Allowed {
 				rl.logger.Warn("Rate limit exceeded", map[string]interface{}{
-					"route":  route,
+					"route":  r.URL.Path,
+					"policy": policy.Name,
+					"key":    key,
 					"ip":     clientIP,
-					"limit":  requests,
-					"period": period,
+					"limit":  policy.Limit.Requests,
+					"period": int64(policy.Limit.Period.Seconds()),
 				})
+				metrics.RateLimitRejections.Inc(policy.Name)
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
 				http.Error(w, "Too many requests", http.StatusTooManyRequests)
 				return
 			}
@@ -134,64 +311,40 @@ func (rl *RateLimiter) RateLimitMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
-func (rl *RateLimiter) getClientIP(r *http.Request) string {
-	// Try X-Forwarded-For first
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ips := strings.Split(forwardedFor, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	// Try X-Real-IP
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return realIP
+// setRateLimitHeaders reports the limit state using the IETF RateLimit header fields
+func setRateLimitHeaders(w http.ResponseWriter, limit ratelimit.Limit, result ratelimit.Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int64(limit.Period.Seconds()))
+	if limit.Burst > 0 {
+		policy += fmt.Sprintf(";burst=%d", limit.Burst)
 	}
-
-	// Get from RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	h.Set("RateLimit-Policy", policy)
 }
 
-func (rl *RateLimiter) getRateConfig(route string) (requests int, period int64) {
-	// Check if there's a specific configuration for this route
-	if routeConfig, exists := rl.config.RateLimit.Routes[route]; exists {
-		return routeConfig.Requests, routeConfig.Period
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
 	}
-
-	// Use default configuration
-	return rl.config.RateLimit.DefaultRate.Requests, rl.config.RateLimit.DefaultRate.Period
+	return int64((d + time.Second - 1) / time.Second)
 }
 
-func (rl *RateLimiter) checkRateLimit(key string, requests int, period int64) (bool, error) {
-	// Get current count
-	count, err := rl.client.Get(rl.ctx, key).Int()
-	if err != nil && err != redis.Nil {
-		return false, err
-	}
-
-	// If key doesn't exist or has expired, set it
-	if err == redis.Nil {
-		err = rl.client.Set(rl.ctx, key, 1, time.Duration(period)*time.Second).Err()
-		if err != nil {
-			return false, err
+// identity returns who the request is counted against under the policy.
+// Requests without the user or a valid token the policy keys by count per IP,
+// so a client cannot get a fresh bucket by sending made-up credentials.
+func (rl *RateLimiter) identity(r *http.Request, policy *ratelimit.Policy, username, clientIP string) string {
+	switch policy.Key {
+	case ratelimit.KeyUser:
+		if username != "" {
+			return "user:" + strings.ToLower(username)
+		}
+	case ratelimit.KeyToken:
+		if credential := GetCredential(r); credential != "" {
+			return "token:" + credential
 		}
-		return true, nil
-	}
-
-	// Check if limit is exceeded
-	if count >= requests {
-		return false, nil
-	}
-
-	// Increment counter
-	err = rl.client.Incr(rl.ctx, key).Err()
-	if err != nil {
-		return false, err
 	}
-
-	return true, nil
+	return "ip:" + clientIP
 }
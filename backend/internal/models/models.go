@@ -1,25 +1,147 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// User roles
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Where a user's identity comes from
+const (
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+)
+
 type User struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"-"` // Password is not exposed in JSON
+	ID              int64  `json:"id"`
+	Username        string `json:"username"`
+	Password        string `json:"-"` // Password is not exposed in JSON
+	Role            string `json:"role"`
+	AuthProvider    string `json:"auth_provider"`
+	OIDCSubject     string `json:"-"` // Stable subject identifier from the identity provider
+	TOTPSecret      string `json:"-"` // Pending until TOTPEnabled is set
+	TOTPEnabled     bool   `json:"totp_enabled"`
+	TOTPLastCounter int64  `json:"-"` // Last accepted time step, codes at or before it are rejected
+	Disabled        bool   `json:"disabled"`
+}
+
+// IsAdmin reports whether the user may manage settings and other users
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Session is one login, shared by every refresh token rotated from it
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// LoginThrottle tracks failed logins for an account or a client IP
+type LoginThrottle struct {
+	Kind          string     `json:"kind"` // "account" or "ip"
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+	Locked        bool       `json:"locked"` // true for a lockout, false for a backoff delay
+}
+
+// Audit actions
+const (
+	AuditLogin           = "auth.login"
+	AuditLoginFailed     = "auth.login_failed"
+	AuditLogout          = "auth.logout"
+	AuditSessionRevoked  = "session.revoke"
+	AuditSessionsRevoked = "session.revoke_others"
+	AuditTokenReused     = "session.token_reused"
+	AuditTwoFactorEnable = "2fa.enable"
+	AuditTwoFactorOff    = "2fa.disable"
+	AuditTwoFactorReset  = "2fa.reset"
+	AuditUserCreate      = "user.create"
+	AuditUserUpdate      = "user.update"
+	AuditLockoutClear    = "lockout.clear"
+	AuditImageDelete     = "image.delete"
+	AuditImagePrivacy    = "image.privacy"
+	AuditWebhookCreate   = "webhook.create"
+	AuditWebhookDelete   = "webhook.delete"
+	AuditAnalyticsErase  = "analytics.erase"
+	AuditUploadFlagged   = "upload.quarantine"
+	AuditQuarantineClear = "quarantine.delete"
+	AuditTokenCreate     = "token.create"
+	AuditTokenRevoke     = "token.revoke"
+	AuditStorageRepair   = "storage.repair"
+	AuditLibraryExport   = "library.export"
+	AuditLibraryImport   = "library.import"
+	AuditConfigReload    = "config.reload"
+)
+
+// AuditEvent is one entry of the append-only audit log. Each entry's hash
+// covers its fields and the previous entry's hash, so editing or removing a
+// row breaks the chain from that point on.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Actor      string          `json:"actor"` // username, or the attempted one for failed logins
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	Target     string          `json:"target"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// QuarantinedUpload is an upload the malware scanner flagged. The file is kept
+// outside the storage path so it is never served.
+type QuarantinedUpload struct {
+	ID            int64     `json:"id"`
+	Filename      string    `json:"filename"`
+	Extension     string    `json:"extension"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	Path          string    `json:"-"`
+	Scanner       string    `json:"scanner"`
+	Signature     string    `json:"signature"`
+	IP            string    `json:"ip"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// APIToken lets scripts and upload clients authenticate without the shared
+// upload key. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
 }
 
 type Image struct {
-	ID         int64     `json:"id"`
-	UUID       string    `json:"uuid"`
-	Filename   string    `json:"filename"`
-	Extension  string    `json:"extension"`
-	Size       int64     `json:"size"`
-	UploadedAt time.Time `json:"uploaded_at"`
-	IsPrivate  bool      `json:"is_private"`
-	PrivateKey string    `json:"private_key,omitempty"`
-	Views      int64     `json:"views"`
+	ID           int64     `json:"id"`
+	UUID         string    `json:"uuid"`
+	Filename     string    `json:"filename"`
+	Extension    string    `json:"extension"`
+	Size         int64     `json:"size"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	IsPrivate    bool      `json:"is_private"`
+	PasswordHash string    `json:"-"` // viewer password of a private image, stored in images.private_key
+	Views        int64     `json:"views"`
+	SHA256       string    `json:"sha256"`  // checksum of the content, empty for images stored before checksums were kept
+	Missing      bool      `json:"missing"` // the storage check found no file for this image
 }
 
 type ImageView struct {
@@ -51,6 +173,20 @@ type LoginResponse struct {
 	Username string `json:"username"`
 }
 
+// TwoFactorChallengeResponse is returned by the password step of a login when
+// the account has two-factor authentication enabled
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"` // seconds
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
 type UploadResponse struct {
 	UUID       string `json:"uuid"`
 	Filename   string `json:"filename"`
@@ -76,9 +212,40 @@ type ViewsData struct {
 	Views int64  `json:"views"`
 }
 
+// ViewAggregate is the number of views an image had on one day from one
+// country
+type ViewAggregate struct {
+	Date    string `json:"date"`
+	Country string `json:"country"`
+	Views   int64  `json:"views"`
+}
+
 type CountryViews struct {
 	Country    string  `json:"country"`
 	Code       string  `json:"code"`
 	Views      int64   `json:"views"`
 	Percentage float64 `json:"percentage"`
 }
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // Only returned when the webhook is created
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64     `json:"id"`
+	WebhookID      int64     `json:"webhook_id"`
+	EventType      string    `json:"event_type"`
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"` // pending, succeeded or failed
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastStatusCode int       `json:"last_status_code,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -0,0 +1,63 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// StateTTL is how long a user has to complete the login at the identity provider
+const StateTTL = 10 * time.Minute
+
+// LoginState is what the login request remembers for the callback. It is kept
+// in a signed cookie so no server-side session is needed.
+type LoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// NewLoginState generates fresh state, nonce and PKCE verifier values
+func NewLoginState() (*LoginState, error) {
+	var values [3]string
+	for i := range values {
+		v, err := RandomString()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return &LoginState{
+		State:    values[0],
+		Nonce:    values[1],
+		Verifier: values[2],
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "oidc_login",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(StateTTL)),
+		},
+	}, nil
+}
+
+// Sign encodes the state for the cookie
+func (s *LoginState) Sign(secret string) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, s).SignedString([]byte(secret))
+}
+
+// ParseLoginState verifies a state cookie and checks it belongs to the
+// state returned by the identity provider
+func ParseLoginState(signed, state, secret string) (*LoginState, error) {
+	s := &LoginState{}
+	_, err := jwt.ParseWithClaims(signed, s, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithSubject("oidc_login"))
+	if err != nil {
+		return nil, err
+	}
+	if s.State == "" || s.State != state {
+		return nil, errors.New("state mismatch")
+	}
+	return s, nil
+}
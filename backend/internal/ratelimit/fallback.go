@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// What to do with requests while the primary store is unreachable
+const (
+	FallbackMemory = "memory" // count in process until the primary is back
+	FallbackAllow  = "allow"  // let every request through
+	FallbackDeny   = "deny"   // reject every request
+)
+
+// FallbackStore uses the primary store and switches to the fallback policy
+// for as long as the primary returns errors
+type FallbackStore struct {
+	primary Store
+	memory  *MemoryStore
+	policy  string
+	onError func(err error)
+
+	mu         sync.Mutex
+	lastReport time.Time
+}
+
+// NewFallbackStore wraps primary. onError is called at most once per 30
+// seconds while the primary is failing.
+func NewFallbackStore(primary Store, policy, algorithm string, onError func(err error)) *FallbackStore {
+	s := &FallbackStore{
+		primary: primary,
+		policy:  policy,
+		onError: onError,
+	}
+	if policy == FallbackMemory {
+		s.memory = NewMemoryStore(algorithm)
+	}
+	return s
+}
+
+func (s *FallbackStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	res, err := s.primary.Allow(ctx, key, limit)
+	if err == nil {
+		return res, nil
+	}
+
+	s.report(err)
+
+	switch s.policy {
+	case FallbackMemory:
+		return s.memory.Allow(ctx, key, limit)
+	case FallbackDeny:
+		return Result{
+			Limit:      limit.max(),
+			Reset:      limit.Period,
+			RetryAfter: limit.Period,
+		}, nil
+	default:
+		return Result{
+			Allowed:   true,
+			Limit:     limit.max(),
+			Remaining: limit.max(),
+		}, nil
+	}
+}
+
+func (s *FallbackStore) report(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastReport) < 30*time.Second {
+		return
+	}
+	s.lastReport = time.Now()
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Ping checks the primary store, ignoring the fallback policy
+func (s *FallbackStore) Ping(ctx context.Context) error {
+	if p, ok := s.primary.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *FallbackStore) Close() error {
+	if s.memory != nil {
+		s.memory.Close()
+	}
+	return s.primary.Close()
+}
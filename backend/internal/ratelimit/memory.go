@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process. Limits are per instance, so it suits
+// single-node deployments and serves as the fallback when Redis is down.
+type MemoryStore struct {
+	algorithm string
+
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type memoryEntry struct {
+	// sliding window
+	window int64
+	curr   int64
+	prev   int64
+
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	expires time.Time
+}
+
+func NewMemoryStore(algorithm string) *MemoryStore {
+	s := &MemoryStore{
+		algorithm: algorithm,
+		entries:   make(map[string]*memoryEntry),
+		stopChan:  make(chan struct{}),
+	}
+	go s.cleanup()
+	return s
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{tokens: tokenBucketCapacity(limit), last: now}
+		s.entries[key] = e
+	}
+
+	if s.algorithm == TokenBucket {
+		return s.allowTokenBucket(e, limit, now), nil
+	}
+	return s.allowSlidingWindow(e, limit, now), nil
+}
+
+func (s *MemoryStore) allowSlidingWindow(e *memoryEntry, limit Limit, now time.Time) Result {
+	period := limit.Period.Nanoseconds()
+	window := now.UnixNano() / period
+	elapsed := time.Duration(now.UnixNano() - window*period)
+
+	switch {
+	case window == e.window+1:
+		e.prev, e.curr = e.curr, 0
+	case window != e.window:
+		e.prev, e.curr = 0, 0
+	}
+	e.window = window
+	e.expires = now.Add(2 * limit.Period)
+
+	estimate := float64(e.prev)*float64(limit.Period-elapsed)/float64(limit.Period) + float64(e.curr)
+	allowed := estimate < float64(limit.max())
+	if allowed {
+		e.curr++
+	}
+	return slidingWindowResult(limit, allowed, e.curr, e.prev, elapsed)
+}
+
+func (s *MemoryStore) allowTokenBucket(e *memoryEntry, limit Limit, now time.Time) Result {
+	capacity := tokenBucketCapacity(limit)
+	e.tokens = math.Min(capacity, e.tokens+float64(now.Sub(e.last))*tokenBucketRate(limit))
+	e.last = now
+
+	allowed := e.tokens >= 1
+	if allowed {
+		e.tokens--
+	}
+	e.expires = now.Add(time.Duration((capacity - e.tokens) / tokenBucketRate(limit)))
+	return tokenBucketResult(limit, allowed, e.tokens)
+}
+
+// cleanup drops entries that have returned to their initial state
+func (s *MemoryStore) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for key, e := range s.entries {
+				if now.After(e.expires) {
+					delete(s.entries, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+	return nil
+}
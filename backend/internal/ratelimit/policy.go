@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"sharex/internal/config"
+)
+
+// Identities a policy can count requests by. Requests without a user or a
+// valid upload key or API token fall back to the client IP.
+const (
+	KeyIP    = "ip"
+	KeyUser  = "user"
+	KeyToken = "token"
+)
+
+// Policy is a compiled rate_limit.routes entry
+type Policy struct {
+	Name   string // the configured pattern, also used to group counters
+	Method string
+	Path   string
+	Limit  Limit
+	Key    string
+
+	glob bool
+}
+
+// Policies matches requests to the most specific configured policy
+type Policies struct {
+	rules       []*Policy
+	fallback    *Policy
+	exemptNets  []*net.IPNet
+	exemptUsers map[string]bool
+}
+
+// NewPolicies compiles the rate_limit section. Patterns are either a path,
+// which also covers everything below it, a path ending in "/" for a pure
+// prefix, or a glob where "*" matches one segment and "**" any number of
+// them. A leading method such as "POST /api/upload" restricts the rule to it.
+func NewPolicies(cfg config.RateLimitConfig) (*Policies, error) {
+	p := &Policies{
+		fallback:    newPolicy("default", "", "", cfg.DefaultRate),
+		exemptUsers: make(map[string]bool),
+	}
+
+	for pattern, rule := range cfg.Routes {
+		method, routePath := "", pattern
+		if m, rest, ok := strings.Cut(pattern, " "); ok {
+			method, routePath = m, strings.TrimSpace(rest)
+		}
+		policy := newPolicy(pattern, method, routePath, rule)
+		p.rules = append(p.rules, policy)
+	}
+	sort.Slice(p.rules, func(i, j int) bool {
+		return p.rules[i].specificity() > p.rules[j].specificity() ||
+			p.rules[i].specificity() == p.rules[j].specificity() && p.rules[i].Name < p.rules[j].Name
+	})
+
+	for _, entry := range cfg.ExemptIPs {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt IP %q: %w", entry, err)
+		}
+		p.exemptNets = append(p.exemptNets, ipNet)
+	}
+	for _, user := range cfg.ExemptUsers {
+		p.exemptUsers[strings.ToLower(strings.TrimSpace(user))] = true
+	}
+
+	return p, nil
+}
+
+func newPolicy(name, method, routePath string, rule config.RateLimitRule) *Policy {
+	return &Policy{
+		Name:   name,
+		Method: method,
+		Path:   routePath,
+		Limit: Limit{
+			Requests: rule.Requests,
+			Period:   time.Duration(rule.Period) * time.Second,
+			Burst:    rule.Burst,
+		},
+		Key:  rule.Key,
+		glob: strings.ContainsAny(routePath, "*?["),
+	}
+}
+
+// Match returns the policy for a request, the default one if no route matches
+func (p *Policies) Match(method, requestPath string) *Policy {
+	for _, policy := range p.rules {
+		if policy.matches(method, requestPath) {
+			return policy
+		}
+	}
+	return p.fallback
+}
+
+// Rules returns the route policies in the order they are tried
+func (p *Policies) Rules() []*Policy {
+	return p.rules
+}
+
+// Default returns the policy for requests no route matches
+func (p *Policies) Default() *Policy {
+	return p.fallback
+}
+
+// ExemptIP reports whether the client IP is on the exemption list
+func (p *Policies) ExemptIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range p.exemptNets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExemptUser reports whether the authenticated user is on the exemption list
+func (p *Policies) ExemptUser(username string) bool {
+	return username != "" && p.exemptUsers[strings.ToLower(username)]
+}
+
+func (p *Policy) matches(method, requestPath string) bool {
+	if p.Method != "" && p.Method != method && !(p.Method == "GET" && method == "HEAD") {
+		return false
+	}
+	switch {
+	case p.glob:
+		return matchGlob(strings.Split(p.Path, "/"), strings.Split(requestPath, "/"))
+	case strings.HasSuffix(p.Path, "/"):
+		return strings.HasPrefix(requestPath, p.Path)
+	default:
+		return requestPath == p.Path || strings.HasPrefix(requestPath, p.Path+"/")
+	}
+}
+
+// specificity orders policies so the most precise match wins: method rules
+// before any-method rules, then literal paths before globs, then longer paths
+func (p *Policy) specificity() int {
+	score := len(p.Path)
+	if !p.glob {
+		score += 1 << 12
+	}
+	if p.Method != "" {
+		score += 1 << 13
+	}
+	return score
+}
+
+// String describes the policy for the startup banner
+func (p *Policy) String() string {
+	s := fmt.Sprintf("%d requests per %d seconds", p.Limit.Requests, int64(p.Limit.Period.Seconds()))
+	if p.Limit.Burst > 0 {
+		s += fmt.Sprintf(" (burst %d)", p.Limit.Burst)
+	}
+	return s + " by " + p.Key
+}
+
+// matchGlob matches path segments, "**" standing for zero or more of them
+func matchGlob(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchGlob(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
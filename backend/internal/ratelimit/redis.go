@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Both scripts read, decide and write in one step so concurrent requests
+// cannot race past the limit. The current time is passed in milliseconds.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local window = math.floor(now / period)
+local elapsed = now - window * period
+local curr_key = KEYS[1] .. ":" .. window
+local prev_key = KEYS[1] .. ":" .. (window - 1)
+
+local curr = tonumber(redis.call("GET", curr_key) or "0")
+local prev = tonumber(redis.call("GET", prev_key) or "0")
+
+local allowed = 0
+if prev * (period - elapsed) / period + curr < limit then
+	curr = redis.call("INCR", curr_key)
+	redis.call("PEXPIRE", curr_key, period * 2)
+	allowed = 1
+end
+
+return {allowed, curr, prev, elapsed}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore shares counters between instances through Redis
+type RedisStore struct {
+	client    *redis.Client
+	algorithm string
+	prefix    string
+}
+
+func NewRedisStore(client *redis.Client, algorithm string) *RedisStore {
+	return &RedisStore{
+		client:    client,
+		algorithm: algorithm,
+		prefix:    "rate_limit:",
+	}
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now().UnixMilli()
+	key = s.prefix + key
+
+	if s.algorithm == TokenBucket {
+		ratePerMs := tokenBucketRate(limit) * float64(time.Millisecond)
+		values, err := tokenBucketScript.Run(ctx, s.client, []string{key},
+			tokenBucketCapacity(limit), strconv.FormatFloat(ratePerMs, 'f', -1, 64), now).Slice()
+		if err != nil {
+			return Result{}, err
+		}
+		if len(values) != 2 {
+			return Result{}, fmt.Errorf("unexpected token bucket reply: %v", values)
+		}
+		tokens, err := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+		if err != nil {
+			return Result{}, err
+		}
+		return tokenBucketResult(limit, values[0] == int64(1), tokens), nil
+	}
+
+	values, err := slidingWindowScript.Run(ctx, s.client, []string{key},
+		limit.max(), limit.Period.Milliseconds(), now).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("unexpected sliding window reply: %v", values)
+	}
+	return slidingWindowResult(limit, values[0] == 1, values[1], values[2], time.Duration(values[3])*time.Millisecond), nil
+}
+
+// Ping checks the connection to Redis. It returns when ctx is done, even if
+// the client is still waiting for its read timeout.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.client.Ping(ctx).Err()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
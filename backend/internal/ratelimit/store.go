@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Algorithms a store can enforce a limit with
+const (
+	SlidingWindow = "sliding_window"
+	TokenBucket   = "token_bucket"
+)
+
+// Limit describes how many requests are allowed per period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int // extra requests that may be taken at once, on top of Requests
+}
+
+// max is the most requests a sliding window lets through
+func (l Limit) max() int {
+	return l.Requests + l.Burst
+}
+
+// Result is the outcome of a single rate-limit check
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the limit is fully available again
+	RetryAfter time.Duration // until the next request is allowed, set when denied
+}
+
+// Store counts requests per key. Implementations must be safe for concurrent
+// use and apply each check atomically.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	Close() error
+}
+
+// Pinger is implemented by stores that keep their counters in a server
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// slidingWindowResult turns the counters of the current and previous fixed
+// windows into a result. The previous window is weighted by how much of it
+// still overlaps the sliding window.
+func slidingWindowResult(limit Limit, allowed bool, curr, prev int64, elapsed time.Duration) Result {
+	period := limit.Period
+	weight := float64(period-elapsed) / float64(period)
+	estimate := float64(prev)*weight + float64(curr)
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.max(),
+		Remaining: int(math.Max(0, math.Floor(float64(limit.max())-estimate))),
+		Reset:     period - elapsed,
+	}
+	if prev > 0 {
+		res.Reset += period
+	}
+
+	if !allowed {
+		res.RetryAfter = slidingWindowRetry(limit, curr, prev, elapsed)
+	}
+	return res
+}
+
+// slidingWindowRetry computes how long until the estimate drops below the limit
+func slidingWindowRetry(limit Limit, curr, prev int64, elapsed time.Duration) time.Duration {
+	period := float64(limit.Period)
+	requests := float64(limit.max())
+
+	if float64(curr) < requests && prev > 0 {
+		// Still in the current window, once enough of the previous one has slid out
+		t := period*(1-(requests-float64(curr))/float64(prev)) - float64(elapsed)
+		if t > 0 {
+			return time.Duration(t) + time.Millisecond
+		}
+		return time.Millisecond
+	}
+
+	// The current window is full, wait for the next one and for enough of this one to slide out
+	t := float64(limit.Period - elapsed)
+	if float64(curr) >= requests {
+		t += period * (1 - requests/float64(curr))
+	}
+	return time.Duration(t) + time.Millisecond
+}
+
+// tokenBucketCapacity is the bucket size, the regular limit plus any burst
+func tokenBucketCapacity(limit Limit) float64 {
+	return float64(limit.Requests + limit.Burst)
+}
+
+// tokenBucketRate returns how many tokens are added per nanosecond
+func tokenBucketRate(limit Limit) float64 {
+	return float64(limit.Requests) / float64(limit.Period)
+}
+
+// tokenBucketResult turns the tokens left after a check into a result
+func tokenBucketResult(limit Limit, allowed bool, tokens float64) Result {
+	capacity := tokenBucketCapacity(limit)
+	rate := tokenBucketRate(limit)
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((capacity - tokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1-tokens)/rate) + time.Millisecond
+	}
+	return res
+}
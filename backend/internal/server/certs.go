@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"sharex/internal/utils"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, such as a renewal by certbot
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves the certificate in certFile and keyFile and loads it
+// again when either file changes, so renewals need no restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *utils.Logger
+	cert     atomic.Pointer[tls.Certificate]
+	modTimes [2]time.Time
+	stopChan chan struct{}
+}
+
+func newCertReloader(certFile, keyFile string, logger *utils.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		stopChan: make(chan struct{}),
+	}
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Start checks the files every certCheckInterval
+func (r *certReloader) Start() {
+	go r.run()
+}
+
+func (r *certReloader) run() {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+func (r *certReloader) Close() {
+	close(r.stopChan)
+}
+
+// check reloads the certificate if a file changed. A pair that does not load,
+// for example because only the certificate has been replaced so far, is
+// retried on the next check while the current certificate stays in use.
+func (r *certReloader) check() {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		r.logger.Warn("Failed to check TLS certificate files", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if modTimes == r.modTimes {
+		return
+	}
+
+	if err := r.load(modTimes); err != nil {
+		r.logger.Warn("Failed to reload TLS certificate, keeping the current one", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	r.logger.Info("Reloaded TLS certificate", map[string]interface{}{
+		"subject":   r.cert.Load().Leaf.Subject.String(),
+		"not_after": r.cert.Load().Leaf.NotAfter.Format(time.RFC3339),
+	})
+}
+
+// load reads the key pair and makes it the served certificate
+func (r *certReloader) load(modTimes [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+	r.cert.Store(&cert)
+	r.modTimes = modTimes
+	return nil
+}
+
+// statFiles returns the modification times of the certificate and key files
+func (r *certReloader) statFiles() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/utils"
+)
+
+// Server is the HTTP server with the timeouts of the server section, HTTPS
+// when a certificate is configured, and a graceful shutdown
+type Server struct {
+	config *config.Config
+	logger *utils.Logger
+	http   *http.Server
+	certs  *certReloader
+}
+
+// New prepares a server for handler. It fails when the configured
+// certificate cannot be loaded.
+func New(cfg *config.Config, handler http.Handler, logger *utils.Logger) (*Server, error) {
+	s := &Server{
+		config: cfg,
+		logger: logger,
+		http: &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.App.Port),
+			Handler:           handler,
+			ReadHeaderTimeout: seconds(cfg.Server.ReadHeaderTimeout),
+			ReadTimeout:       seconds(cfg.Server.ReadTimeout),
+			WriteTimeout:      seconds(cfg.Server.WriteTimeout),
+			IdleTimeout:       seconds(cfg.Server.IdleTimeout),
+			ErrorLog:          log.New(errorLogWriter{logger}, "", 0),
+		},
+	}
+
+	if cfg.Server.TLS.CertFile != "" {
+		certs, err := newCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+		s.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	return s, nil
+}
+
+// RegisterOnShutdown registers a function called when shutdown starts, to
+// end responses that would otherwise run until the deadline, like event
+// streams
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.http.RegisterOnShutdown(f)
+}
+
+// Run serves until ctx is done. It then stops accepting connections and
+// gives in-flight requests server.shutdown_timeout seconds to finish before
+// closing the connections that are left.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+
+	if s.certs != nil {
+		s.certs.Start()
+		defer s.certs.Close()
+	}
+
+	s.logger.Info("Starting server", map[string]interface{}{
+		"address":     s.http.Addr,
+		"environment": s.config.App.Environment,
+		"tls":         s.certs != nil,
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			served <- s.http.ServeTLS(listener, "", "")
+		} else {
+			served <- s.http.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := seconds(s.config.Server.ShutdownTimeout)
+	s.logger.Info("Shutting down server", map[string]interface{}{
+		"timeout": timeout.String(),
+	})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		s.logger.Warn("Requests still running at the shutdown deadline were cut off", map[string]interface{}{
+			"error": err.Error(),
+		})
+		s.http.Close()
+	}
+
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	s.logger.Info("Server stopped", nil)
+	return nil
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// errorLogWriter sends the messages of net/http, such as TLS handshake
+// errors, to the debug log since clients cause most of them
+type errorLogWriter struct {
+	logger *utils.Logger
+}
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	w.logger.Debug("HTTP server error", map[string]interface{}{
+		"error": strings.TrimSpace(string(p)),
+	})
+	return len(p), nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+
+	"sharex/internal/models"
+)
+
+// ImageViewAggregates returns an image's views per day and country, with raw
+// views rolled up the way retention would
+func (db *DB) ImageViewAggregates(imageID int64) ([]models.ViewAggregate, error) {
+	day := db.dialect.day("viewed_at")
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT date, country, SUM(views)
+		FROM (
+			SELECT %[1]s AS date, COALESCE(country, 'Unknown') AS country, COUNT(*) AS views
+			FROM image_views
+			WHERE image_id = ?
+			GROUP BY %[1]s, COALESCE(country, 'Unknown')
+			UNION ALL
+			SELECT date, country, views
+			FROM image_view_aggregates
+			WHERE image_id = ?
+		) AS combined
+		GROUP BY date, country
+		ORDER BY date, country
+	`, day), imageID, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []models.ViewAggregate
+	for rows.Next() {
+		var a models.ViewAggregate
+		if err := rows.Scan(&a.Date, &a.Country, &a.Views); err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, a)
+	}
+	return aggregates, rows.Err()
+}
+
+// ReplaceImage deletes the image with the given id, its views included, and
+// creates image in its place in one transaction
+func (db *DB) ReplaceImage(id int64, image *models.Image) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM image_views WHERE image_id = ?`,
+		`DELETE FROM image_view_aggregates WHERE image_id = ?`,
+		`DELETE FROM images WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+
+	newID, err := tx.insert(insertImage,
+		image.UUID,
+		image.Filename,
+		image.Extension,
+		image.Size,
+		image.UploadedAt,
+		image.IsPrivate,
+		image.PasswordHash,
+		image.SHA256,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	image.ID = newID
+	return nil
+}
+
+// ImportImageViews sets an image's view count and adds its views per day and
+// country, as exported from another instance
+func (db *DB) ImportImageViews(imageID, total int64, aggregates []models.ViewAggregate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE images SET views = ? WHERE id = ?`, total, imageID); err != nil {
+		return err
+	}
+	for _, a := range aggregates {
+		_, err := tx.Exec(`
+			INSERT INTO image_view_aggregates (image_id, date, country, views)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (image_id, date, country) DO UPDATE SET views = image_view_aggregates.views + excluded.views
+		`, imageID, a.Date, a.Country, a.Views)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
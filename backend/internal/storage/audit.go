@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"sharex/internal/models"
+)
+
+// auditMu serializes appends within the process. The unique prev_hash column
+// keeps the chain linear even if another process writes at the same time.
+var auditMu sync.Mutex
+
+// AuditFilter narrows down ListAuditEvents. Empty fields match everything.
+type AuditFilter struct {
+	Actor      string
+	Action     string // exact action, or a prefix when it ends in ".*"
+	TargetType string
+	Target     string
+	IP         string
+	From       time.Time
+	To         time.Time
+}
+
+// RecordAuditEvent appends an event to the audit log, filling in its time
+// and hashes
+func (db *DB) RecordAuditEvent(event *models.AuditEvent) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRow(`SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// PostgreSQL keeps microseconds, the hash must match what is read back
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.PrevHash = prevHash
+	event.Hash = auditHash(event)
+
+	event.ID, err = tx.insert(`
+		INSERT INTO audit_events (created_at, actor, action, target_type, target, ip, user_agent,
+			before_value, after_value, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.CreatedAt, event.Actor, event.Action, event.TargetType, event.Target, event.IP, event.UserAgent,
+		string(event.Before), string(event.After), event.PrevHash, event.Hash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListAuditEvents returns one page of matching events, newest first, and the
+// total number of matches
+func (db *DB) ListAuditEvents(filter AuditFilter, limit, offset int) ([]models.AuditEvent, int64, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	for column, value := range map[string]string{
+		"actor":       filter.Actor,
+		"target_type": filter.TargetType,
+		"target":      filter.Target,
+		"ip":          filter.IP,
+	} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if prefix, ok := strings.CutSuffix(filter.Action, "*"); ok {
+		conditions = append(conditions, "substr(action, 1, ?) = ?")
+		args = append(args, len(prefix), prefix)
+	} else if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := db.QueryRow(`SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(auditColumns+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, *event)
+	}
+	return events, total, rows.Err()
+}
+
+// AuditChainStatus is the result of verifying the audit log
+type AuditChainStatus struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	Head     string `json:"head"`                // hash of the newest event, worth keeping elsewhere
+	BrokenAt int64  `json:"broken_at,omitempty"` // first event whose hash or link does not match
+}
+
+// VerifyAuditChain recomputes every hash from the oldest event on and
+// reports the first one that does not match
+func (db *DB) VerifyAuditChain() (*AuditChainStatus, error) {
+	rows, err := db.Query(auditColumns + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	status := &AuditChainStatus{Valid: true}
+	prevHash := ""
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		status.Checked++
+		if event.PrevHash != prevHash || auditHash(event) != event.Hash {
+			status.Valid = false
+			status.BrokenAt = event.ID
+			return status, nil
+		}
+		prevHash = event.Hash
+	}
+	status.Head = prevHash
+	return status, rows.Err()
+}
+
+const auditColumns = `
+	SELECT id, created_at, actor, action, target_type, target, ip, user_agent,
+		before_value, after_value, prev_hash, hash
+	FROM audit_events`
+
+func scanAuditEvent(row scanner) (*models.AuditEvent, error) {
+	var (
+		event         models.AuditEvent
+		before, after string
+	)
+	err := row.Scan(&event.ID, &event.CreatedAt, &event.Actor, &event.Action, &event.TargetType, &event.Target,
+		&event.IP, &event.UserAgent, &before, &after, &event.PrevHash, &event.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if before != "" {
+		event.Before = json.RawMessage(before)
+	}
+	if after != "" {
+		event.After = json.RawMessage(after)
+	}
+	return &event, nil
+}
+
+// auditHash is the SHA-256 of the previous hash and every recorded field
+func auditHash(event *models.AuditEvent) string {
+	fields, _ := json.Marshal([]string{
+		event.PrevHash,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		event.Actor,
+		event.Action,
+		event.TargetType,
+		event.Target,
+		event.IP,
+		event.UserAgent,
+		string(event.Before),
+		string(event.After),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
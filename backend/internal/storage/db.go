@@ -1,37 +1,115 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
+	"sharex/internal/metrics"
 	"sharex/internal/models"
+	"sharex/internal/utils"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
 	*sql.DB
+	dialect dialect
 }
 
-func NewDB(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// NewDB opens the database of the given driver, sqlite or postgres, and
+// brings its schema up to date. source is the SQLite file or the PostgreSQL
+// connection URL.
+func NewDB(driver, source string) (*DB, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	sqlDB, err := sql.Open(d.driverName(), source)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
 
-	if err := initSchema(db); err != nil {
+	db := &DB{sqlDB, d}
+	if err := db.initSchema(); err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	return db, nil
+}
+
+// Exec runs a statement and records its latency
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+// Query runs a query and records its latency
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+// QueryContext runs a query with a context and records its latency
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), args...)
+}
+
+// QueryRow runs a single-row query and records its latency
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
 }
 
-func initSchema(db *sql.DB) error {
-	schema := `
+// insert runs an INSERT and returns the id of the new row
+func (db *DB) insert(query string, args ...interface{}) (int64, error) {
+	return db.dialect.insert(db, query, args...)
+}
+
+// observeQuery records query latency labelled by the leading SQL keyword
+func observeQuery(query string, start time.Time) {
+	operation := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToLower(fields[0])
+	}
+	metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), operation)
+}
+
+// initSchema creates missing tables and migrates existing ones in a single
+// transaction
+func (db *DB) initSchema() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.dialect.lockSchema(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(db.dialect.schema()); err != nil {
+		return err
+	}
+	if err := migrateSchema(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const sqliteSchema = `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		username TEXT UNIQUE NOT NULL,
@@ -59,57 +137,305 @@ func initSchema(db *sql.DB) error {
 		viewed_at DATETIME NOT NULL,
 		FOREIGN KEY (image_id) REFERENCES images(id)
 	);
-	`
 
-	_, err := db.Exec(schema)
+	CREATE INDEX IF NOT EXISTS idx_image_views_viewed_at ON image_views(viewed_at);
+	CREATE INDEX IF NOT EXISTS idx_image_views_ip ON image_views(ip);
+
+	CREATE TABLE IF NOT EXISTS image_view_aggregates (
+		image_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT 'Unknown',
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (image_id, date, country),
+		FOREIGN KEY (image_id) REFERENCES images(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		revoked_reason TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME,
+		FOREIGN KEY (session_id) REFERENCES sessions(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		kind TEXT NOT NULL,
+		key TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at DATETIME NOT NULL,
+		blocked_until DATETIME,
+		locked BOOLEAN NOT NULL DEFAULT 0,
+		PRIMARY KEY (kind, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		before_value TEXT NOT NULL DEFAULT '',
+		after_value TEXT NOT NULL DEFAULT '',
+		prev_hash TEXT NOT NULL UNIQUE,
+		hash TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+
+	CREATE TABLE IF NOT EXISTS quarantined_uploads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		extension TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		path TEXT NOT NULL,
+		scanner TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		quarantined_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_active_name ON api_tokens(name) WHERE revoked_at IS NULL;
+`
+
+// addedColumns are the columns introduced after the initial schema
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "totp_last_counter", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'admin'"},
+	{"users", "auth_provider", "TEXT NOT NULL DEFAULT 'local'"},
+	{"users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"images", "sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"images", "missing", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// migrateSchema adds columns introduced after the initial schema to existing databases
+func migrateSchema(tx *Tx) error {
+	for _, c := range addedColumns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject)`)
 	return err
 }
 
+// HashPrivateKeys replaces viewer passwords that older versions stored
+// verbatim in images.private_key with their hashes and returns how many it
+// hashed. Hashing is slow, so it runs outside any transaction and each key is
+// only replaced if it did not change in the meantime.
+func (db *DB) HashPrivateKeys(ctx context.Context) (int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, private_key FROM images WHERE private_key IS NOT NULL AND private_key != ''`)
+	if err != nil {
+		return 0, err
+	}
+
+	plain := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !utils.IsPasswordHash(key) {
+			plain[id] = key
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	hashed := 0
+	for id, key := range plain {
+		if err := ctx.Err(); err != nil {
+			return hashed, err
+		}
+		hash, err := utils.HashPassword(key)
+		if err != nil {
+			return hashed, err
+		}
+		result, err := db.Exec(`UPDATE images SET private_key = ? WHERE id = ? AND private_key = ?`, hash, id, key)
+		if err != nil {
+			return hashed, err
+		}
+		// A password changed since it was read is not counted
+		n, err := result.RowsAffected()
+		if err != nil {
+			return hashed, err
+		}
+		hashed += int(n)
+	}
+	return hashed, nil
+}
+
+// addColumnIfMissing adds a column unless the table already has it
+func addColumnIfMissing(tx *Tx, table, column, definition string) error {
+	exists, err := tx.dialect.hasColumn(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// schemaTable matches the tables the schema creates
+var schemaTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+
+// CheckSchema reports whether the database is reachable and has every table
+// and column of the current schema, which a restore or an older version
+// sharing the database could have undone
+func (db *DB) CheckSchema(ctx context.Context) error {
+	sqlTx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx := &Tx{sqlTx, db.dialect}
+	defer tx.Rollback()
+
+	for _, match := range schemaTable.FindAllStringSubmatch(db.dialect.schema(), -1) {
+		rows, err := tx.Query(fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0", match[1]))
+		if err != nil {
+			return fmt.Errorf("table %s: %w", match[1], err)
+		}
+		rows.Close()
+	}
+	for _, c := range addedColumns {
+		exists, err := db.dialect.hasColumn(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("column %s.%s is missing, the schema is not migrated", c.table, c.column)
+		}
+	}
+	return nil
+}
+
+// CreateUser adds a local user, storing only a hash of the password
 func (db *DB) CreateUser(username, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
 	query := `INSERT INTO users (username, password) VALUES (?, ?)`
-	_, err := db.Exec(query, username, password)
+	_, err = db.Exec(query, username, hash)
 	return err
 }
 
 func (db *DB) GetUser(username string) (*models.User, error) {
-	query := `SELECT id, username, password FROM users WHERE username = ?`
-	user := &models.User{}
-	err := db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	return user, err
+	return scanUser(db.QueryRow(userColumns+` WHERE username = ?`, username))
 }
 
+const insertImage = `
+	INSERT INTO images (uuid, filename, extension, size, uploaded_at, is_private, private_key, sha256)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
 func (db *DB) CreateImage(image *models.Image) error {
-	query := `
-		INSERT INTO images (uuid, filename, extension, size, uploaded_at, is_private, private_key)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
-	result, err := db.Exec(query,
+	id, err := db.insert(insertImage,
 		image.UUID,
 		image.Filename,
 		image.Extension,
 		image.Size,
 		image.UploadedAt,
 		image.IsPrivate,
-		image.PrivateKey,
+		image.PasswordHash,
+		image.SHA256,
 	)
 	if err != nil {
 		return err
 	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
 	image.ID = id
 	return nil
 }
 
+// ImagePath returns where an image's file is stored below basePath
+func ImagePath(basePath string, image *models.Image) string {
+	return filepath.Join(
+		basePath,
+		fmt.Sprintf("%d", image.UploadedAt.Year()),
+		fmt.Sprintf("%02d", image.UploadedAt.Month()),
+		fmt.Sprintf("%02d", image.UploadedAt.Day()),
+		fmt.Sprintf("%s.%s", image.UUID, image.Extension),
+	)
+}
+
 func (db *DB) GetImage(uuid string) (*models.Image, error) {
 	query := `
-		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views
+		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views, sha256, missing
 		FROM images WHERE uuid = ?
 	`
 	image := &models.Image{}
@@ -121,8 +447,10 @@ func (db *DB) GetImage(uuid string) (*models.Image, error) {
 		&image.Size,
 		&image.UploadedAt,
 		&image.IsPrivate,
-		&image.PrivateKey,
+		&image.PasswordHash,
 		&image.Views,
+		&image.SHA256,
+		&image.Missing,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -186,7 +514,7 @@ func (db *DB) GetImageViews(imageID int64) ([]models.ImageView, error) {
 
 func (db *DB) ListImages(imageType, dateFrom, dateTo string) ([]models.Image, error) {
 	query := `
-		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views
+		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views, sha256, missing
 		FROM images
 		WHERE 1=1
 	`
@@ -239,8 +567,10 @@ func (db *DB) ListImages(imageType, dateFrom, dateTo string) ([]models.Image, er
 			&image.Size,
 			&image.UploadedAt,
 			&image.IsPrivate,
-			&image.PrivateKey,
+			&image.PasswordHash,
 			&image.Views,
+			&image.SHA256,
+			&image.Missing,
 		)
 		if err != nil {
 			return nil, err
@@ -256,13 +586,25 @@ func (db *DB) UpdateImage(image *models.Image) error {
 		SET is_private = ?, private_key = ?
 		WHERE uuid = ?
 	`
-	_, err := db.Exec(query, image.IsPrivate, image.PrivateKey, image.UUID)
+	_, err := db.Exec(query, image.IsPrivate, image.PasswordHash, image.UUID)
+	return err
+}
+
+// SetImageChecksum records the SHA-256 of an image's content
+func (db *DB) SetImageChecksum(id int64, sha256 string) error {
+	_, err := db.Exec(`UPDATE images SET sha256 = ? WHERE id = ?`, sha256, id)
+	return err
+}
+
+// SetImageMissing flags an image whose file is gone from storage
+func (db *DB) SetImageMissing(id int64, missing bool) error {
+	_, err := db.Exec(`UPDATE images SET missing = ? WHERE id = ?`, missing, id)
 	return err
 }
 
 func (db *DB) GetImageByID(id int64) (*models.Image, error) {
 	query := `
-		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views
+		SELECT id, uuid, filename, extension, size, uploaded_at, is_private, private_key, views, sha256, missing
 		FROM images WHERE id = ?
 	`
 	image := &models.Image{}
@@ -274,8 +616,10 @@ func (db *DB) GetImageByID(id int64) (*models.Image, error) {
 		&image.Size,
 		&image.UploadedAt,
 		&image.IsPrivate,
-		&image.PrivateKey,
+		&image.PasswordHash,
 		&image.Views,
+		&image.SHA256,
+		&image.Missing,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -291,6 +635,13 @@ func (db *DB) DeleteImageByID(id int64) error {
 		return err
 	}
 
+	// Then delete the aggregated views
+	query = `DELETE FROM image_view_aggregates WHERE image_id = ?`
+	_, err = db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
 	// Then delete the image
 	query = `DELETE FROM images WHERE id = ?`
 	_, err = db.Exec(query, id)
@@ -298,23 +649,31 @@ func (db *DB) DeleteImageByID(id int64) error {
 }
 
 func (db *DB) GetViewsForDate(date string) (int64, error) {
-	query := `
-		SELECT COUNT(*) 
-		FROM image_views 
-		WHERE DATE(viewed_at) = ?
-	`
+	query := fmt.Sprintf(`
+		SELECT
+			(SELECT COUNT(*) FROM image_views WHERE %s = ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM image_view_aggregates WHERE date = ?)
+	`, db.dialect.day("viewed_at"))
 	var count int64
-	err := db.QueryRow(query, date).Scan(&count)
+	err := db.QueryRow(query, date, date).Scan(&count)
 	return count, err
 }
 
 func (db *DB) GetCountryViews() ([]models.CountryViews, error) {
 	query := `
-		SELECT 
+		SELECT
 			country,
-			COUNT(*) as views
-		FROM image_views
-		WHERE country IS NOT NULL
+			SUM(views) as views
+		FROM (
+			SELECT country, COUNT(*) as views
+			FROM image_views
+			WHERE country IS NOT NULL
+			GROUP BY country
+			UNION ALL
+			SELECT country, SUM(views) as views
+			FROM image_view_aggregates
+			GROUP BY country
+		) AS combined
 		GROUP BY country
 		ORDER BY views DESC
 		LIMIT 10
@@ -403,7 +762,7 @@ func (db *DB) GetDashboardStats() (int64, int64, int64, error) {
 	}
 
 	// Get private images
-	err = db.QueryRow("SELECT COUNT(*) FROM images WHERE is_private = 1").Scan(&privateImages)
+	err = db.QueryRow("SELECT COUNT(*) FROM images WHERE is_private = TRUE").Scan(&privateImages)
 	if err != nil {
 		return 0, 0, 0, err
 	}
@@ -441,3 +800,148 @@ func (db *DB) GetTotalImagesCount(imageType, dateFrom, dateTo string) (int64, er
 	err := db.QueryRow(query, args...).Scan(&count)
 	return count, err
 }
+
+// PurgeImageViews rolls raw view rows older than the cutoff up into daily
+// per-country aggregates and deletes them. It returns the number of purged rows.
+func (db *DB) PurgeImageViews(before time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		INSERT INTO image_view_aggregates (image_id, date, country, views)
+		SELECT image_id, %[1]s, COALESCE(country, 'Unknown'), COUNT(*)
+		FROM image_views
+		WHERE viewed_at < ?
+		GROUP BY image_id, %[1]s, COALESCE(country, 'Unknown')
+		ON CONFLICT (image_id, date, country) DO UPDATE SET views = image_view_aggregates.views + excluded.views
+	`, db.dialect.day("viewed_at"))
+	if _, err := tx.Exec(query, before); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM image_views WHERE viewed_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, tx.Commit()
+}
+
+// EraseImageViewsByIP deletes every raw view row recorded for any of the given
+// IP values. Aggregates carry no IP and are left untouched.
+func (db *DB) EraseImageViewsByIP(ips ...string) (int64, error) {
+	if len(ips) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ips)), ", ")
+	args := make([]interface{}, len(ips))
+	for i, ip := range ips {
+		args[i] = ip
+	}
+
+	result, err := db.Exec(`DELETE FROM image_views WHERE ip IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ViewFilter narrows down the raw view rows returned by StreamImageViews
+type ViewFilter struct {
+	ImageID  int64
+	DateFrom string // YYYY-MM-DD, inclusive
+	DateTo   string // YYYY-MM-DD, inclusive
+	Country  string
+}
+
+// StreamImageViews calls fn for every raw view row matching the filter, oldest
+// first, without loading the result set into memory. Iteration stops at the
+// first error returned by fn.
+func (db *DB) StreamImageViews(ctx context.Context, filter ViewFilter, fn func(models.RecentView) error) error {
+	query := `
+		SELECT iv.id, iv.image_id, i.uuid, iv.ip, COALESCE(iv.country, ''), COALESCE(iv.user_agent, ''), iv.viewed_at
+		FROM image_views iv
+		JOIN images i ON iv.image_id = i.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if filter.ImageID != 0 {
+		query += " AND iv.image_id = ?"
+		args = append(args, filter.ImageID)
+	}
+
+	if filter.DateFrom != "" {
+		query += " AND iv.viewed_at >= ?"
+		args = append(args, filter.DateFrom)
+	}
+
+	if filter.DateTo != "" {
+		query += " AND iv.viewed_at < ?"
+		// Add one day to include the entire end date
+		endDate, err := time.Parse("2006-01-02", filter.DateTo)
+		if err == nil {
+			args = append(args, endDate.AddDate(0, 0, 1).Format("2006-01-02"))
+		} else {
+			args = append(args, filter.DateTo)
+		}
+	}
+
+	if filter.Country != "" {
+		query += " AND UPPER(iv.country) = ?"
+		args = append(args, strings.ToUpper(filter.Country))
+	}
+
+	query += " ORDER BY iv.viewed_at ASC, iv.id ASC"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var view models.RecentView
+		err := rows.Scan(
+			&view.ID,
+			&view.ImageID,
+			&view.ImageUUID,
+			&view.IP,
+			&view.Country,
+			&view.UserAgent,
+			&view.ViewedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(view); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Vacuum releases the space of deleted rows. On SQLite it rebuilds the
+// database file.
+func (db *DB) Vacuum() error {
+	_, err := db.Exec(`VACUUM`)
+	return err
+}
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist. It runs while the server keeps serving requests.
+func (db *DB) Snapshot(path string) error {
+	if _, ok := db.dialect.(sqliteDialect); !ok {
+		return fmt.Errorf("snapshots need the sqlite driver, back up PostgreSQL with pg_dump")
+	}
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
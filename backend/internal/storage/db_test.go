@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/utils"
+)
+
+// postgresURLEnv names the connection URL of a PostgreSQL server to run the
+// tests against as well. They run on SQLite only when it is unset.
+const postgresURLEnv = "LLMSTOR_TEST_POSTGRES_URL"
+
+// forEachDriver runs a test once per database engine, each time with the
+// source of a new, empty database
+func forEachDriver(t *testing.T, fn func(t *testing.T, driver, source string)) {
+	t.Run("sqlite", func(t *testing.T) {
+		fn(t, "sqlite", filepath.Join(t.TempDir(), "test.db"))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		fn(t, "postgres", postgresSource(t))
+	})
+}
+
+// postgresSource creates a schema the test owns and returns a URL that uses
+// it. The session time zone is set far from UTC so day formatting that
+// depends on it fails.
+func postgresSource(t *testing.T) string {
+	t.Helper()
+	base := os.Getenv(postgresURLEnv)
+	if base == "" {
+		t.Skipf("%s is not set", postgresURLEnv)
+	}
+
+	admin, err := sql.Open("pgx", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("llmstor_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("parse %s: %v", postgresURLEnv, err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	query.Set("timezone", "Asia/Tokyo")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func openTestDB(t *testing.T, driver, source string) *DB {
+	t.Helper()
+	db, err := NewDB(driver, source)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createTestImage(t *testing.T, db *DB, uuid string) *models.Image {
+	t.Helper()
+	image := &models.Image{
+		UUID:       uuid,
+		Filename:   uuid + ".png",
+		Extension:  "png",
+		Size:       1,
+		UploadedAt: time.Now().UTC(),
+	}
+	if err := db.CreateImage(image); err != nil {
+		t.Fatalf("CreateImage: %v", err)
+	}
+	return image
+}
+
+func TestInsertReturnsID(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, driver, source string) {
+		db := openTestDB(t, driver, source)
+
+		first := createTestImage(t, db, "first")
+		second := createTestImage(t, db, "second")
+		if first.ID == 0 || second.ID == first.ID {
+			t.Fatalf("ids %d and %d, want two different ids", first.ID, second.ID)
+		}
+		stored, err := db.GetImage("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ID != second.ID {
+			t.Errorf("CreateImage set id %d, stored row has %d", second.ID, stored.ID)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		id, err := tx.insert(`INSERT INTO users (username, password) VALUES (?, ?)`, "alice", "hash")
+		if err != nil {
+			t.Fatalf("insert in transaction: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		user, err := db.GetUser("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != id {
+			t.Errorf("insert returned id %d, stored row has %d", id, user.ID)
+		}
+	})
+}
+
+func TestDayFormatsUTC(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, driver, source string) {
+		db := openTestDB(t, driver, source)
+		image := createTestImage(t, db, "viewed")
+
+		// 23:30 in New York is the next day in UTC, and later still in Tokyo
+		viewedAt := time.Date(2024, 3, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+		_, err := db.Exec(`
+			INSERT INTO image_views (image_id, ip, country, user_agent, viewed_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, image.ID, "192.0.2.1", "US", "test", viewedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var day string
+		if err := db.QueryRow(`SELECT ` + db.dialect.day("viewed_at") + ` FROM image_views`).Scan(&day); err != nil {
+			t.Fatal(err)
+		}
+		if day != "2024-03-02" {
+			t.Errorf("day = %q, want 2024-03-02", day)
+		}
+
+		for date, want := range map[string]int64{"2024-03-01": 0, "2024-03-02": 1} {
+			count, err := db.GetViewsForDate(date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != want {
+				t.Errorf("GetViewsForDate(%s) = %d, want %d", date, count, want)
+			}
+		}
+	})
+}
+
+func TestMigrateSchema(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, driver, source string) {
+		// A database of the first release: the initial tables without any
+		// added column, and a viewer password stored verbatim
+		d := dialects[driver]
+		old, err := sql.Open(d.driverName(), source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := old.Exec(d.schema()); err != nil {
+			t.Fatalf("create initial schema: %v", err)
+		}
+		_, err = old.Exec(d.rebind(`INSERT INTO users (username, password) VALUES (?, ?)`), "admin", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = old.Exec(d.rebind(`
+			INSERT INTO images (uuid, filename, extension, size, uploaded_at, is_private, private_key)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`), "private", "private.png", "png", 1, time.Now().UTC(), true, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old.Close()
+
+		db := openTestDB(t, driver, source)
+		if err := db.CheckSchema(t.Context()); err != nil {
+			t.Fatalf("CheckSchema after migrating: %v", err)
+		}
+
+		user, err := db.GetUser("admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != models.RoleAdmin || user.AuthProvider != models.AuthProviderLocal {
+			t.Errorf("existing user has role %q and provider %q, want admin and local", user.Role, user.AuthProvider)
+		}
+
+		hashed, err := db.HashPrivateKeys(t.Context())
+		if err != nil {
+			t.Fatalf("HashPrivateKeys: %v", err)
+		}
+		if hashed != 1 {
+			t.Errorf("HashPrivateKeys hashed %d keys, want 1", hashed)
+		}
+		image, err := db.GetImage("private")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utils.IsPasswordHash(image.PasswordHash) {
+			t.Fatalf("viewer password %q was not hashed", image.PasswordHash)
+		}
+		if !utils.CheckPassword("secret", image.PasswordHash) {
+			t.Errorf("hashed viewer password does not match the old one")
+		}
+		db.Close()
+
+		// Migrating and hashing again changes nothing
+		db = openTestDB(t, driver, source)
+		if hashed, err := db.HashPrivateKeys(t.Context()); err != nil || hashed != 0 {
+			t.Errorf("second HashPrivateKeys hashed %d keys, err %v, want none", hashed, err)
+		}
+		again, err := db.GetImage("private")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.PasswordHash != image.PasswordHash {
+			t.Errorf("second migration rehashed the viewer password")
+		}
+	})
+}
+
+func TestUserPasswords(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, driver, source string) {
+		db := openTestDB(t, driver, source)
+
+		if err := db.CreateUser("alice", "first"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		user, err := db.GetUser("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utils.IsPasswordHash(user.Password) || !utils.CheckPassword("first", user.Password) {
+			t.Fatalf("CreateUser stored %q, want a hash of the password", user.Password)
+		}
+
+		now := time.Now().UTC()
+		session := &models.Session{ID: "session", UserID: user.ID, CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := db.CreateSession(session, "refresh"); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		if err := db.SetUserPassword(user.ID, "second"); err != nil {
+			t.Fatalf("SetUserPassword: %v", err)
+		}
+		if user, err = db.GetUser("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if !utils.CheckPassword("second", user.Password) || utils.CheckPassword("first", user.Password) {
+			t.Errorf("SetUserPassword did not replace the password with a hash of the new one")
+		}
+		if active, err := db.IsSessionActive(session.ID); err != nil || active {
+			t.Errorf("session active = %v, err %v after a password change, want revoked", active, err)
+		}
+
+		// A password an older version stored verbatim is hashed once proven,
+		// unless it changed in the meantime
+		if _, err := db.Exec(`UPDATE users SET password = ? WHERE id = ?`, "legacy", user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.RehashUserPassword(user.ID, "stale"); err != nil {
+			t.Fatalf("RehashUserPassword: %v", err)
+		}
+		if user, err = db.GetUser("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if user.Password != "legacy" {
+			t.Errorf("RehashUserPassword with a stale password stored %q", user.Password)
+		}
+		if err := db.RehashUserPassword(user.ID, "legacy"); err != nil {
+			t.Fatalf("RehashUserPassword: %v", err)
+		}
+		if user, err = db.GetUser("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if !utils.IsPasswordHash(user.Password) || !utils.CheckPassword("legacy", user.Password) {
+			t.Errorf("RehashUserPassword stored %q, want a hash of the legacy password", user.Password)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect covers what differs between the database engines. Repository
+// methods are written once with ? placeholders and the SQL both engines
+// understand, and go through the dialect for the rest.
+type dialect interface {
+	// driverName is the database/sql driver to open
+	driverName() string
+	// rebind rewrites ? placeholders into the engine's own form
+	rebind(query string) string
+	// schema creates the tables of a new database
+	schema() string
+	// lockSchema keeps other processes from migrating at the same time,
+	// until tx ends
+	lockSchema(tx *Tx) error
+	// hasColumn reports whether a table has a column
+	hasColumn(tx *Tx, table, column string) (bool, error)
+	// insert runs an INSERT and returns the id of the new row
+	insert(e execer, query string, args ...interface{}) (int64, error)
+	// day formats a timestamp column as a YYYY-MM-DD date in UTC
+	day(column string) string
+}
+
+// dialects maps the database.driver setting to its dialect
+var dialects = map[string]dialect{
+	"sqlite":   sqliteDialect{},
+	"postgres": postgresDialect{},
+}
+
+// execer is implemented by both *DB and *Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx is a transaction that speaks the dialect of the DB it was started on
+type Tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+// Begin starts a transaction
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.dialect}, nil
+}
+
+// Exec runs a statement inside the transaction
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+// Query runs a query inside the transaction
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+// QueryRow runs a single-row query inside the transaction
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// insert runs an INSERT inside the transaction and returns the id of the new row
+func (tx *Tx) insert(query string, args ...interface{}) (int64, error) {
+	return tx.dialect.insert(tx, query, args...)
+}
+
+// sqliteDialect is the default, a single database file next to the server
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string { return "sqlite3" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) schema() string { return sqliteSchema }
+
+// lockSchema does nothing, SQLite locks the whole file for a write
+// transaction anyway
+func (sqliteDialect) lockSchema(tx *Tx) error { return nil }
+
+func (sqliteDialect) hasColumn(tx *Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func (sqliteDialect) insert(e execer, query string, args ...interface{}) (int64, error) {
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (sqliteDialect) day(column string) string {
+	return fmt.Sprintf("DATE(%s)", column)
+}
+
+// rebindNumbered rewrites ? placeholders into $1, $2, ... leaving question
+// marks inside string literals alone
+func rebindNumbered(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
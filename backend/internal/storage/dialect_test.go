@@ -0,0 +1,29 @@
+package storage
+
+import "testing"
+
+func TestRebindNumbered(t *testing.T) {
+	tests := []struct {
+		name, query, want string
+	}{
+		{"no placeholders", `SELECT 1`, `SELECT 1`},
+		{"placeholders", `SELECT * FROM images WHERE id = ? AND uuid = ?`, `SELECT * FROM images WHERE id = $1 AND uuid = $2`},
+		{"string literal", `SELECT '?' FROM images WHERE id = ?`, `SELECT '?' FROM images WHERE id = $1`},
+		{"escaped quote", `SELECT 'it''s ?' WHERE a = ? AND b = ?`, `SELECT 'it''s ?' WHERE a = $1 AND b = $2`},
+		{"ten placeholders", `VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, `VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebindNumbered(tt.query); got != tt.want {
+				t.Errorf("rebindNumbered(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteRebindKeepsPlaceholders(t *testing.T) {
+	query := `SELECT * FROM images WHERE id = ?`
+	if got := (sqliteDialect{}).rebind(query); got != query {
+		t.Errorf("rebind(%q) = %q, want it unchanged", query, got)
+	}
+}
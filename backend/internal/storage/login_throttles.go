@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"sharex/internal/models"
+)
+
+// Kinds of login throttles
+const (
+	ThrottleAccount = "account"
+	ThrottleIP      = "ip"
+)
+
+// GetLoginThrottle returns the throttle state for an account or IP, or nil if
+// it has no recorded failures
+func (db *DB) GetLoginThrottle(kind, key string) (*models.LoginThrottle, error) {
+	query := `
+		SELECT kind, key, failures, last_failure_at, blocked_until, locked
+		FROM login_throttles WHERE kind = ? AND key = ?
+	`
+	t, err := scanLoginThrottle(db.QueryRow(query, kind, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// RecordLoginFailure counts a failed login and returns the new number of
+// failures. Failures older than window no longer count.
+func (db *DB) RecordLoginFailure(kind, key string, window time.Duration) (int, error) {
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO login_throttles (kind, key, failures, last_failure_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(kind, key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ?
+				AND (login_throttles.blocked_until IS NULL OR login_throttles.blocked_until < ?)
+				THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures
+	`
+	var failures int
+	err := db.QueryRow(query, kind, key, now, now.Add(-window), now).Scan(&failures)
+	return failures, err
+}
+
+// BlockLogin stops logins for an account or IP until the given time
+func (db *DB) BlockLogin(kind, key string, until time.Time, locked bool) error {
+	query := `UPDATE login_throttles SET blocked_until = ?, locked = ? WHERE kind = ? AND key = ?`
+	_, err := db.Exec(query, until.UTC(), locked, kind, key)
+	return err
+}
+
+// ResetLoginThrottle forgets all failures of an account or IP. It returns
+// false if there was nothing to reset.
+func (db *DB) ResetLoginThrottle(kind, key string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM login_throttles WHERE kind = ? AND key = ?`, kind, key)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ListLoginThrottles returns accounts and IPs with failures inside the window
+// or an active block, and deletes the ones that no longer matter
+func (db *DB) ListLoginThrottles(window time.Duration) ([]models.LoginThrottle, error) {
+	now := time.Now().UTC()
+	if _, err := db.Exec(`
+		DELETE FROM login_throttles
+		WHERE last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)
+	`, now.Add(-window), now); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT kind, key, failures, last_failure_at, blocked_until, locked
+		FROM login_throttles
+		ORDER BY last_failure_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throttles := []models.LoginThrottle{}
+	for rows.Next() {
+		t, err := scanLoginThrottle(rows)
+		if err != nil {
+			return nil, err
+		}
+		throttles = append(throttles, *t)
+	}
+	return throttles, rows.Err()
+}
+
+func scanLoginThrottle(row scanner) (*models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	var blockedUntil sql.NullTime
+	if err := row.Scan(&t.Kind, &t.Key, &t.Failures, &t.LastFailureAt, &blockedUntil, &t.Locked); err != nil {
+		return nil, err
+	}
+	if blockedUntil.Valid {
+		t.BlockedUntil = &blockedUntil.Time
+	}
+	return &t, nil
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresDialect stores metadata in a PostgreSQL database that several
+// server replicas can share
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string { return "pgx" }
+
+func (postgresDialect) rebind(query string) string { return rebindNumbered(query) }
+
+func (postgresDialect) schema() string { return postgresSchema }
+
+// lockSchema serializes migrations of replicas that start at the same time.
+// The lock is released when tx ends.
+func (postgresDialect) lockSchema(tx *Tx) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('llmstor.schema'))`)
+	return err
+}
+
+func (postgresDialect) hasColumn(tx *Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?
+	`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+func (postgresDialect) insert(e execer, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := e.QueryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) day(column string) string {
+	return fmt.Sprintf("TO_CHAR(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD')", column)
+}
+
+// postgresSchema matches sqliteSchema table for table. Columns added since
+// are created by migrateSchema on both engines.
+const postgresSchema = `
+	CREATE TABLE IF NOT EXISTS users (
+		id BIGSERIAL PRIMARY KEY,
+		username TEXT UNIQUE NOT NULL,
+		password TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS images (
+		id BIGSERIAL PRIMARY KEY,
+		uuid TEXT UNIQUE NOT NULL,
+		filename TEXT NOT NULL,
+		extension TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		uploaded_at TIMESTAMPTZ NOT NULL,
+		is_private BOOLEAN NOT NULL DEFAULT FALSE,
+		private_key TEXT,
+		views BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS image_views (
+		id BIGSERIAL PRIMARY KEY,
+		image_id BIGINT NOT NULL REFERENCES images(id),
+		ip TEXT NOT NULL,
+		country TEXT,
+		user_agent TEXT,
+		viewed_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_image_views_viewed_at ON image_views(viewed_at);
+	CREATE INDEX IF NOT EXISTS idx_image_views_ip ON image_views(ip);
+	CREATE INDEX IF NOT EXISTS idx_image_views_image ON image_views(image_id);
+
+	CREATE TABLE IF NOT EXISTS image_view_aggregates (
+		image_id BIGINT NOT NULL REFERENCES images(id),
+		date TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT 'Unknown',
+		views BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (image_id, date, country)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id),
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		webhook_id BIGINT NOT NULL REFERENCES webhooks(id),
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id),
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		revoked_at TIMESTAMPTZ,
+		revoked_reason TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL REFERENCES sessions(id),
+		created_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		kind TEXT NOT NULL,
+		key TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMPTZ NOT NULL,
+		blocked_until TIMESTAMPTZ,
+		locked BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (kind, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGSERIAL PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		before_value TEXT NOT NULL DEFAULT '',
+		after_value TEXT NOT NULL DEFAULT '',
+		prev_hash TEXT NOT NULL UNIQUE,
+		hash TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+
+	CREATE TABLE IF NOT EXISTS quarantined_uploads (
+		id BIGSERIAL PRIMARY KEY,
+		filename TEXT NOT NULL,
+		extension TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		sha256 TEXT NOT NULL,
+		path TEXT NOT NULL,
+		scanner TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		quarantined_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_active_name ON api_tokens(name) WHERE revoked_at IS NULL;
+`
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"sharex/internal/models"
+)
+
+// CreateQuarantinedUpload records an upload the malware scanner flagged
+func (db *DB) CreateQuarantinedUpload(q *models.QuarantinedUpload) error {
+	if q.QuarantinedAt.IsZero() {
+		q.QuarantinedAt = time.Now()
+	}
+	q.QuarantinedAt = q.QuarantinedAt.UTC()
+
+	query := `
+		INSERT INTO quarantined_uploads (filename, extension, size, sha256, path, scanner, signature, ip, quarantined_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	var err error
+	q.ID, err = db.insert(query, q.Filename, q.Extension, q.Size, q.SHA256, q.Path, q.Scanner, q.Signature, q.IP, q.QuarantinedAt)
+	return err
+}
+
+// GetQuarantinedUpload returns a quarantined upload, or nil if it does not exist
+func (db *DB) GetQuarantinedUpload(id int64) (*models.QuarantinedUpload, error) {
+	query := `
+		SELECT id, filename, extension, size, sha256, path, scanner, signature, ip, quarantined_at
+		FROM quarantined_uploads WHERE id = ?
+	`
+	q, err := scanQuarantinedUpload(db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return q, err
+}
+
+// ListQuarantinedUploads returns quarantined uploads, newest first
+func (db *DB) ListQuarantinedUploads() ([]models.QuarantinedUpload, error) {
+	rows, err := db.Query(`
+		SELECT id, filename, extension, size, sha256, path, scanner, signature, ip, quarantined_at
+		FROM quarantined_uploads
+		ORDER BY quarantined_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []models.QuarantinedUpload{}
+	for rows.Next() {
+		q, err := scanQuarantinedUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, *q)
+	}
+	return uploads, rows.Err()
+}
+
+// DeleteQuarantinedUpload removes the record of a quarantined upload
+func (db *DB) DeleteQuarantinedUpload(id int64) error {
+	_, err := db.Exec(`DELETE FROM quarantined_uploads WHERE id = ?`, id)
+	return err
+}
+
+func scanQuarantinedUpload(row scanner) (*models.QuarantinedUpload, error) {
+	var q models.QuarantinedUpload
+	if err := row.Scan(&q.ID, &q.Filename, &q.Extension, &q.Size, &q.SHA256, &q.Path,
+		&q.Scanner, &q.Signature, &q.IP, &q.QuarantinedAt); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"sharex/internal/models"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is
+	// presented again. The whole session has been revoked when this is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Reasons recorded when a session is revoked
+const (
+	RevokedLogout = "logout"
+	RevokedByUser = "revoked"
+	RevokedReuse  = "token_reuse"
+)
+
+// CreateSession stores a new login session together with its first refresh token
+func (db *DB) CreateSession(session *models.Session, refreshID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// Drop sessions that expired long enough ago to be of no further interest
+	cutoff := now.Add(-30 * 24 * time.Hour)
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE expires_at < ?)`, cutoff); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE expires_at < ?`, cutoff); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO sessions (id, user_id, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err := tx.Exec(query, session.ID, session.UserID, session.IP, session.UserAgent,
+		session.CreatedAt.UTC(), session.LastUsedAt.UTC(), session.ExpiresAt.UTC()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (id, session_id, created_at) VALUES (?, ?, ?)`, refreshID, session.ID, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RotateRefreshToken marks refreshID as used and records newID as its
+// successor, extending the session until expiresAt. A token that was already
+// rotated more than grace ago revokes the whole session; within grace it is
+// treated as a concurrent refresh from another tab and also rotated.
+func (db *DB) RotateRefreshToken(refreshID, newID, ip, userAgent string, expiresAt time.Time, grace time.Duration) (*models.Session, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	var usedAt, revokedAt sql.NullTime
+	session := &models.Session{}
+	query := `
+		SELECT t.used_at, s.id, s.user_id, s.created_at, s.expires_at, s.revoked_at
+		FROM refresh_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.id = ?
+	`
+	err = tx.QueryRow(query, refreshID).Scan(&usedAt, &session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid || !session.ExpiresAt.After(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if usedAt.Valid && now.Sub(usedAt.Time) > grace {
+		if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE id = ?`, now, RevokedReuse, session.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return session, ErrRefreshTokenReused
+	}
+
+	if !usedAt.Valid {
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = ? WHERE id = ?`, now, refreshID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (id, session_id, created_at) VALUES (?, ?, ?)`, newID, session.ID, now); err != nil {
+		return nil, err
+	}
+
+	update := `UPDATE sessions SET ip = ?, user_agent = ?, last_used_at = ?, expires_at = ? WHERE id = ?`
+	if _, err := tx.Exec(update, ip, userAgent, now, expiresAt.UTC(), session.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	session.IP = ip
+	session.UserAgent = userAgent
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt.UTC()
+	return session, nil
+}
+
+// IsSessionActive reports whether a session exists and is neither revoked nor expired
+func (db *DB) IsSessionActive(id string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM sessions WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`
+	err := db.QueryRow(query, id, time.Now().UTC()).Scan(&count)
+	return count > 0, err
+}
+
+// SessionRole returns the current role of the user a session belongs to, or
+// an empty string when the session is revoked, expired or unknown
+func (db *DB) SessionRole(id string) (string, error) {
+	var role string
+	query := `
+		SELECT u.role FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = ? AND s.revoked_at IS NULL AND s.expires_at > ?
+	`
+	err := db.QueryRow(query, id, time.Now().UTC()).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// ListSessions returns a user's active sessions, most recently used first
+func (db *DB) ListSessions(userID int64) ([]models.Session, error) {
+	query := `
+		SELECT id, user_id, ip, user_agent, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC
+	`
+	rows, err := db.Query(query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of a user's sessions. It returns false if the
+// session does not exist, belongs to someone else or was already revoked.
+func (db *DB) RevokeSession(userID int64, id, reason string) (bool, error) {
+	query := `UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+	result, err := db.Exec(query, time.Now().UTC(), reason, id, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RevokeAllSessions revokes every active session of a user except the one
+// with id except, which may be empty
+func (db *DB) RevokeAllSessions(userID int64, except, reason string) (int64, error) {
+	query := `UPDATE sessions SET revoked_at = ?, revoked_reason = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL`
+	result, err := db.Exec(query, time.Now().UTC(), reason, userID, except)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"sharex/internal/models"
+)
+
+// HashAPIToken returns the stored form of an API token. Tokens are random
+// enough that a plain SHA-256 is sufficient.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken stores a new token. Names are unique among active tokens.
+func (db *DB) CreateAPIToken(name, token string) (*models.APIToken, error) {
+	apiToken := &models.APIToken{
+		Name:      name,
+		TokenHash: HashAPIToken(token),
+		CreatedAt: time.Now().UTC(),
+	}
+	query := `INSERT INTO api_tokens (name, token_hash, created_at) VALUES (?, ?, ?)`
+	var err error
+	if apiToken.ID, err = db.insert(query, apiToken.Name, apiToken.TokenHash, apiToken.CreatedAt); err != nil {
+		return nil, err
+	}
+	return apiToken, nil
+}
+
+// ListAPITokens returns every token, including revoked ones, oldest first
+func (db *DB) ListAPITokens() ([]models.APIToken, error) {
+	rows, err := db.Query(`
+		SELECT id, name, token_hash, created_at, last_used_at, revoked_at
+		FROM api_tokens ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var lastUsed, revoked sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedAt, &lastUsed, &revoked); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		if revoked.Valid {
+			t.RevokedAt = &revoked.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes the active token with the given name. It returns
+// false if there is none.
+func (db *DB) RevokeAPIToken(name string) (bool, error) {
+	query := `UPDATE api_tokens SET revoked_at = ? WHERE name = ? AND revoked_at IS NULL`
+	result, err := db.Exec(query, time.Now().UTC(), name)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// FindAPIToken returns the id of an active token, without recording a use.
+// It returns false for unknown and revoked tokens.
+func (db *DB) FindAPIToken(token string) (int64, bool, error) {
+	if token == "" {
+		return 0, false, nil
+	}
+
+	var id int64
+	query := `SELECT id FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL`
+	err := db.QueryRow(query, HashAPIToken(token)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// UseAPIToken reports whether a token is valid and not revoked, recording
+// when it was last used. It returns the token's name.
+func (db *DB) UseAPIToken(token string) (string, bool, error) {
+	if token == "" {
+		return "", false, nil
+	}
+
+	var id int64
+	var name string
+	query := `SELECT id, name FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL`
+	err := db.QueryRow(query, HashAPIToken(token)).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if _, err := db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+		return "", false, err
+	}
+	return name, true, nil
+}
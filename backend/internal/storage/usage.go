@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Usage returns the total size of all files under basePath. Dotfiles are left
+// out: they are uploads and import archives still being spooled, which would
+// otherwise count against the quota of the files they become.
+func Usage(basePath string) (int64, error) {
+	var total int64
+	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
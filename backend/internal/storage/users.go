@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"sharex/internal/models"
+	"sharex/internal/utils"
+)
+
+const userColumns = `
+	SELECT id, username, password, role, auth_provider, oidc_subject,
+		totp_secret, totp_enabled, totp_last_counter, disabled
+	FROM users`
+
+func scanUser(row scanner) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password,
+		&user.Role,
+		&user.AuthProvider,
+		&user.OIDCSubject,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastCounter,
+		&user.Disabled,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByOIDCSubject finds the user linked to an identity provider subject
+func (db *DB) GetUserByOIDCSubject(subject string) (*models.User, error) {
+	if subject == "" {
+		return nil, nil
+	}
+	return scanUser(db.QueryRow(userColumns+` WHERE oidc_subject = ?`, subject))
+}
+
+// CreateOIDCUser provisions a user on their first single sign-on login. These
+// users have no local password.
+func (db *DB) CreateOIDCUser(username, subject, role string) (*models.User, error) {
+	query := `INSERT INTO users (username, password, role, auth_provider, oidc_subject) VALUES (?, '', ?, ?, ?)`
+	if _, err := db.Exec(query, username, role, models.AuthProviderOIDC, subject); err != nil {
+		return nil, err
+	}
+	return db.GetUserByOIDCSubject(subject)
+}
+
+// LinkOIDCSubject ties an existing user to an identity provider subject
+func (db *DB) LinkOIDCSubject(userID int64, subject string) error {
+	_, err := db.Exec(`UPDATE users SET oidc_subject = ? WHERE id = ?`, subject, userID)
+	return err
+}
+
+// ListUsers returns every user ordered by username
+func (db *DB) ListUsers() ([]models.User, error) {
+	rows, err := db.Query(userColumns + ` ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// SetUserPassword replaces a user's local password and revokes the user's
+// sessions, so a stolen session does not outlive the old password
+func (db *DB) SetUserPassword(userID int64, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE users SET password = ? WHERE id = ?`, hash, userID); err != nil {
+		return err
+	}
+	_, err = db.RevokeAllSessions(userID, "", RevokedByUser)
+	return err
+}
+
+// RehashUserPassword replaces a password an older version stored verbatim
+// with its hash once a login has proven it. Sessions are kept, and a password
+// changed in the meantime is left alone.
+func (db *DB) RehashUserPassword(userID int64, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE users SET password = ? WHERE id = ? AND password = ?`, hash, userID, password)
+	return err
+}
+
+// SetUserDisabled blocks or allows logins for a user. Disabling also revokes
+// the user's sessions so existing logins end immediately.
+func (db *DB) SetUserDisabled(userID int64, disabled bool) error {
+	if _, err := db.Exec(`UPDATE users SET disabled = ? WHERE id = ?`, disabled, userID); err != nil {
+		return err
+	}
+	if disabled {
+		_, err := db.RevokeAllSessions(userID, "", RevokedByUser)
+		return err
+	}
+	return nil
+}
+
+// SetUserRole changes a user's role
+func (db *DB) SetUserRole(userID int64, role string) error {
+	_, err := db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, userID)
+	return err
+}
+
+// SetPendingTOTPSecret stores a new secret for enrollment without enabling it
+func (db *DB) SetPendingTOTPSecret(userID int64, secret string) error {
+	query := `UPDATE users SET totp_secret = ?, totp_enabled = FALSE, totp_last_counter = 0 WHERE id = ?`
+	_, err := db.Exec(query, secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the recovery codes
+func (db *DB) EnableTOTP(userID int64, counter int64, recoveryCodeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_counter = ? WHERE id = ?`, counter, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication and removes the secret and recovery codes
+func (db *DB) DisableTOTP(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_counter = 0 WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AdvanceTOTPCounter records the time step of an accepted code. It returns
+// false if a code for this or a later step was already used.
+func (db *DB) AdvanceTOTPCounter(userID int64, counter int64) (bool, error) {
+	query := `UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?`
+	result, err := db.Exec(query, counter, userID, counter)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns false if
+// no unused code with this hash exists.
+func (db *DB) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := db.Exec(query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (db *DB) CountRecoveryCodes(userID int64) (int64, error) {
+	var count int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"sharex/internal/models"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+func (db *DB) CreateWebhook(webhook *models.Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	id, err := db.insert(query,
+		webhook.URL,
+		webhook.Secret,
+		strings.Join(webhook.Events, ","),
+		webhook.Enabled,
+		webhook.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	webhook.ID = id
+	return nil
+}
+
+func (db *DB) GetWebhook(id int64) (*models.Webhook, error) {
+	query := `SELECT id, url, secret, events, enabled, created_at FROM webhooks WHERE id = ?`
+	webhook, err := scanWebhook(db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (db *DB) ListWebhooks() ([]models.Webhook, error) {
+	query := `SELECT id, url, secret, events, enabled, created_at FROM webhooks ORDER BY id`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook together with its delivery log
+func (db *DB) DeleteWebhook(id int64) error {
+	if _, err := db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+func (db *DB) CreateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	id, err := db.insert(query,
+		delivery.WebhookID,
+		delivery.EventType,
+		delivery.Payload,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+		delivery.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	return nil
+}
+
+func (db *DB) GetWebhookDelivery(id int64) (*models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+		FROM webhook_deliveries WHERE id = ?
+	`
+	delivery, err := scanWebhookDelivery(db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// ListWebhookDeliveries returns the most recent deliveries of a webhook
+func (db *DB) ListWebhookDeliveries(webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	return db.queryWebhookDeliveries(query, webhookID, limit)
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is due
+func (db *DB) GetDueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+	`
+	return db.queryWebhookDeliveries(query, DeliveryPending, now, limit)
+}
+
+// UpdateWebhookDelivery stores the outcome of a delivery attempt
+func (db *DB) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err := db.Exec(query,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.UpdatedAt,
+		delivery.ID,
+	)
+	return err
+}
+
+// PurgeWebhookDeliveries deletes succeeded and failed deliveries last
+// updated before the cutoff, returning how many were deleted
+func (db *DB) PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM webhook_deliveries WHERE status IN (?, ?) AND updated_at < ?
+	`, DeliverySucceeded, DeliveryFailed, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *DB) queryWebhookDeliveries(query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row scanner) (*models.Webhook, error) {
+	webhook := &models.Webhook{}
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&webhook.Enabled,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if events != "" {
+		webhook.Events = strings.Split(events, ",")
+	}
+	return webhook, nil
+}
+
+func scanWebhookDelivery(row scanner) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
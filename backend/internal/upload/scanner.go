@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"sharex/internal/config"
+)
+
+// ScanResult is the verdict of a malware scan
+type ScanResult struct {
+	Clean     bool
+	Signature string // name of the detected threat when not clean
+}
+
+// Scanner inspects uploaded content before it is published. An error means
+// the scan could not be completed, not that the file is infected.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+	Name() string
+}
+
+// ErrScanFailed wraps scanner protocol and connection failures
+var ErrScanFailed = errors.New("scan failed")
+
+// NewScanner creates the scanner configured in uploads.scanner, or returns nil
+// when scanning is disabled
+func NewScanner(cfg *config.Config) (Scanner, error) {
+	switch cfg.Uploads.Scanner.Type {
+	case "":
+		return nil, nil
+	case config.ScannerClamd:
+		return NewClamdScanner(cfg.Uploads.Scanner.Address, time.Duration(cfg.Uploads.Scanner.Timeout)*time.Second)
+	}
+	return nil, fmt.Errorf("unknown scanner type %q", cfg.Uploads.Scanner.Type)
+}
+
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner streams files to a clamd daemon with the INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for a clamd address, either a unix socket
+// path (unix:/run/clamav/clamd.ctl or an absolute path) or tcp://host:port
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network, addr, err := parseClamdAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &ClamdScanner{network: network, address: addr, timeout: timeout}, nil
+}
+
+func parseClamdAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		return "unix", strings.TrimPrefix(address, "unix:"), nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "/"):
+		return "unix", address, nil
+	}
+	return "", "", fmt.Errorf("clamd address must be unix:<path>, an absolute socket path or tcp://host:port, got %q", address)
+}
+
+// Name returns the scanner name
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan sends the content to clamd and parses its verdict
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	// Each chunk is prefixed with its length; a zero length ends the stream
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return ScanResult{}, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply understands "stream: OK", "stream: <name> FOUND" and
+// "<message> ERROR"
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return ScanResult{Clean: true}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return ScanResult{}, fmt.Errorf("%w: clamd: %s", ErrScanFailed, strings.TrimSuffix(reply, " ERROR"))
+	}
+	return ScanResult{}, fmt.Errorf("%w: unexpected clamd reply %q", ErrScanFailed, reply)
+}
@@ -0,0 +1,400 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// InvalidError is returned by Validate when a file is rejected. Its message
+// is safe to show to the uploader.
+type InvalidError struct {
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return e.Reason
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &InvalidError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Limits bounds the decoded size of an image
+type Limits struct {
+	MaxPixels       int64 // width * height, summed over the frames of an animation, 0 for no limit
+	MaxDimension    int   // longest side, 0 for no limit
+	AllowUnverified bool  // accept formats without a decoder once their headers check out
+}
+
+// Format describes a file type the validator knows
+type Format struct {
+	Name       string
+	Extensions []string
+	magic      func(head []byte) bool
+	decode     func(r io.Reader) error                    // full decode, nil when no decoder is available
+	config     func(r io.Reader) (image.Config, error)    // dimensions from the header
+	structure  func(r io.ReadSeeker, limits Limits) error // rejects trailing or out-of-structure data
+}
+
+var formats = []Format{
+	{
+		Name:       "png",
+		Extensions: []string{"png"},
+		magic:      func(h []byte) bool { return bytes.HasPrefix(h, []byte("\x89PNG\r\n\x1a\n")) },
+		decode:     func(r io.Reader) error { _, err := png.Decode(r); return err },
+		config:     png.DecodeConfig,
+		structure:  checkPNGStructure,
+	},
+	{
+		Name:       "jpeg",
+		Extensions: []string{"jpg", "jpeg"},
+		magic:      func(h []byte) bool { return bytes.HasPrefix(h, []byte{0xFF, 0xD8, 0xFF}) },
+		decode:     func(r io.Reader) error { _, err := jpeg.Decode(r); return err },
+		config:     jpeg.DecodeConfig,
+		structure:  checkJPEGStructure,
+	},
+	{
+		Name:       "gif",
+		Extensions: []string{"gif"},
+		magic: func(h []byte) bool {
+			return bytes.HasPrefix(h, []byte("GIF87a")) || bytes.HasPrefix(h, []byte("GIF89a"))
+		},
+		decode:    func(r io.Reader) error { _, err := gif.DecodeAll(r); return err },
+		config:    gif.DecodeConfig,
+		structure: checkGIFStructure,
+	},
+	{
+		Name:       "webp",
+		Extensions: []string{"webp"},
+		magic: func(h []byte) bool {
+			return len(h) >= 12 && bytes.Equal(h[0:4], []byte("RIFF")) && bytes.Equal(h[8:12], []byte("WEBP"))
+		},
+		config:    webpConfig,
+		structure: checkWebPStructure,
+	},
+}
+
+// FormatForExtension returns the known format for an extension
+func FormatForExtension(ext string) (*Format, bool) {
+	for i := range formats {
+		for _, e := range formats[i].Extensions {
+			if e == ext {
+				return &formats[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Detect returns the format the file's magic bytes identify, if any
+func Detect(r io.ReadSeeker) (*Format, error) {
+	head := make([]byte, 16)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	for i := range formats {
+		if formats[i].magic(head[:n]) {
+			return &formats[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Validate checks that the file really is an image of the type its extension
+// claims: the magic bytes must match, the dimensions must be within limits,
+// the container must end where the image ends, and the whole image must
+// decode. Formats without a decoder are rejected unless the limits allow
+// unverified files, and are then checked as far as their headers go.
+func Validate(r io.ReadSeeker, ext string, limits Limits) error {
+	claimed, ok := FormatForExtension(ext)
+	if !ok {
+		return invalid("no validator for .%s files", ext)
+	}
+
+	if claimed.decode == nil && !limits.AllowUnverified {
+		return invalid("%s images cannot be fully decoded", claimed.Name)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	detected, err := Detect(r)
+	if err != nil {
+		return err
+	}
+	if detected == nil {
+		return invalid("content is not a recognized image")
+	}
+	if detected != claimed {
+		return invalid("content is %s but the extension is .%s", detected.Name, ext)
+	}
+
+	// Dimensions come from the header, before anything large is allocated
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	cfg, err := claimed.config(bufio.NewReader(r))
+	if err != nil {
+		return invalid("unreadable %s header: %v", claimed.Name, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return invalid("image has no pixels")
+	}
+	if limits.MaxDimension > 0 && (cfg.Width > limits.MaxDimension || cfg.Height > limits.MaxDimension) {
+		return invalid("%dx%d exceeds the maximum dimension of %d", cfg.Width, cfg.Height, limits.MaxDimension)
+	}
+	if limits.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > limits.MaxPixels {
+		return invalid("%dx%d exceeds the maximum of %d pixels", cfg.Width, cfg.Height, limits.MaxPixels)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := claimed.structure(r, limits); err != nil {
+		return invalid("%v", err)
+	}
+
+	if claimed.decode != nil {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := claimed.decode(bufio.NewReader(r)); err != nil {
+			return invalid("%s does not decode: %v", claimed.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkTrailing allows only zero padding after the end of the image, which
+// some encoders add. Anything else is a second file riding along.
+func checkTrailing(r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return fmt.Errorf("unexpected data after the end of the image")
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkPNGStructure walks the chunks up to IEND
+func checkPNGStructure(r io.ReadSeeker, _ Limits) error {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(8); err != nil {
+		return err
+	}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return fmt.Errorf("truncated png chunk")
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if length > 1<<31-1 {
+			return fmt.Errorf("invalid png chunk length")
+		}
+		// Chunk data and CRC
+		if _, err := io.CopyN(io.Discard, br, int64(length)+4); err != nil {
+			return fmt.Errorf("truncated png chunk")
+		}
+		if string(header[4:]) == "IEND" {
+			return checkTrailing(br)
+		}
+	}
+}
+
+// checkJPEGStructure walks the marker segments and entropy-coded scans up to EOI
+func checkJPEGStructure(r io.ReadSeeker, _ Limits) error {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(2); err != nil {
+		return err
+	}
+
+	inScan := false
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("jpeg ends before its end marker")
+		}
+		if b != 0xFF {
+			if inScan {
+				continue
+			}
+			return fmt.Errorf("invalid jpeg marker")
+		}
+
+		marker, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("jpeg ends before its end marker")
+		}
+		switch {
+		case marker == 0x00 && inScan, marker >= 0xD0 && marker <= 0xD7 && inScan:
+			// Stuffed byte or restart marker inside scan data
+			continue
+		case marker == 0xFF:
+			// Fill byte
+			br.UnreadByte()
+			continue
+		case marker == 0xD9:
+			return checkTrailing(br)
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			// Standalone markers without a length
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return fmt.Errorf("truncated jpeg segment")
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return fmt.Errorf("invalid jpeg segment length")
+		}
+		if _, err := br.Discard(n - 2); err != nil {
+			return fmt.Errorf("truncated jpeg segment")
+		}
+		// Start of scan is followed by entropy-coded data
+		inScan = marker == 0xDA
+	}
+}
+
+// checkGIFStructure walks the blocks up to the trailer. Every frame decodes
+// to its own image, and frames compress so well that a small file can hold
+// thousands, so the frames times the canvas must stay within the pixel limit.
+func checkGIFStructure(r io.ReadSeeker, limits Limits) error {
+	br := bufio.NewReader(r)
+	var header [13]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return fmt.Errorf("truncated gif header")
+	}
+	canvas := int64(binary.LittleEndian.Uint16(header[6:8])) * int64(binary.LittleEndian.Uint16(header[8:10]))
+	var frames int64
+	if flags := header[10]; flags&0x80 != 0 {
+		if _, err := br.Discard(3 << (flags&0x07 + 1)); err != nil {
+			return fmt.Errorf("truncated gif color table")
+		}
+	}
+
+	skipSubBlocks := func() error {
+		for {
+			size, err := br.ReadByte()
+			if err != nil {
+				return fmt.Errorf("truncated gif data")
+			}
+			if size == 0 {
+				return nil
+			}
+			if _, err := br.Discard(int(size)); err != nil {
+				return fmt.Errorf("truncated gif data")
+			}
+		}
+	}
+
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("gif ends before its trailer")
+		}
+		switch block {
+		case 0x21: // extension
+			if _, err := br.ReadByte(); err != nil {
+				return fmt.Errorf("truncated gif extension")
+			}
+			if err := skipSubBlocks(); err != nil {
+				return err
+			}
+		case 0x2C: // image descriptor
+			frames++
+			if limits.MaxPixels > 0 && frames*canvas > limits.MaxPixels {
+				return fmt.Errorf("%d frames of %d pixels exceed the maximum of %d pixels", frames, canvas, limits.MaxPixels)
+			}
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return fmt.Errorf("truncated gif image")
+			}
+			if flags := desc[8]; flags&0x80 != 0 {
+				if _, err := br.Discard(3 << (flags&0x07 + 1)); err != nil {
+					return fmt.Errorf("truncated gif color table")
+				}
+			}
+			// LZW minimum code size, then the image data
+			if _, err := br.ReadByte(); err != nil {
+				return fmt.Errorf("truncated gif image")
+			}
+			if err := skipSubBlocks(); err != nil {
+				return err
+			}
+		case 0x3B: // trailer
+			return checkTrailing(br)
+		default:
+			return fmt.Errorf("invalid gif block")
+		}
+	}
+}
+
+// checkWebPStructure makes sure the RIFF size covers exactly the file
+func checkWebPStructure(r io.ReadSeeker, _ Limits) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("truncated webp header")
+	}
+	riffSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if riffSize+8 > end {
+		return fmt.Errorf("truncated webp data")
+	}
+	if _, err := r.Seek(riffSize+8, io.SeekStart); err != nil {
+		return err
+	}
+	return checkTrailing(r)
+}
+
+// webpConfig reads the canvas size from the first chunk of a WebP file
+func webpConfig(r io.Reader) (image.Config, error) {
+	var buf [30]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return image.Config{}, err
+	}
+	chunk := buf[12:16]
+	data := buf[20:]
+	switch string(chunk) {
+	case "VP8 ":
+		// Frame tag (3 bytes), start code, then 14-bit width and height
+		if !bytes.Equal(data[3:6], []byte{0x9D, 0x01, 0x2A}) {
+			return image.Config{}, fmt.Errorf("invalid VP8 start code")
+		}
+		width := int(binary.LittleEndian.Uint16(data[6:8]) & 0x3FFF)
+		height := int(binary.LittleEndian.Uint16(data[8:10]) & 0x3FFF)
+		return image.Config{Width: width, Height: height}, nil
+	case "VP8L":
+		if data[0] != 0x2F {
+			return image.Config{}, fmt.Errorf("invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(data[1:5])
+		return image.Config{Width: int(bits&0x3FFF) + 1, Height: int(bits>>14&0x3FFF) + 1}, nil
+	case "VP8X":
+		width := int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16) + 1
+		height := int(uint32(data[7])|uint32(data[8])<<8|uint32(data[9])<<16) + 1
+		return image.Config{Width: width, Height: height}, nil
+	}
+	return image.Config{}, fmt.Errorf("unknown webp chunk %q", chunk)
+}
@@ -0,0 +1,141 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+const htmlPayload = "<html><script>alert(1)</script></html>"
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 10), uint8(y * 10), 128, 255})
+		}
+	}
+	return img
+}
+
+func pngFixture(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func jpegFixture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(16, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gifFixture(t *testing.T, frames int) []byte {
+	t.Helper()
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9)
+		frame.SetColorIndex(i%10, i%10, uint8(i))
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// webpFixture builds a lossless WebP header of a 2x2 image. riffDelta is
+// added to the RIFF size the header claims.
+func webpFixture(riffDelta int) []byte {
+	chunk := []byte{0x2F}
+	chunk = binary.LittleEndian.AppendUint32(chunk, 1|1<<14)
+	chunk = append(chunk, 1, 2, 3, 4, 5)
+
+	body := []byte("WEBPVP8L")
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(chunk)))
+	body = append(body, chunk...)
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(body)+riffDelta))
+	return append(file, body...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestValidate(t *testing.T) {
+	pngFile := pngFixture(t, 4, 4)
+	jpegFile := jpegFixture(t)
+	gifFile := gifFixture(t, 5)
+	unverified := Limits{AllowUnverified: true}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		ext    string
+		limits Limits
+		valid  bool
+	}{
+		{"png", pngFile, "png", Limits{}, true},
+		{"jpeg", jpegFile, "jpg", Limits{}, true},
+		{"jpeg with the long extension", jpegFile, "jpeg", Limits{}, true},
+		{"gif", gifFile, "gif", Limits{}, true},
+		{"png with zero padding", concat(pngFile, make([]byte, 64)), "png", Limits{}, true},
+
+		{"png with html appended", concat(pngFile, []byte(htmlPayload)), "png", Limits{}, false},
+		{"jpeg with html appended", concat(jpegFile, []byte(htmlPayload)), "jpg", Limits{}, false},
+		{"gif with html appended", concat(gifFile, []byte(htmlPayload)), "gif", Limits{}, false},
+		{"truncated png", pngFile[:len(pngFile)-8], "png", Limits{}, false},
+		{"truncated gif", gifFile[:len(gifFile)-1], "gif", Limits{}, false},
+
+		{"png named jpg", pngFile, "jpg", Limits{}, false},
+		{"jpeg named png", jpegFile, "png", Limits{}, false},
+		{"gif named webp", gifFile, "webp", unverified, false},
+		{"html named png", []byte(htmlPayload), "png", Limits{}, false},
+		{"empty file", nil, "png", Limits{}, false},
+		{"unknown extension", pngFile, "svg", Limits{}, false},
+
+		{"png within max dimension", pngFile, "png", Limits{MaxDimension: 4}, true},
+		{"png over max dimension", pngFile, "png", Limits{MaxDimension: 3}, false},
+		{"png over max pixels", pngFile, "png", Limits{MaxPixels: 15}, false},
+		{"gif frames within max pixels", gifFile, "gif", Limits{MaxPixels: 500}, true},
+		{"gif frames over max pixels", gifFile, "gif", Limits{MaxPixels: 499}, false},
+
+		{"webp without a decoder", webpFixture(0), "webp", Limits{}, false},
+		{"unverified webp", webpFixture(0), "webp", unverified, true},
+		{"webp riff size shorter than the file", webpFixture(-2), "webp", unverified, false},
+		{"webp with html appended", concat(webpFixture(0), []byte(htmlPayload)), "webp", unverified, false},
+		{"webp riff size longer than the file", webpFixture(2), "webp", unverified, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(bytes.NewReader(tt.data), tt.ext, tt.limits)
+			if tt.valid {
+				if err != nil {
+					t.Errorf("Validate rejected a valid file: %v", err)
+				}
+				return
+			}
+			var invalid *InvalidError
+			if !errors.As(err, &invalid) {
+				t.Errorf("Validate = %v, want an InvalidError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// IPResolver determines the client IP of a request. Forwarding headers are
+// only believed when the connection comes from a trusted proxy, and then only
+// as far back as the chain of trusted proxies reaches.
+type IPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewIPResolver builds a resolver trusting the given IPs and CIDRs
+func NewIPResolver(trustedProxies []string) (*IPResolver, error) {
+	resolver := &IPResolver{}
+	for _, entry := range trustedProxies {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %q", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %q", entry)
+		}
+		resolver.trusted = append(resolver.trusted, ipNet)
+	}
+	return resolver, nil
+}
+
+// Resolve returns the client IP of the request
+func (res *IPResolver) Resolve(r *http.Request) string {
+	peer := GetIPFromAddr(r.RemoteAddr)
+	if ip := normalizeIP(peer); ip != "" {
+		peer = ip
+	}
+	if !res.isTrusted(peer) {
+		return peer
+	}
+
+	// Hops as seen by the proxies, nearest last
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = parseForwarded(forwarded)
+	} else if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		for _, value := range forwardedFor {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	} else {
+		// Proxies that send a single client address
+		for _, header := range []string{"X-Real-IP", "Cf-Connecting-Ip"} {
+			if ip := normalizeIP(r.Header.Get(header)); ip != "" {
+				return ip
+			}
+		}
+		return peer
+	}
+
+	// Walk right to left, skipping our own proxies. The first address not
+	// added by one of them is the client; anything further left is client
+	// supplied and cannot be trusted.
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := normalizeIP(hops[i])
+		if ip == "" {
+			break
+		}
+		client = ip
+		if !res.isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func (res *IPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range res.trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded returns the for= values of RFC 7239 Forwarded headers in order
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// normalizeIP strips ports, brackets and IPv4-mapped prefixes and returns an
+// empty string for anything that is not an IP, such as RFC 7239 "unknown" or
+// obfuscated identifiers
+func normalizeIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.String()
+}
+
+// WithClientIP returns a copy of the request carrying the resolved client IP
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP returns the client IP resolved for the request, or the connection's
+// peer address when no resolver has run
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return GetIPFromAddr(r.RemoteAddr)
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPResolverResolve(t *testing.T) {
+	resolver, err := NewIPResolver([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("NewIPResolver: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name: "no headers",
+			peer: "203.0.113.7:5000",
+			want: "203.0.113.7",
+		},
+		{
+			name: "untrusted peer sending X-Forwarded-For",
+			peer: "203.0.113.7:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name: "untrusted peer sending Forwarded and X-Real-IP",
+			peer: "203.0.113.7:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1"},
+				"X-Real-Ip": {"198.51.100.2"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name: "trusted peer",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "chain of trusted proxies walked right to left",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.9, 198.51.100.1, 10.0.0.3", "192.0.2.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "client-supplied entries left of the client are ignored",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.5, 198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "only trusted proxies in the chain",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"},
+			},
+			want: "10.0.0.3",
+		},
+		{
+			name: "Forwarded chain",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for=198.51.100.9;proto=https, for="198.51.100.1:4711";by=10.0.0.2`, "for=10.0.0.3"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "Forwarded takes precedence over X-Forwarded-For",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"198.51.100.2"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "unknown for value stops the walk",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1, for=unknown, for=10.0.0.2"},
+			},
+			want: "10.0.0.2",
+		},
+		{
+			name: "obfuscated for value stops the walk",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for=198.51.100.1, for="_hidden"`},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "Forwarded element without for",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1, proto=https"},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name: "bracketed IPv6 with a port",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for="[2001:db8::1]:4711"`},
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name: "IPv6 peer and IPv6 trusted proxy",
+			peer: "[fd00::1]:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"2001:DB8::2, fd00::2"},
+			},
+			want: "2001:db8::2",
+		},
+		{
+			name: "IPv4-mapped client",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"::ffff:198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "IPv4-mapped trusted peer",
+			peer: "[::ffff:10.0.0.1]:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "IPv4-mapped untrusted peer",
+			peer: "[::ffff:203.0.113.7]:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name: "X-Real-IP from a trusted peer",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Real-Ip": {"198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name: "invalid X-Real-IP from a trusted peer",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Real-Ip": {"not an ip"},
+			},
+			want: "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for name, values := range tt.headers {
+				r.Header[http.CanonicalHeaderKey(name)] = values
+			}
+			if got := resolver.Resolve(r); got != tt.want {
+				t.Errorf("Resolve = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIPResolverRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"not-an-ip", "10.0.0.0/33", ""} {
+		if _, err := NewIPResolver([]string{entry}); err == nil {
+			t.Errorf("NewIPResolver accepted %q", entry)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net"
 	"net/http"
 	"strings"
+
+	"sharex/internal/config"
 )
 
 var privateCIDRs = []string{
@@ -55,43 +60,13 @@ func IsPrivateIP(ipStr string) bool {
 	return false
 }
 
-// GetIPFromAddr extracts the IP address from an HTTP request or other sources
+// GetIPFromAddr extracts the IP address from an HTTP request or other sources.
+// For requests this is the client IP resolved by the IPResolver.
 func GetIPFromAddr(addr interface{}) string {
 	switch v := addr.(type) {
 	case *http.Request:
-		// Try to get the real IP from various headers
-		// Check Cloudflare headers first
-		if cfIP := v.Header.Get("Cf-Connecting-Ip"); cfIP != "" {
-			return cfIP
-		}
-
-		// Check X-Forwarded-For
-		if forwardedFor := v.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-			// X-Forwarded-For can contain multiple IPs, take the first one
-			ips := strings.Split(forwardedFor, ",")
-			if len(ips) > 0 {
-				return strings.TrimSpace(ips[0])
-			}
-		}
-
-		// Check X-Real-IP
-		if realIP := v.Header.Get("X-Real-IP"); realIP != "" {
-			return realIP
-		}
-
-		// Check X-Client-IP
-		if clientIP := v.Header.Get("X-Client-IP"); clientIP != "" {
-			return clientIP
-		}
-
-		// If no headers are present, use RemoteAddr
-		if v.RemoteAddr != "" {
-			ip, _, err := net.SplitHostPort(v.RemoteAddr)
-			if err == nil {
-				return ip
-			}
-			return v.RemoteAddr
-		}
+		// Forwarding headers are only honored through the trusted-proxy resolver
+		return ClientIP(v)
 
 	case string:
 		// If it's already a string, try to extract IP from "ip:port" format
@@ -117,6 +92,27 @@ func GetIPFromAddr(addr interface{}) string {
 	default:
 		return ""
 	}
+}
 
-	return ""
+// AnonymizeIP reduces an IP address according to the configured anonymization mode.
+// "truncate" zeroes everything past the /24 (IPv4) or /48 (IPv6) prefix, "hash"
+// replaces the address with a keyed HMAC-SHA256 digest and "none" returns it as is.
+func AnonymizeIP(ipStr, mode, key string) string {
+	switch mode {
+	case config.IPAnonymizationTruncate:
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return ipStr
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	case config.IPAnonymizationHash:
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(ipStr))
+		return "h:" + hex.EncodeToString(mac.Sum(nil))[:32]
+	default:
+		return ipStr
+	}
 }
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
@@ -12,23 +14,36 @@ import (
 type TokenType string
 
 const (
-	AccessToken  TokenType = "access"
-	RefreshToken TokenType = "refresh"
+	AccessToken        TokenType = "access"
+	RefreshToken       TokenType = "refresh"
+	TwoFactorChallenge TokenType = "2fa_challenge"
+)
+
+// TwoFactorChallengeTTL is how long a user has to enter their second factor
+const TwoFactorChallengeTTL = 5 * time.Minute
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
 )
 
 type Claims struct {
 	Username  string    `json:"username"`
 	TokenType TokenType `json:"token_type"`
+	SessionID string    `json:"sid,omitempty"` // Server-side session the token belongs to
 	jwt.RegisteredClaims
 }
 
-func GenerateTokenPair(username, secret string) (string, string, error) {
+// GenerateTokenPair issues an access token and a refresh token for a session.
+// The refresh token carries refreshID so it can be rotated and revoked.
+func GenerateTokenPair(username, sessionID, refreshID, secret string) (string, string, error) {
 	// Generate access token (15 minutes)
 	accessClaims := &Claims{
 		Username:  username,
 		TokenType: AccessToken,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -43,8 +58,10 @@ func GenerateTokenPair(username, secret string) (string, string, error) {
 	refreshClaims := &Claims{
 		Username:  username,
 		TokenType: RefreshToken,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+			ID:        refreshID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(RefreshTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -58,6 +75,36 @@ func GenerateTokenPair(username, secret string) (string, string, error) {
 	return accessTokenString, refreshTokenString, nil
 }
 
+// GenerateTokenID returns a random identifier for sessions and refresh tokens
+func GenerateTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// GenerateChallengeToken issues a short-lived token proving the password step
+// of a two-step login succeeded. It cannot be used as an access token.
+func GenerateChallengeToken(username, secret string) (string, error) {
+	id, err := GenerateTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		Username:  username,
+		TokenType: TwoFactorChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TwoFactorChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
 func ValidateToken(tokenString, secret string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -85,7 +132,7 @@ func SetTokenCookies(w http.ResponseWriter, accessToken, refreshToken string, se
 		HttpOnly: true,
 		Secure:   secure,
 		SameSite: http.SameSiteStrictMode,
-		MaxAge:   15 * 60, // 15 minutes
+		MaxAge:   int(AccessTokenTTL.Seconds()),
 	})
 
 	http.SetCookie(w, &http.Cookie{
@@ -95,7 +142,7 @@ func SetTokenCookies(w http.ResponseWriter, accessToken, refreshToken string, se
 		HttpOnly: true,
 		Secure:   secure,
 		SameSite: http.SameSiteStrictMode,
-		MaxAge:   7 * 24 * 60 * 60, // 7 days
+		MaxAge:   int(RefreshTokenTTL.Seconds()),
 	})
 }
 
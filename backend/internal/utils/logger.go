@@ -8,9 +8,13 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"sharex/internal/config"
+	"sharex/internal/ratelimit"
 
 	"github.com/common-nighthawk/go-figure"
 )
@@ -43,12 +47,34 @@ type LogEntry struct {
 
 type Logger struct {
 	config   *config.Config
+	levels   atomic.Pointer[levelOutputs]
+	mu       sync.Mutex // guards writers, rotators and files
 	writers  map[LogLevel]io.Writer
 	rotators map[LogLevel]*LogRotator
 	files    map[LogLevel]*os.File
 	stopChan chan struct{}
 }
 
+// levelOutput is where entries of a level go
+type levelOutput struct {
+	enabled bool
+	console bool
+}
+
+// levelOutputs holds the output of every level. It is replaced as a whole
+// when the configuration is reloaded.
+type levelOutputs map[LogLevel]levelOutput
+
+func newLevelOutputs(cfg *config.Config) *levelOutputs {
+	development := cfg.App.Environment == "development"
+	return &levelOutputs{
+		DEBUG: {cfg.Logging.Debug.Enabled, cfg.Logging.Debug.ConsoleOutput && development},
+		INFO:  {cfg.Logging.Info.Enabled, cfg.Logging.Info.ConsoleOutput && development},
+		WARN:  {cfg.Logging.Warn.Enabled, cfg.Logging.Warn.ConsoleOutput && development},
+		ERROR: {cfg.Logging.Error.Enabled, cfg.Logging.Error.ConsoleOutput && development},
+	}
+}
+
 func NewLogger(cfg *config.Config) (*Logger, error) {
 	// If logging is disabled globally, return a disabled logger
 	if !cfg.Logging.Enabled {
@@ -86,54 +112,19 @@ func NewLogger(cfg *config.Config) (*Logger, error) {
 		}
 	}
 
-	writers := make(map[LogLevel]io.Writer)
-	rotators := make(map[LogLevel]*LogRotator)
-	files := make(map[LogLevel]*os.File)
-
-	// Initialize writers for each log level
-	for level, levelConfig := range map[LogLevel]struct {
-		enabled       bool
-		consoleOutput bool
-		file          string
-	}{
-		DEBUG: {cfg.Logging.Debug.Enabled, cfg.Logging.Debug.ConsoleOutput && cfg.App.Environment == "development", cfg.Logging.Debug.File},
-		INFO:  {cfg.Logging.Info.Enabled, cfg.Logging.Info.ConsoleOutput && cfg.App.Environment == "development", cfg.Logging.Info.File},
-		WARN:  {cfg.Logging.Warn.Enabled, cfg.Logging.Warn.ConsoleOutput && cfg.App.Environment == "development", cfg.Logging.Warn.File},
-		ERROR: {cfg.Logging.Error.Enabled, cfg.Logging.Error.ConsoleOutput && cfg.App.Environment == "development", cfg.Logging.Error.File},
-	} {
-		if !levelConfig.enabled {
-			continue
-		}
-
-		// Add file writer
-		filePath := filepath.Join(cfg.Logging.LogDir, levelConfig.file)
-		file, err := openLogFile(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open log file for level %s: %w", level, err)
-		}
-		writers[level] = file
-		if f, ok := file.(*os.File); ok {
-			files[level] = f
-		}
-
-		// Create log rotator
-		rotator := NewLogRotator(
-			cfg.Logging.LogDir,
-			maxLogSize,
-			cfg.Logging.MaxLogAge,
-			cfg.Logging.CompressLogs,
-		)
-		rotators[level] = rotator
-	}
-
 	logger := &Logger{
 		config:   cfg,
-		writers:  writers,
-		rotators: rotators,
-		files:    files,
+		writers:  make(map[LogLevel]io.Writer),
+		rotators: make(map[LogLevel]*LogRotator),
+		files:    make(map[LogLevel]*os.File),
 		stopChan: make(chan struct{}),
 	}
 
+	// Initialize writers for each log level
+	if err := logger.SetLevels(cfg); err != nil {
+		return nil, err
+	}
+
 	// Start background cleanup routine
 	go logger.startCleanupRoutine()
 
@@ -160,6 +151,50 @@ func InitializeLogger(cfg *config.Config) (*Logger, error) {
 	return logger, nil
 }
 
+// SetLevels applies the enabled and console_output settings of every level,
+// opening the files of levels that were disabled until now
+func (l *Logger) SetLevels(cfg *config.Config) error {
+	if !l.config.Logging.Enabled {
+		return nil
+	}
+	maxLogSize, err := l.config.GetMaxLogSize()
+	if err != nil {
+		return fmt.Errorf("invalid max_log_size: %w", err)
+	}
+
+	levels := newLevelOutputs(cfg)
+	l.mu.Lock()
+	for level, output := range *levels {
+		if _, open := l.writers[level]; open || !output.enabled {
+			continue
+		}
+
+		// Add file writer
+		filePath := filepath.Join(l.config.Logging.LogDir, l.getLogFileName(level))
+		file, err := openLogFile(filePath)
+		if err != nil {
+			l.mu.Unlock()
+			return fmt.Errorf("failed to open log file for level %s: %w", level, err)
+		}
+		l.writers[level] = file
+		if f, ok := file.(*os.File); ok {
+			l.files[level] = f
+		}
+
+		// Create log rotator
+		l.rotators[level] = NewLogRotator(
+			l.config.Logging.LogDir,
+			maxLogSize,
+			l.config.Logging.MaxLogAge,
+			l.config.Logging.CompressLogs,
+		)
+	}
+	l.mu.Unlock()
+
+	l.levels.Store(levels)
+	return nil
+}
+
 func openLogFile(path string) (io.Writer, error) {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -181,23 +216,13 @@ func (l *Logger) log(level LogLevel, message string, data interface{}) {
 	}
 
 	// Check if the specific log level is enabled
-	var levelEnabled bool
-	switch level {
-	case DEBUG:
-		levelEnabled = l.config.Logging.Debug.Enabled
-	case INFO:
-		levelEnabled = l.config.Logging.Info.Enabled
-	case WARN:
-		levelEnabled = l.config.Logging.Warn.Enabled
-	case ERROR:
-		levelEnabled = l.config.Logging.Error.Enabled
-	default:
+	output := (*l.levels.Load())[level]
+	if !output.enabled {
 		return
 	}
 
-	if !levelEnabled {
-		return
-	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	// Check if rotation is needed before any write operation
 	if rotator, exists := l.rotators[level]; exists {
@@ -266,28 +291,19 @@ func (l *Logger) log(level LogLevel, message string, data interface{}) {
 	}
 
 	// Only output to console in development environment
-	if l.config.App.Environment == "development" {
+	if output.console {
 		var color string
-		var shouldOutput bool
-
 		switch level {
 		case DEBUG:
 			color = colorBlue
-			shouldOutput = l.config.Logging.Debug.ConsoleOutput
 		case INFO:
 			color = colorWhite
-			shouldOutput = l.config.Logging.Info.ConsoleOutput
 		case WARN:
 			color = colorYellow
-			shouldOutput = l.config.Logging.Warn.ConsoleOutput
 		case ERROR:
 			color = colorRed
-			shouldOutput = l.config.Logging.Error.ConsoleOutput
-		}
-
-		if shouldOutput {
-			fmt.Fprintf(os.Stdout, "%s%s%s\n", color, string(jsonData), colorReset)
 		}
+		fmt.Fprintf(os.Stdout, "%s%s%s\n", color, string(jsonData), colorReset)
 	}
 }
 
@@ -515,38 +531,47 @@ func formatBytes(bytes uint64) string {
 }
 
 // formatRateLimitStatus returns a formatted string showing rate limiting configuration
-func formatRateLimitStatus(rl struct {
-	Enabled     bool   `yaml:"enabled"`
-	RedisURL    string `yaml:"redis_url"`
-	DefaultRate struct {
-		Requests int   `yaml:"requests"`
-		Period   int64 `yaml:"period"` // in seconds
-	} `yaml:"default_rate"`
-	Routes map[string]struct {
-		Requests int   `yaml:"requests"`
-		Period   int64 `yaml:"period"` // in seconds
-	} `yaml:"routes"`
-}) string {
+func formatRateLimitStatus(rl config.RateLimitConfig) string {
 	if !rl.Enabled {
 		return "Disabled"
 	}
 
-	status := fmt.Sprintf("Enabled (Redis: %s)\n", rl.RedisURL)
-	status += fmt.Sprintf("  Default Rate: %d requests per %d seconds\n", rl.DefaultRate.Requests, rl.DefaultRate.Period)
+	status := fmt.Sprintf("Enabled (store: %s, algorithm: %s)\n", rl.Store, rl.Algorithm)
+	if rl.Store == "redis" {
+		status += fmt.Sprintf("  Redis: %s (fallback: %s)\n", rl.RedisURL, rl.Fallback)
+	}
+	policies, err := ratelimit.NewPolicies(rl)
+	if err != nil {
+		return status + fmt.Sprintf("  Invalid policies: %v\n", err)
+	}
 
-	if len(rl.Routes) > 0 {
-		status += "  Route-specific limits:\n"
-		for route, limit := range rl.Routes {
-			status += fmt.Sprintf("    %s: %d requests per %d seconds\n", route, limit.Requests, limit.Period)
+	status += fmt.Sprintf("  Default: %s\n", policies.Default())
+	if rules := policies.Rules(); len(rules) > 0 {
+		status += "  Route policies (in match order):\n"
+		for _, policy := range rules {
+			status += fmt.Sprintf("    %s: %s\n", policy.Name, policy)
 		}
 	}
+	if len(rl.ExemptIPs) > 0 {
+		status += fmt.Sprintf("  Exempt IPs: %s\n", strings.Join(rl.ExemptIPs, ", "))
+	}
+	if len(rl.ExemptUsers) > 0 {
+		status += fmt.Sprintf("  Exempt users: %s\n", strings.Join(rl.ExemptUsers, ", "))
+	}
 
 	return status
 }
 
 func (l *Logger) runCleanup() error {
-	// Check and rotate all log files
+	l.mu.Lock()
+	rotators := make(map[LogLevel]*LogRotator, len(l.rotators))
 	for level, rotator := range l.rotators {
+		rotators[level] = rotator
+	}
+	l.mu.Unlock()
+
+	// Check and rotate all log files
+	for level, rotator := range rotators {
 		filePath := filepath.Join(l.config.Logging.LogDir, l.getLogFileName(level))
 
 		maxLogSize, err := l.config.GetMaxLogSize()
@@ -566,18 +591,22 @@ func (l *Logger) runCleanup() error {
 				})
 
 				// Close the current file before rotation
+				l.mu.Lock()
 				if file, ok := l.files[level]; ok {
 					file.Close()
 					delete(l.files, level)
 				}
 
 				if err := rotator.RotateIfNeeded(filePath); err != nil {
+					l.mu.Unlock()
 					l.logError("Failed to rotate log file during cleanup", err)
 					continue
 				}
 
 				// Reopen the file after rotation
-				if err := l.reopenFile(level); err != nil {
+				err := l.reopenFile(level)
+				l.mu.Unlock()
+				if err != nil {
 					l.logError("Failed to reopen log file after rotation", err)
 					continue
 				}
@@ -600,7 +629,7 @@ func (l *Logger) runCleanup() error {
 	}
 
 	// Run cleanup for all rotators
-	for _, rotator := range l.rotators {
+	for _, rotator := range rotators {
 		if err := rotator.CleanupOldArchives(); err != nil {
 			l.logError("Failed to cleanup old archives", err)
 		}
@@ -645,6 +674,8 @@ func (l *Logger) Close() error {
 	}
 
 	// Close all files
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	for _, file := range l.files {
 		if err := file.Close(); err != nil {
 			return err
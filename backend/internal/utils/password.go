@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 600000
+	passwordKeyLength  = 32
+)
+
+// HashPassword hashes a password as pbkdf2-sha256$<iterations>$<salt>$<hash>.
+// It is used for local account passwords and for the viewer passwords of
+// private images.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// IsPasswordHash reports whether a stored password is already hashed
+func IsPasswordHash(value string) bool {
+	return strings.HasPrefix(value, passwordScheme+"$")
+}
+
+// CheckPassword compares a password with a stored hash. A password an older
+// version stored verbatim is compared as it is until it gets hashed.
+func CheckPassword(password, encoded string) bool {
+	if encoded != "" && !IsPasswordHash(encoded) {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) == 1
+	}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(hash) {
+		t.Fatalf("HashPassword returned %q, not a recognized hash", hash)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		want     bool
+	}{
+		{"hash", "secret", hash, true},
+		{"wrong password", "other", hash, false},
+		{"legacy plain text", "viewer", "viewer", true},
+		{"wrong legacy password", "other", "viewer", false},
+		{"no password stored", "", "", false},
+		{"malformed hash", "viewer", "pbkdf2-sha256$x$y$z", false},
+	}
+	for _, tt := range tests {
+		if got := CheckPassword(tt.password, tt.encoded); got != tt.want {
+			t.Errorf("%s: CheckPassword = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Purposes a share token can be signed for. Tokens signed for one purpose are
+// rejected for the other.
+const (
+	ShareCookie = "cookie"
+	ShareLink   = "link"
+)
+
+// SignShareToken grants access to one private image until expires, or for
+// good when expires is zero. The signature covers the stored password hash,
+// so changing or removing the password revokes every token issued for it.
+// Tokens have the form <expiry unix seconds>.<signature>.
+func SignShareToken(secret, purpose, uuid, passwordHash string, expires time.Time) string {
+	var exp int64
+	if !expires.IsZero() {
+		exp = expires.Unix()
+	}
+	return strconv.FormatInt(exp, 10) + "." + shareSignature(secret, purpose, uuid, passwordHash, exp)
+}
+
+// VerifyShareToken checks a token made by SignShareToken
+func VerifyShareToken(secret, purpose, uuid, passwordHash, token string) bool {
+	expPart, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expPart, 10, 64)
+	if err != nil || exp < 0 {
+		return false
+	}
+	if exp != 0 && time.Now().Unix() > exp {
+		return false
+	}
+	expected := shareSignature(secret, purpose, uuid, passwordHash, exp)
+	return hmac.Equal([]byte(sig), []byte(expected))
+}
+
+func shareSignature(secret, purpose, uuid, passwordHash string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "share\x00%s\x00%s\x00%d\x00%s", purpose, uuid, exp, passwordHash)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
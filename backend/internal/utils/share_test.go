@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareTokens(t *testing.T) {
+	const secret = "jwt-secret"
+	hash, err := HashPassword("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash, err := HashPassword("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		check func(token string) bool
+		valid bool
+	}{
+		{
+			name:  "cookie",
+			token: SignShareToken(secret, ShareCookie, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareCookie, "img1", hash, token) },
+			valid: true,
+		},
+		{
+			name:  "link without expiry",
+			token: SignShareToken(secret, ShareLink, "img1", hash, time.Time{}),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+			valid: true,
+		},
+		{
+			name:  "cookie used as a link",
+			token: SignShareToken(secret, ShareCookie, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+		{
+			name:  "link used as a cookie",
+			token: SignShareToken(secret, ShareLink, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareCookie, "img1", hash, token) },
+		},
+		{
+			name:  "expired",
+			token: SignShareToken(secret, ShareLink, "img1", hash, time.Now().Add(-time.Minute)),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+		{
+			name:  "expiry extended",
+			token: extendExpiry(SignShareToken(secret, ShareLink, "img1", hash, time.Now().Add(-time.Minute))),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+		{
+			name:  "password changed",
+			token: SignShareToken(secret, ShareLink, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", otherHash, token) },
+		},
+		{
+			name:  "password removed",
+			token: SignShareToken(secret, ShareLink, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", "", token) },
+		},
+		{
+			name:  "other image",
+			token: SignShareToken(secret, ShareLink, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img2", hash, token) },
+		},
+		{
+			name:  "other secret",
+			token: SignShareToken("other-secret", ShareLink, "img1", hash, hour),
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+		{
+			name:  "malformed",
+			token: "not-a-token",
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+		{
+			name:  "negative expiry",
+			token: "-1.signature",
+			check: func(token string) bool { return VerifyShareToken(secret, ShareLink, "img1", hash, token) },
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.check(tt.token); got != tt.valid {
+			t.Errorf("%s: VerifyShareToken = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+// extendExpiry moves a token's expiry a day ahead without re-signing it
+func extendExpiry(token string) string {
+	_, sig, _ := strings.Cut(token, ".")
+	return strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10) + "." + sig
+}
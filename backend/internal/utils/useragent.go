@@ -0,0 +1,63 @@
+package utils
+
+import "strings"
+
+// Bot classes assigned to recorded views based on their user agent
+const (
+	BotClassHuman   = "human"
+	BotClassBot     = "bot"     // Crawlers, scripts and monitoring tools
+	BotClassPreview = "preview" // Link unfurlers fetching a preview for a chat or social post
+)
+
+var previewAgents = []string{
+	"discordbot",
+	"slackbot",
+	"slack-imgproxy",
+	"twitterbot",
+	"facebookexternalhit",
+	"telegrambot",
+	"whatsapp",
+	"linkedinbot",
+	"skypeuripreview",
+	"mattermost",
+	"embedly",
+}
+
+var botAgents = []string{
+	"bot",
+	"crawler",
+	"spider",
+	"slurp",
+	"curl/",
+	"wget/",
+	"python-requests",
+	"python-urllib",
+	"go-http-client",
+	"java/",
+	"okhttp",
+	"headlesschrome",
+	"phantomjs",
+	"uptime",
+	"monitor",
+}
+
+// ClassifyUserAgent returns the bot class for a user agent string
+func ClassifyUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return BotClassBot
+	}
+
+	// Check previews first, most of them also contain "bot"
+	for _, agent := range previewAgents {
+		if strings.Contains(ua, agent) {
+			return BotClassPreview
+		}
+	}
+	for _, agent := range botAgents {
+		if strings.Contains(ua, agent) {
+			return BotClassBot
+		}
+	}
+	return BotClassHuman
+}
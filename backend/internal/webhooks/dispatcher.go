@@ -0,0 +1,442 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sharex/internal/config"
+	"sharex/internal/events"
+	"sharex/internal/health"
+	"sharex/internal/models"
+	"sharex/internal/storage"
+	"sharex/internal/utils"
+)
+
+// Webhook event names
+const (
+	EventUploadCreated       = "upload.created"
+	EventImageDeleted        = "image.deleted"
+	EventImagePrivacyChanged = "image.privacy_changed"
+	EventImageViewed         = "image.viewed"
+	EventQuotaWarning        = "quota.warning"
+)
+
+// EventNames lists every event a webhook can subscribe to
+var EventNames = []string{
+	EventUploadCreated,
+	EventImageDeleted,
+	EventImagePrivacyChanged,
+	EventImageViewed,
+	EventQuotaWarning,
+}
+
+// brokerEvents maps live event types to webhook event names
+var brokerEvents = map[string]string{
+	events.TypeUpload:  EventUploadCreated,
+	events.TypeDelete:  EventImageDeleted,
+	events.TypePrivacy: EventImagePrivacyChanged,
+	events.TypeView:    EventImageViewed,
+	events.TypeQuota:   EventQuotaWarning,
+}
+
+// Signature headers sent with every delivery. The signature is the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+const (
+	HeaderEvent     = "X-Llmstor-Event"
+	HeaderDelivery  = "X-Llmstor-Delivery"
+	HeaderTimestamp = "X-Llmstor-Timestamp"
+	HeaderSignature = "X-Llmstor-Signature"
+)
+
+const (
+	pollInterval  = 2 * time.Second
+	purgeInterval = time.Hour
+	batchSize     = 20
+	queueSize     = 1024
+	maxRetryDelay = 6 * time.Hour
+)
+
+// queuedEvent is a broker event waiting to be stored as deliveries
+type queuedEvent struct {
+	name    string
+	event   events.Event
+	targets []models.Webhook
+}
+
+// Dispatcher turns live events into persisted deliveries and sends them from a
+// background worker, retrying failures with exponential backoff
+type Dispatcher struct {
+	config    *config.Config
+	db        *storage.DB
+	logger    *utils.Logger
+	client    *http.Client
+	heartbeat *health.Heartbeat
+	queue     chan queuedEvent
+	wake      chan struct{}
+	stopChan  chan struct{}
+	workers   sync.WaitGroup
+	lastPurge time.Time
+
+	mu       sync.RWMutex
+	webhooks []models.Webhook
+}
+
+func NewDispatcher(cfg *config.Config, db *storage.DB, logger *utils.Logger) *Dispatcher {
+	return &Dispatcher{
+		config:    cfg,
+		db:        db,
+		logger:    logger,
+		client:    &http.Client{Timeout: time.Duration(cfg.Webhooks.Timeout) * time.Second},
+		heartbeat: health.NewHeartbeat("webhooks", pollInterval),
+		queue:     make(chan queuedEvent, queueSize),
+		wake:      make(chan struct{}, 1),
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// IsValidEvent reports whether name is a known webhook event
+func IsValidEvent(name string) bool {
+	for _, event := range EventNames {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start loads the webhooks, listens for broker events and starts the workers
+// that store and send deliveries
+func (d *Dispatcher) Start(broker *events.Broker) error {
+	if err := d.Reload(); err != nil {
+		return err
+	}
+	broker.AddListener(d.handleEvent)
+	d.workers.Add(2)
+	go d.consume()
+	go d.run()
+	return nil
+}
+
+// Reload refreshes the cached webhook list, call it after webhooks change
+func (d *Dispatcher) Reload() error {
+	webhooks, err := d.db.ListWebhooks()
+	if err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+
+	d.mu.Lock()
+	d.webhooks = webhooks
+	d.mu.Unlock()
+	return nil
+}
+
+// handleEvent queues an event that enabled webhooks subscribe to. It runs
+// inside Broker.Publish, on the request path of views and uploads, so the
+// deliveries are stored by another goroutine. Events are dropped while the
+// queue is full rather than slowing requests down.
+func (d *Dispatcher) handleEvent(event events.Event) {
+	name, ok := brokerEvents[event.Type]
+	if !ok {
+		return
+	}
+
+	d.mu.RLock()
+	var targets []models.Webhook
+	for _, webhook := range d.webhooks {
+		if webhook.Enabled && subscribed(webhook, name) {
+			targets = append(targets, webhook)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	select {
+	case d.queue <- queuedEvent{name: name, event: event, targets: targets}:
+	default:
+		d.logger.Warn("Webhook event queue is full, dropping event", map[string]interface{}{
+			"event":    name,
+			"event_id": event.ID,
+		})
+	}
+}
+
+// consume stores the queued events as deliveries until the dispatcher is
+// closed, then stores what is left in the queue
+func (d *Dispatcher) consume() {
+	defer d.workers.Done()
+	for {
+		select {
+		case queued := <-d.queue:
+			d.enqueueEvent(queued.name, queued.event, queued.targets)
+		case <-d.stopChan:
+			for {
+				select {
+				case queued := <-d.queue:
+					d.enqueueEvent(queued.name, queued.event, queued.targets)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// enqueueEvent stores a delivery of the event for every target webhook
+func (d *Dispatcher) enqueueEvent(name string, event events.Event, targets []models.Webhook) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      name,
+		"event_id":   event.ID,
+		"created_at": event.Time.UTC().Format(time.RFC3339),
+		"data":       event.Data,
+	})
+	if err != nil {
+		d.logger.Error("Failed to encode webhook payload", map[string]interface{}{
+			"error": err.Error(),
+			"event": name,
+		})
+		return
+	}
+
+	for _, webhook := range targets {
+		if _, err := d.Enqueue(webhook.ID, name, string(payload)); err != nil {
+			d.logger.Error("Failed to enqueue webhook delivery", map[string]interface{}{
+				"error":      err.Error(),
+				"webhook_id": webhook.ID,
+				"event":      name,
+			})
+		}
+	}
+}
+
+// Enqueue persists a new pending delivery and wakes the worker
+func (d *Dispatcher) Enqueue(webhookID int64, eventType, payload string) (*models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	delivery := &models.WebhookDelivery{
+		WebhookID:     webhookID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        storage.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := d.db.CreateWebhookDelivery(delivery); err != nil {
+		return nil, err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return delivery, nil
+}
+
+func (d *Dispatcher) run() {
+	defer d.workers.Done()
+	defer d.heartbeat.Stop()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.heartbeat.Beat()
+		d.deliverDue()
+		if time.Since(d.lastPurge) >= purgeInterval {
+			d.purge()
+			d.lastPurge = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+// deliverDue sends every delivery whose next attempt is due, in batches
+func (d *Dispatcher) deliverDue() {
+	for {
+		deliveries, err := d.db.GetDueWebhookDeliveries(time.Now().UTC(), batchSize)
+		if err != nil {
+			d.logger.Error("Failed to load due webhook deliveries", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		for i := range deliveries {
+			select {
+			case <-d.stopChan:
+				return
+			default:
+			}
+			// A backlog of slow endpoints can take longer than the interval
+			d.heartbeat.Beat()
+			d.attempt(&deliveries[i])
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *Dispatcher) attempt(delivery *models.WebhookDelivery) {
+	webhook, err := d.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		d.logger.Error("Failed to get webhook", map[string]interface{}{
+			"error":      err.Error(),
+			"webhook_id": delivery.WebhookID,
+		})
+		return
+	}
+
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now().UTC()
+
+	if webhook == nil || !webhook.Enabled {
+		delivery.Status = storage.DeliveryFailed
+		delivery.LastError = "webhook deleted or disabled"
+	} else {
+		statusCode, sendErr := d.send(webhook, delivery)
+		delivery.LastStatusCode = statusCode
+		switch {
+		case sendErr == nil:
+			delivery.Status = storage.DeliverySucceeded
+			delivery.LastError = ""
+		case delivery.Attempts >= d.config.Webhooks.MaxAttempts:
+			delivery.Status = storage.DeliveryFailed
+			delivery.LastError = sendErr.Error()
+		default:
+			delivery.LastError = sendErr.Error()
+			delivery.NextAttemptAt = delivery.UpdatedAt.Add(d.retryDelay(delivery.Attempts))
+		}
+	}
+
+	if delivery.Status != storage.DeliverySucceeded {
+		d.logger.Warn("Webhook delivery failed", map[string]interface{}{
+			"webhook_id":  delivery.WebhookID,
+			"delivery_id": delivery.ID,
+			"event":       delivery.EventType,
+			"attempts":    delivery.Attempts,
+			"status":      delivery.Status,
+			"error":       delivery.LastError,
+		})
+	}
+
+	if err := d.db.UpdateWebhookDelivery(delivery); err != nil {
+		d.logger.Error("Failed to update webhook delivery", map[string]interface{}{
+			"error":       err.Error(),
+			"delivery_id": delivery.ID,
+		})
+	}
+}
+
+// purge deletes delivered and failed deliveries older than the retention
+// period. Pending ones are kept until they are sent or give up.
+func (d *Dispatcher) purge() {
+	if d.config.Webhooks.RetentionDays <= 0 {
+		return
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -d.config.Webhooks.RetentionDays)
+
+	purged, err := d.db.PurgeWebhookDeliveries(cutoff)
+	if err != nil {
+		d.logger.Error("Failed to purge webhook deliveries", map[string]interface{}{
+			"error":  err.Error(),
+			"cutoff": cutoff.Format(time.RFC3339),
+		})
+		return
+	}
+	if purged > 0 {
+		d.logger.Info("Purged webhook deliveries", map[string]interface{}{
+			"purged": purged,
+			"cutoff": cutoff.Format(time.RFC3339),
+		})
+	}
+}
+
+// retryDelay doubles the configured delay for every failed attempt, up to a cap
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := time.Duration(d.config.Webhooks.RetryDelay) * time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// send posts the signed payload and treats any 2xx response as success
+func (d *Dispatcher) send(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "llmstor-webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a payload
+func Sign(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Heartbeat reports whether the delivery worker is still running
+func (d *Dispatcher) Heartbeat() *health.Heartbeat {
+	return d.heartbeat
+}
+
+// Close stops the workers, storing the events still queued, and waits for
+// the current attempt to finish
+func (d *Dispatcher) Close() {
+	close(d.stopChan)
+	d.workers.Wait()
+}
+
+func subscribed(webhook models.Webhook, name string) bool {
+	for _, event := range webhook.Events {
+		if event == name || event == "*" {
+			return true
+		}
+	}
+	return false
+}
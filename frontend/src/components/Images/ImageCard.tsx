@@ -66,10 +66,10 @@ const ImageCard = ({ image, onTogglePrivacy, onDelete }: ImageCardProps) => {
     if (image.uuid && image.uuid.startsWith("temp-")) return image.url || "";
 
     if (forSharing) {
-      const baseUrl = `${window.location.origin}/${image.uuid}.${image.extension}`;
-      return image.isPrivate && image.privateKey
-        ? `${baseUrl}?key=${btoa(image.privateKey)}`
-        : baseUrl;
+      // Private images come with a signed link from the API
+      return `${window.location.origin}${
+        image.url || `/${image.uuid}.${image.extension}`
+      }`;
     }
 
     if (isAuthenticated) {
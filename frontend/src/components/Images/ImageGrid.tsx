@@ -99,19 +99,9 @@ const ImageGrid = ({ newImage, isUploading = false }: ImageGridProps) => {
             ? {
                 ...image,
                 isPrivate: updatedImage.is_private ?? updatedImage.isPrivate,
-                privateKey:
-                  updatedImage.private_key ?? updatedImage.privateKey ?? "",
                 url:
-                  (updatedImage.is_private ?? updatedImage.isPrivate) &&
-                  (updatedImage.private_key ?? updatedImage.privateKey)
-                    ? `/${updatedImage.uuid}.${
-                        updatedImage.extension
-                      }?key=${btoa(
-                        updatedImage.private_key ??
-                          updatedImage.privateKey ??
-                          ""
-                      )}`
-                    : `/${updatedImage.uuid}.${updatedImage.extension}`,
+                  updatedImage.url ??
+                  `/${updatedImage.uuid}.${updatedImage.extension}`,
               }
             : image
         )
@@ -12,12 +12,13 @@ import {
 } from "@/components/ui/card";
 import { Label } from "@/components/ui/label";
 import { Eye, EyeOff } from "lucide-react";
+import TwoFactorForm from "./TwoFactorForm";
 
 const LoginForm = () => {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [showPassword, setShowPassword] = useState(false);
-  const { login, isLoading } = useAuth();
+  const { login, isLoading, twoFactorPending } = useAuth();
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -28,6 +29,10 @@ const LoginForm = () => {
     setShowPassword((prev) => !prev);
   };
 
+  if (twoFactorPending) {
+    return <TwoFactorForm />;
+  }
+
   return (
     <Card className="w-full max-w-md animate-fade-in">
       <CardHeader>
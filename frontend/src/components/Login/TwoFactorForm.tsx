@@ -0,0 +1,99 @@
+import { useState } from "react";
+import { useAuth } from "@/context/AuthContext";
+import { useToast } from "@/hooks/use-toast";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import {
+  Card,
+  CardContent,
+  CardDescription,
+  CardFooter,
+  CardHeader,
+  CardTitle,
+} from "@/components/ui/card";
+import { Label } from "@/components/ui/label";
+
+// Second login step for accounts with two-factor authentication
+const TwoFactorForm = () => {
+  const [code, setCode] = useState("");
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
+  const { verifyTwoFactor, cancelTwoFactor, isLoading } = useAuth();
+  const { toast } = useToast();
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      await verifyTwoFactor(code.trim(), useRecoveryCode);
+    } catch (error) {
+      setCode("");
+      toast({
+        title: "Sign in failed",
+        description: useRecoveryCode
+          ? "The recovery code is invalid or was already used."
+          : "The code is invalid or was already used.",
+        variant: "destructive",
+      });
+    }
+  };
+
+  const toggleRecoveryCode = () => {
+    setUseRecoveryCode((prev) => !prev);
+    setCode("");
+  };
+
+  return (
+    <Card className="w-full max-w-md animate-fade-in">
+      <CardHeader>
+        <CardTitle className="text-2xl">Two-factor authentication</CardTitle>
+        <CardDescription>
+          {useRecoveryCode
+            ? "Enter one of your recovery codes"
+            : "Enter the code from your authenticator app"}
+        </CardDescription>
+      </CardHeader>
+      <form onSubmit={handleSubmit}>
+        <CardContent className="space-y-4">
+          <div className="space-y-2">
+            <Label htmlFor="code">
+              {useRecoveryCode ? "Recovery code" : "Code"}
+            </Label>
+            <Input
+              id="code"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              autoComplete="one-time-code"
+              inputMode={useRecoveryCode ? "text" : "numeric"}
+              autoFocus
+              required
+            />
+          </div>
+          <button
+            type="button"
+            className="text-sm text-muted-foreground hover:underline"
+            onClick={toggleRecoveryCode}
+          >
+            {useRecoveryCode
+              ? "Use a code from your authenticator app"
+              : "Use a recovery code"}
+          </button>
+        </CardContent>
+        <CardFooter className="flex flex-col gap-2">
+          <Button className="w-full" type="submit" disabled={isLoading}>
+            {isLoading ? "Verifying..." : "Verify"}
+          </Button>
+          <Button
+            className="w-full"
+            type="button"
+            variant="ghost"
+            onClick={cancelTwoFactor}
+            disabled={isLoading}
+          >
+            Back
+          </Button>
+        </CardFooter>
+      </form>
+    </Card>
+  );
+};
+
+export default TwoFactorForm;
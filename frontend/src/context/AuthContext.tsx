@@ -1,6 +1,6 @@
 import { createContext, useContext, useState, useEffect } from "react";
 import { useToast } from "@/hooks/use-toast";
-import { login, logout, verifyToken, refreshToken } from "@/services/api";
+import { login, loginTwoFactor, logout, verifyToken, refreshToken } from "@/services/api";
 import { useNavigate, useLocation } from "react-router-dom";
 import { LoginResponse } from "@/types";
 
@@ -8,7 +8,10 @@ interface AuthContextType {
   isAuthenticated: boolean;
   username: string | null;
   isLoading: boolean;
+  twoFactorPending: boolean;
   login: (username: string, password: string) => Promise<void>;
+  verifyTwoFactor: (code: string, isRecoveryCode: boolean) => Promise<void>;
+  cancelTwoFactor: () => void;
   logout: () => Promise<void>;
 }
 
@@ -18,6 +21,7 @@ export const AuthProvider = ({ children }: { children: React.ReactNode }) => {
   const [isAuthenticated, setIsAuthenticated] = useState(false);
   const [username, setUsername] = useState<string | null>(null);
   const [isLoading, setIsLoading] = useState(true);
+  const [challengeToken, setChallengeToken] = useState<string | null>(null);
   const { toast } = useToast();
   const navigate = useNavigate();
   const location = useLocation();
@@ -103,17 +107,27 @@ export const AuthProvider = ({ children }: { children: React.ReactNode }) => {
     };
   }, [navigate, location.pathname]);
 
+  const completeLogin = (response: LoginResponse) => {
+    setChallengeToken(null);
+    setIsAuthenticated(true);
+    setUsername(response.username);
+    toast({
+      title: "Welcome back!",
+      description: `Successfully logged in as ${response.username}`,
+    });
+    navigate("/dashboard");
+  };
+
   const handleLogin = async (username: string, password: string) => {
     try {
       setIsLoading(true);
       const response = await login(username, password);
-      setIsAuthenticated(true);
-      setUsername(response.username);
-      toast({
-        title: "Welcome back!",
-        description: `Successfully logged in as ${response.username}`,
-      });
-      navigate("/dashboard");
+      if ("two_factor_required" in response) {
+        // The login form asks for the code next
+        setChallengeToken(response.challenge_token);
+        return;
+      }
+      completeLogin(response);
     } catch (error) {
       console.error("Login error:", error);
       setIsAuthenticated(false);
@@ -124,6 +138,26 @@ export const AuthProvider = ({ children }: { children: React.ReactNode }) => {
     }
   };
 
+  const handleVerifyTwoFactor = async (code: string, isRecoveryCode: boolean) => {
+    if (!challengeToken) {
+      return;
+    }
+    try {
+      setIsLoading(true);
+      const response = await loginTwoFactor(challengeToken, code, isRecoveryCode);
+      completeLogin(response);
+    } catch (error) {
+      console.error("Two-factor login error:", error);
+      // Expired challenges and too many attempts need the password again
+      if (error instanceof Error && /challenge|too many/i.test(error.message)) {
+        setChallengeToken(null);
+      }
+      throw error;
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
   const handleLogout = async () => {
     try {
       setIsLoading(true);
@@ -147,7 +181,10 @@ export const AuthProvider = ({ children }: { children: React.ReactNode }) => {
     isAuthenticated,
     username,
     isLoading,
+    twoFactorPending: challengeToken !== null,
     login: handleLogin,
+    verifyTwoFactor: handleVerifyTwoFactor,
+    cancelTwoFactor: () => setChallengeToken(null),
     logout: handleLogout,
   };
 
@@ -197,13 +197,8 @@ const ImageDetails = () => {
       setImage({
         ...updatedImage,
         url:
-          isPrivate && password
-            ? `/${updatedImage.uuid}.${updatedImage.extension}?key=${btoa(
-                password
-              )}`
-            : `/${updatedImage.uuid}.${updatedImage.extension}`,
+          updatedImage.url ?? `/${updatedImage.uuid}.${updatedImage.extension}`,
         isPrivate: updatedImage.is_private ?? updatedImage.isPrivate,
-        privateKey: updatedImage.private_key ?? updatedImage.privateKey ?? "",
       });
 
       toast({
@@ -263,12 +258,10 @@ const ImageDetails = () => {
 
   const handleShare = () => {
     if (!image) return;
-    const url =
-      image.isPrivate && image.privateKey
-        ? `${window.location.origin}/${image.uuid}.${
-            image.extension
-          }?key=${btoa(image.privateKey)}`
-        : `${window.location.origin}/${image.uuid}.${image.extension}`;
+    // Private images come with a signed link from the API
+    const url = `${window.location.origin}${
+      image.url || `/${image.uuid}.${image.extension}`
+    }`;
     navigator.clipboard.writeText(url);
     toast({
       title: "Link copied",
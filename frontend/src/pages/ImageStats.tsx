@@ -3,15 +3,16 @@ import { Button } from "@/components/ui/button";
 import { ArrowLeft, Eye, Globe, Monitor } from "lucide-react";
 import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
 import { useState, useEffect } from "react";
-import { Image, ImageView } from "@/types";
+import { Image, ImageView, ViewAggregate } from "@/types";
 import { useToast } from "@/hooks/use-toast";
-import { getImageById, getImageViews } from "@/services/api";
+import { getImageById, getImageStats } from "@/services/api";
 import AuthenticatedImage from "@/components/Images/AuthenticatedImage";
 
 const ImageStats = () => {
   const { id } = useParams<{ id: string }>();
   const [image, setImage] = useState<Image | null>(null);
   const [views, setViews] = useState<ImageView[]>([]);
+  const [viewsByDay, setViewsByDay] = useState<ViewAggregate[]>([]);
   const [isLoading, setIsLoading] = useState(true);
   const navigate = useNavigate();
   const { toast } = useToast();
@@ -22,13 +23,14 @@ const ImageStats = () => {
 
       try {
         setIsLoading(true);
-        const [imageData, viewsData] = await Promise.all([
+        const [imageData, statsData] = await Promise.all([
           getImageById(parseInt(id)),
-          getImageViews(parseInt(id)),
+          getImageStats(parseInt(id)),
         ]);
 
         setImage(imageData);
-        setViews(viewsData);
+        setViews(statsData.views);
+        setViewsByDay(statsData.views_by_day ?? []);
       } catch (error) {
         console.error("Error fetching image stats:", error);
         toast({
@@ -77,10 +79,10 @@ const ImageStats = () => {
     });
   };
 
-  // Group views by country
-  const countryViews = views.reduce((acc, view) => {
-    const country = view.country || "Unknown";
-    acc[country] = (acc[country] || 0) + 1;
+  // Group views by country, including views older than the retention period
+  const countryViews = viewsByDay.reduce((acc, day) => {
+    const country = day.country_name || "Unknown";
+    acc[country] = (acc[country] || 0) + day.views;
     return acc;
   }, {} as Record<string, number>);
 
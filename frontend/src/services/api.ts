@@ -1,4 +1,4 @@
-import { Image, ViewsData, DiskUsage, CountryViews, ImageView, UploadResponse, LoginRequest, LoginResponse, ErrorResponse, RecentView, RecentViewsResponse, PaginatedResponse, DashboardStats, Config, RefreshTokenResponse } from "@/types";
+import { Image, ViewsData, DiskUsage, CountryViews, ImageView, UploadResponse, LoginRequest, LoginResponse, ErrorResponse, RecentView, RecentViewsResponse, PaginatedResponse, DashboardStats, Config, RefreshTokenResponse, ViewAggregate, TwoFactorChallenge } from "@/types";
 
 const API_BASE_URL = "/api";
 
@@ -61,7 +61,7 @@ const getCsrfToken = (): string | null => {
 };
 
 // Auth endpoints
-export const login = async (username: string, password: string): Promise<LoginResponse> => {
+export const login = async (username: string, password: string): Promise<LoginResponse | TwoFactorChallenge> => {
   const response = await fetch(`${API_BASE_URL}/login`, {
     method: "POST",
     headers: {
@@ -71,9 +71,38 @@ export const login = async (username: string, password: string): Promise<LoginRe
     credentials: "include",
   });
 
-  const data = await handleResponse<LoginResponse>(response);
-  
+  const data = await handleResponse<LoginResponse | TwoFactorChallenge>(response);
+
+  // Accounts with two-factor enabled get a challenge for the second step
+  if ("two_factor_required" in data && data.two_factor_required && data.challenge_token) {
+    return data;
+  }
+
   // Validate response data
+  if (!("username" in data) || !data.username) {
+    throw new Error("Invalid response data from server");
+  }
+
+  return data;
+};
+
+// Second login step, with a code from the authenticator app or a recovery code
+export const loginTwoFactor = async (challengeToken: string, code: string, isRecoveryCode: boolean): Promise<LoginResponse> => {
+  const response = await fetch(`${API_BASE_URL}/login/2fa`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(
+      isRecoveryCode
+        ? { challenge_token: challengeToken, recovery_code: code }
+        : { challenge_token: challengeToken, code }
+    ),
+    credentials: "include",
+  });
+
+  const data = await handleResponse<LoginResponse>(response);
+
   if (!data.username) {
     throw new Error("Invalid response data from server");
   }
@@ -83,7 +112,7 @@ export const login = async (username: string, password: string): Promise<LoginRe
 
 export const logout = async (): Promise<void> => {
   return handleCSRFError(async () => {
-    const response = await fetch(`${API_BASE_URL}/logout`, {
+    const response = await apiFetch(`${API_BASE_URL}/logout`, {
       method: "POST",
       headers: getAuthHeaders(),
       credentials: "include",
@@ -96,7 +125,7 @@ export const logout = async (): Promise<void> => {
 };
 
 export const verifyToken = async (): Promise<{ username: string }> => {
-  const response = await fetch(`${API_BASE_URL}/verify`, {
+  const response = await apiFetch(`${API_BASE_URL}/verify`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -104,17 +133,48 @@ export const verifyToken = async (): Promise<{ username: string }> => {
   return handleResponse<{ username: string }>(response);
 };
 
-export const refreshToken = async (): Promise<RefreshTokenResponse> => {
-  const response = await fetch(`${API_BASE_URL}/refresh`, {
-    method: 'POST',
-    credentials: 'include',
-    headers: {
-      'Content-Type': 'application/json',
-      'X-CSRF-Token': getCsrfToken() || '',
-    },
-  });
+// Refresh tokens are single use, so concurrent callers share one request
+let refreshInFlight: Promise<RefreshTokenResponse> | null = null;
+
+export const refreshToken = (): Promise<RefreshTokenResponse> => {
+  if (!refreshInFlight) {
+    refreshInFlight = (async () => {
+      const response = await fetch(`${API_BASE_URL}/refresh`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: {
+          'Content-Type': 'application/json',
+          'X-CSRF-Token': getCsrfToken() || '',
+        },
+      });
+
+      return handleResponse<RefreshTokenResponse>(response);
+    })().finally(() => {
+      refreshInFlight = null;
+    });
+  }
+  return refreshInFlight;
+};
+
+// Helper function that renews an expired access token once and retries the request
+const apiFetch = async (url: string, init: RequestInit): Promise<Response> => {
+  const response = await fetch(url, init);
+  if (response.status !== 401) {
+    return response;
+  }
 
-  return handleResponse<RefreshTokenResponse>(response);
+  try {
+    await refreshToken();
+  } catch {
+    return response;
+  }
+
+  // The refresh also rotated the CSRF token
+  const headers = new Headers(init.headers);
+  if (headers.has('X-CSRF-Token')) {
+    headers.set('X-CSRF-Token', getCsrfToken() || '');
+  }
+  return fetch(url, { ...init, headers });
 };
 
 // Image endpoints
@@ -143,7 +203,7 @@ export const getImages = async (
   const queryString = params.toString();
   const url = `${API_BASE_URL}/list${queryString ? `?${queryString}` : ""}`;
 
-  const response = await fetch(url, {
+  const response = await apiFetch(url, {
     headers: getAuthHeaders(),
     credentials: "include",
   });
@@ -152,7 +212,7 @@ export const getImages = async (
 };
 
 export const getImage = async (uuid: string): Promise<Image> => {
-  const response = await fetch(`${API_BASE_URL}/images/${uuid}`, {
+  const response = await apiFetch(`${API_BASE_URL}/images/${uuid}`, {
     headers: getAuthHeaders(),
     credentials: "include",
   });
@@ -161,7 +221,7 @@ export const getImage = async (uuid: string): Promise<Image> => {
 
 export const deleteImage = async (uuid: string): Promise<void> => {
   return handleCSRFError(async () => {
-    const response = await fetch(`${API_BASE_URL}/delete/${uuid}`, {
+    const response = await apiFetch(`${API_BASE_URL}/delete/${uuid}`, {
       method: "DELETE",
       headers: getAuthHeaders(),
       credentials: "include",
@@ -172,7 +232,7 @@ export const deleteImage = async (uuid: string): Promise<void> => {
 
 // Stats endpoints
 export const getDashboardStats = async (): Promise<DashboardStats> => {
-  const response = await fetch(`${API_BASE_URL}/stats/dashboard`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/dashboard`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -181,7 +241,7 @@ export const getDashboardStats = async (): Promise<DashboardStats> => {
 };
 
 export const getViewsData = async (): Promise<ViewsData[]> => {
-  const response = await fetch(`${API_BASE_URL}/stats/views`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/views`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -190,7 +250,7 @@ export const getViewsData = async (): Promise<ViewsData[]> => {
 };
 
 export const getCountryViews = async (): Promise<CountryViews[]> => {
-  const response = await fetch(`${API_BASE_URL}/stats/country-views`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/country-views`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -199,7 +259,7 @@ export const getCountryViews = async (): Promise<CountryViews[]> => {
 };
 
 export const getRecentViews = async (): Promise<RecentViewsResponse> => {
-  const response = await fetch(`${API_BASE_URL}/stats/recent-views`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/recent-views`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -208,7 +268,7 @@ export const getRecentViews = async (): Promise<RecentViewsResponse> => {
 };
 
 export const getDiskUsage = async (): Promise<DiskUsage> => {
-  const response = await fetch(`${API_BASE_URL}/stats/disk-usage`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/disk-usage`, {
     method: "GET",
     headers: getAuthHeaders(),
     credentials: "include",
@@ -218,7 +278,7 @@ export const getDiskUsage = async (): Promise<DiskUsage> => {
 
 // Image-specific stats
 export const getImageById = async (id: number): Promise<Image> => {
-  const response = await fetch(`${API_BASE_URL}/stats/${id}`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/${id}`, {
     headers: getAuthHeaders(),
     credentials: "include",
   });
@@ -227,7 +287,7 @@ export const getImageById = async (id: number): Promise<Image> => {
 
 export const updateImagePrivacy = async (id: number, isPrivate: boolean, password?: string): Promise<Image> => {
   return handleCSRFError(async () => {
-    const response = await fetch(`${API_BASE_URL}/privacy/${id}`, {
+    const response = await apiFetch(`${API_BASE_URL}/privacy/${id}`, {
       method: "POST",
       headers: getAuthHeaders(),
       credentials: "include",
@@ -241,7 +301,7 @@ export const updateImagePrivacy = async (id: number, isPrivate: boolean, passwor
 };
 
 export const getImageViews = async (id: number): Promise<ImageView[]> => {
-  const response = await fetch(`${API_BASE_URL}/stats/${id}`, {
+  const response = await apiFetch(`${API_BASE_URL}/stats/${id}`, {
     headers: {
       ...getAuthHeaders(),
     },
@@ -251,17 +311,17 @@ export const getImageViews = async (id: number): Promise<ImageView[]> => {
   return data.views;
 };
 
-export const getImageStats = async (id: number): Promise<{ image: Image, views: ImageView[] }> => {
-  const response = await fetch(`${API_BASE_URL}/stats/${id}`, {
+export const getImageStats = async (id: number): Promise<{ image: Image, views: ImageView[], views_by_day: ViewAggregate[] }> => {
+  const response = await apiFetch(`${API_BASE_URL}/stats/${id}`, {
     headers: {
       ...getAuthHeaders(),
     },
   });
-  return handleResponse<{ image: Image, views: ImageView[] }>(response);
+  return handleResponse<{ image: Image, views: ImageView[], views_by_day: ViewAggregate[] }>(response);
 };
 
 export const getConfig = async (): Promise<Config> => {
-  const response = await fetch(`${API_BASE_URL}/config`, {
+  const response = await apiFetch(`${API_BASE_URL}/config`, {
     headers: {
       ...getAuthHeaders(),
     },
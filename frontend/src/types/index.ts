@@ -52,6 +52,13 @@ export interface ImageView {
   viewedAt?: string;
 }
 
+export interface ViewAggregate {
+  date: string;
+  country_name: string;
+  country_code: string;
+  views: number;
+}
+
 export interface Visitor {
   id: number;
   ip: string;
@@ -70,12 +77,10 @@ export interface Image {
   size: number;
   uploadedAt: string;
   isPrivate: boolean;
-  privateKey?: string;
   views: number;
   url: string;
   full_link?: string;
   is_private?: boolean;
-  private_key?: string;
 }
 
 export interface UploadResponse {
@@ -84,7 +89,6 @@ export interface UploadResponse {
   extension: string;
   size: number;
   isPrivate: boolean;
-  privateKey?: string;
   url: string;
 }
 
@@ -97,6 +101,13 @@ export interface LoginResponse {
   username: string;
 }
 
+// Returned by the password step when the account has two-factor enabled
+export interface TwoFactorChallenge {
+  two_factor_required: true;
+  challenge_token: string;
+  expires_in: number;
+}
+
 export interface ErrorResponse {
   error: string;
 }